@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Журнал аудита. Как и для отмены, на таблицы вешаются триггеры,
+// которые пишут каждое изменение строки в audit_log вместе со значениями
+// до и после. Имя оператора триггеры не знают, его проставляет
+// recordUndoStep, которым завершается каждая операция
+
+// Таблицы, изменения в которых попадают в журнал
+var auditTables = []string{
+	"athletes", "teams", "team_members",
+	"competitions", "competition_athletes", "competition_teams",
+}
+
+// Больше записей за раз не показывается, журнал растёт без ограничений
+const maxAuditEntries = 1000
+
+const (
+	AuditInsert = "insert"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+var auditActions = []string{AuditInsert, AuditUpdate, AuditDelete}
+
+type AuditEntry struct {
+	ID int
+	Time time.Time
+	Operator string
+	Table string
+	Action string
+	OldValues string
+	NewValues string
+}
+
+// Пустые поля не фильтруют. From и To — границы по дате включительно
+type AuditFilter struct {
+	Table string
+	Action string
+	Operator string
+	Search string
+	From time.Time
+	To time.Time
+}
+
+// Кем подписываются изменения: флаг --operator, переменная окружения или
+// имя пользователя в системе
+var operator string
+
+func defaultOperator() string {
+	if name := os.Getenv(operatorEnv); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+func auditActionName(action string) string {
+	switch action {
+	case AuditInsert: return "Добавление"
+	case AuditUpdate: return "Изменение"
+	case AuditDelete: return "Удаление"
+	default: return action
+	}
+}
+
+func auditTableName(table string) string {
+	switch table {
+	case "athletes": return "Спортсмены"
+	case "teams": return "Команды"
+	case "team_members": return "Составы команд"
+	case "competitions": return "Соревнования"
+	case "competition_athletes": return "Результаты спортсменов"
+	case "competition_teams": return "Результаты команд"
+	default: return table
+	}
+}
+
+func installAuditTriggers(ctx context.Context, d *sql.DB) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := dropTriggers(ctx, tx, "audit"); err != nil {
+		return err
+	}
+
+	for _, table := range auditTables {
+		columns, _, err := tableColumns(ctx, tx, table)
+		if err != nil {
+			return err
+		}
+		for _, trigger := range auditTriggers(table, columns) {
+			if _, err := tx.ExecContext(ctx, trigger); err != nil {
+				return fmt.Errorf("failed to create audit trigger on %s: %s", table, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func auditTriggers(table string, columns []string) []string {
+	var oldPairs, newPairs []string
+	for _, c := range columns {
+		oldPairs = append(oldPairs, fmt.Sprintf("'%s', OLD.%s", c, c))
+		newPairs = append(newPairs, fmt.Sprintf("'%s', NEW.%s", c, c))
+	}
+	oldValues := "json_object(" + strings.Join(oldPairs, ", ") + ")"
+	newValues := "json_object(" + strings.Join(newPairs, ", ") + ")"
+
+	return []string{
+		fmt.Sprintf(`CREATE TRIGGER audit_%[1]s_insert AFTER INSERT ON %[1]s BEGIN
+			INSERT INTO audit_log (table_name, action, new_values) VALUES ('%[1]s', 'insert', %[2]s);
+		END;`, table, newValues),
+		// UPDATE, который ничего не поменял, в журнал не попадает
+		fmt.Sprintf(`CREATE TRIGGER audit_%[1]s_update AFTER UPDATE ON %[1]s
+		WHEN %[2]s IS NOT %[3]s BEGIN
+			INSERT INTO audit_log (table_name, action, old_values, new_values) VALUES ('%[1]s', 'update', %[2]s, %[3]s);
+		END;`, table, oldValues, newValues),
+		fmt.Sprintf(`CREATE TRIGGER audit_%[1]s_delete AFTER DELETE ON %[1]s BEGIN
+			INSERT INTO audit_log (table_name, action, old_values) VALUES ('%[1]s', 'delete', %[2]s);
+		END;`, table, oldValues),
+	}
+}
+
+// Подписывает именем оператора записи, сделанные с прошлой операции
+func signAuditEntries(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, "UPDATE audit_log SET operator = ? WHERE operator IS NULL;", operator)
+	return err
+}
+
+// Записи журнала, самые новые первыми
+func (s *sqliteStore) getAuditLog(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	var entries []AuditEntry
+
+	var where []string
+	var args []any
+	if filter.Table != "" {
+		where = append(where, "table_name = ?")
+		args = append(args, filter.Table)
+	}
+	if filter.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Operator != "" {
+		where = append(where, "operator LIKE ?")
+		args = append(args, "%"+filter.Operator+"%")
+	}
+	if filter.Search != "" {
+		where = append(where, "(old_values LIKE ? OR new_values LIKE ?)")
+		args = append(args, "%"+filter.Search+"%", "%"+filter.Search+"%")
+	}
+	// time хранится в UTC, а даты в фильтре местные
+	if !filter.From.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, filter.From.UTC().Format(time.DateTime))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "time < ?")
+		args = append(args, filter.To.AddDate(0, 0, 1).UTC().Format(time.DateTime))
+	}
+
+	query := "SELECT id, time, coalesce(operator, ''), table_name, action, coalesce(old_values, ''), coalesce(new_values, '') FROM audit_log"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT %d;", maxAuditEntries)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := AuditEntry{}
+		if err := rows.Scan(&e.ID, &e.Time, &e.Operator, &e.Table, &e.Action, &e.OldValues, &e.NewValues); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+type auditValue struct {
+	Column string
+	Value string
+}
+
+// Разбирает объект JSON из журнала, сохраняя порядок столбцов
+func parseAuditValues(s string) []auditValue {
+	var values []auditValue
+	if s == "" {
+		return nil
+	}
+
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil {
+		return nil
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return values
+		}
+		value, err := dec.Token()
+		if err != nil {
+			return values
+		}
+		column, _ := key.(string)
+		values = append(values, auditValue{column, formatAuditValue(column, value)})
+	}
+	return values
+}
+
+// Время и дни рождения приложение хранит в секундах Unix
+func formatAuditValue(column string, value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case json.Number:
+		if n, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+			switch column {
+			case "birthday": return time.Unix(n, 0).UTC().Format(time.DateOnly)
+			case "time": return time.Unix(n, 0).UTC().Format("2006-01-02 15:04")
+			}
+		}
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Значения до и после изменения в виде "столбец: значение, ...". У
+// изменённой строки показываются только столбцы, которые поменялись
+func (e AuditEntry) Changes() (string, string) {
+	oldValues := parseAuditValues(e.OldValues)
+	newValues := parseAuditValues(e.NewValues)
+
+	if e.Action == AuditUpdate && len(oldValues) == len(newValues) {
+		var oldChanged, newChanged []auditValue
+		for i := range oldValues {
+			if oldValues[i] != newValues[i] {
+				oldChanged = append(oldChanged, oldValues[i])
+				newChanged = append(newChanged, newValues[i])
+			}
+		}
+		oldValues, newValues = oldChanged, newChanged
+	}
+
+	return joinAuditValues(oldValues), joinAuditValues(newValues)
+}
+
+func joinAuditValues(values []auditValue) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = v.Column + ": " + v.Value
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Дата для фильтра по журналу, пустая строка — без ограничения
+func parseAuditDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("некорректная дата %q, нужно ГГГГ-ММ-ДД", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Снимки базы складываются в каталог backups рядом с файлом базы и
+// называются db-20250102-150405.000-startup.sqlite3. Хранятся только
+// последние maxSnapshots снимков каждой базы с каждой причиной: иначе
+// снимки при запуске со временем вытеснили бы снимки перед миграцией,
+// импортом или восстановлением
+const maxSnapshots = 20
+
+const snapshotTimeLayout = "20060102-150405.000"
+
+type Snapshot struct {
+	Path string
+	Time time.Time
+	Reason string
+}
+
+func snapshotDir(path string) string {
+	return filepath.Join(filepath.Dir(path), "backups")
+}
+
+func snapshotPrefix(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + "-"
+}
+
+// Копирует базу src в файл dest через online backup API: копия
+// согласованная, даже если в базу в это время пишут
+func backupDatabase(ctx context.Context, src *sql.DB, dest string) error {
+	destDB, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=rwc", dest))
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+
+	return copyDatabase(ctx, src, destDB)
+}
+
+func copyDatabase(ctx context.Context, src *sql.DB, dest *sql.DB) error {
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destRaw any) error {
+		return srcConn.Raw(func(srcRaw any) error {
+			b, err := destRaw.(*sqlite3.SQLiteConn).Backup("main", srcRaw.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
+
+// Снимок базы d, открытой из файла path. reason попадает в имя файла,
+// чтобы было видно, перед чем снимок сделан
+func takeSnapshot(ctx context.Context, d *sql.DB, path string, reason string) (string, error) {
+	dir := snapshotDir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	name := snapshotPrefix(path) + time.Now().Format(snapshotTimeLayout) + "-" + reason + ".sqlite3"
+	dest := filepath.Join(dir, name)
+	if err := backupDatabase(ctx, d, dest); err != nil {
+		os.Remove(dest)
+		return "", fmt.Errorf("не удалось сделать снимок базы: %w", err)
+	}
+
+	return dest, pruneSnapshots(path)
+}
+
+// Снимок базы перед операцией, которая может многое удалить или
+// переписать. У базы в памяти (в тестах) файла нет, и снимок не нужен
+func (s *sqliteStore) snapshot(ctx context.Context, reason string) (string, error) {
+	if s.path == "" {
+		return "", nil
+	}
+	return takeSnapshot(ctx, s.db, s.path, reason)
+}
+
+// Снимки базы path, самые свежие первыми
+func listSnapshots(path string) ([]Snapshot, error) {
+	var snapshots []Snapshot
+
+	entries, err := os.ReadDir(snapshotDir(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	prefix := snapshotPrefix(path)
+	for _, e := range entries {
+		name, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || !strings.HasSuffix(name, ".sqlite3") || len(name) <= len(snapshotTimeLayout) {
+			continue
+		}
+		t, err := time.ParseInLocation(snapshotTimeLayout, name[:len(snapshotTimeLayout)], time.Local)
+		if err != nil {
+			continue
+		}
+		reason := strings.TrimSuffix(strings.TrimPrefix(name[len(snapshotTimeLayout):], "-"), ".sqlite3")
+
+		snapshots = append(snapshots, Snapshot{filepath.Join(snapshotDir(path), e.Name()), t, reason})
+	}
+
+	slices.SortFunc(snapshots, func(a, b Snapshot) int { return b.Time.Compare(a.Time) })
+	return snapshots, nil
+}
+
+func pruneSnapshots(path string) error {
+	snapshots, err := listSnapshots(path)
+	if err != nil {
+		return err
+	}
+	kept := make(map[string]int)
+	for _, s := range snapshots {
+		if kept[s.Reason] < maxSnapshots {
+			kept[s.Reason]++
+			continue
+		}
+		if err := os.Remove(s.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Заменяет содержимое базы копией из файла src. Перед этим делается
+// снимок, так что восстановление тоже можно откатить
+func (s *sqliteStore) restoreBackup(ctx context.Context, src string) error {
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+
+	srcDB, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", src))
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	var version int
+	if err := srcDB.QueryRowContext(ctx, "PRAGMA user_version;").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("версия схемы в копии %d новее поддерживаемой %d", version, len(migrations))
+	}
+
+	if _, err := s.snapshot(ctx, "before-restore"); err != nil {
+		return err
+	}
+	if err := copyDatabase(ctx, srcDB, s.db); err != nil {
+		return err
+	}
+
+	// Копия могла быть сделана до последних миграций
+	if err := dbMigrate(ctx, s.db, s.path); err != nil {
+		return err
+	}
+	return installTriggers(ctx, s.db)
+}
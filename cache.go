@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Виды данных, об изменении которых сообщает cachedStore. Изменение
+// может затрагивать сразу несколько видов: например, смена кода страны
+// меняет и спортсменов, и команды
+type DataKind int
+
+const (
+	DataCountries DataKind = 1 << iota
+	DataSports
+	DataEvents
+	DataAthletes
+	DataSites
+	DataTeams
+	DataCompetitions
+	DataResults
+
+	DataAll = DataCountries | DataSports | DataEvents | DataAthletes | DataSites | DataTeams | DataCompetitions | DataResults
+)
+
+// Кэш справочников (страны, виды спорта, дисциплины, места, спортсмены) поверх
+// другого хранилища. Остальные чтения идут в него напрямую. Все
+// изменения через cachedStore сбрасывают затронутые данные в кэше и
+// рассылают уведомления подписчикам. Об изменениях в обход кэша (отмена,
+// импорт, восстановление из снимка) нужно сообщать через notify
+type cachedStore struct {
+	Store
+
+	mu sync.Mutex
+	loaded DataKind
+	countries []Country
+	sports []Sport
+	events []Event
+	athletes []Athlete
+	sites []Site
+
+	listeners []func(DataKind)
+}
+
+func newCachedStore(store Store) *cachedStore {
+	return &cachedStore{Store: store}
+}
+
+func (c *cachedStore) onChange(listener func(DataKind)) {
+	c.listeners = append(c.listeners, listener)
+}
+
+func (c *cachedStore) notify(kinds DataKind) {
+	c.mu.Lock()
+	c.loaded &^= kinds
+	c.mu.Unlock()
+
+	for _, listener := range c.listeners {
+		listener(kinds)
+	}
+}
+
+// Если изменение прошло, то уведомляет о нём, ошибку возвращает как есть
+func (c *cachedStore) changed(err error, kinds DataKind) error {
+	if err == nil {
+		c.notify(kinds)
+	}
+	return err
+}
+
+// Возвращает копию, чтобы вызывающий мог сортировать и менять список
+func cached[T any](c *cachedStore, kind DataKind, list *[]T, load func() ([]T, error)) ([]T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.loaded&kind == 0 {
+		items, err := load()
+		if err != nil {
+			return nil, err
+		}
+		*list = items
+		c.loaded |= kind
+	}
+	return slices.Clone(*list), nil
+}
+
+func (c *cachedStore) getCountries(ctx context.Context) ([]Country, error) {
+	return cached(c, DataCountries, &c.countries, func() ([]Country, error) {
+		return c.Store.getCountries(ctx)
+	})
+}
+
+func (c *cachedStore) getSports(ctx context.Context) ([]Sport, error) {
+	return cached(c, DataSports, &c.sports, func() ([]Sport, error) {
+		return c.Store.getSports(ctx)
+	})
+}
+
+func (c *cachedStore) getEvents(ctx context.Context) ([]Event, error) {
+	return cached(c, DataEvents, &c.events, func() ([]Event, error) {
+		return c.Store.getEvents(ctx)
+	})
+}
+
+func (c *cachedStore) getAthletes(ctx context.Context) ([]Athlete, error) {
+	return cached(c, DataAthletes, &c.athletes, func() ([]Athlete, error) {
+		return c.Store.getAthletes(ctx)
+	})
+}
+
+func (c *cachedStore) getSites(ctx context.Context) ([]Site, error) {
+	return cached(c, DataSites, &c.sites, func() ([]Site, error) {
+		return c.Store.getSites(ctx)
+	})
+}
+
+// Код и название страны есть в спортсменах, командах и результатах,
+// поэтому изменение страны затрагивает и их. То же с остальными
+// справочниками
+
+func (c *cachedStore) addCountry(ctx context.Context, code string, name string) error {
+	return c.changed(c.Store.addCountry(ctx, code, name), DataCountries)
+}
+
+func (c *cachedStore) updateCountry(ctx context.Context, oldCode string, code string, name string) error {
+	return c.changed(c.Store.updateCountry(ctx, oldCode, code, name),
+		DataCountries|DataAthletes|DataTeams|DataResults)
+}
+
+func (c *cachedStore) deleteCountry(ctx context.Context, code string) error {
+	return c.changed(c.Store.deleteCountry(ctx, code), DataCountries)
+}
+
+func (c *cachedStore) addSport(ctx context.Context, sport Sport) error {
+	return c.changed(c.Store.addSport(ctx, sport), DataSports)
+}
+
+func (c *cachedStore) updateSport(ctx context.Context, oldCode string, sport Sport) error {
+	return c.changed(c.Store.updateSport(ctx, oldCode, sport),
+		DataSports|DataEvents|DataTeams|DataCompetitions)
+}
+
+// Дисциплины удаляются вместе с видом спорта
+func (c *cachedStore) deleteSport(ctx context.Context, code string) error {
+	return c.changed(c.Store.deleteSport(ctx, code), DataSports|DataEvents)
+}
+
+func (c *cachedStore) addEvent(ctx context.Context, e Event) error {
+	return c.changed(c.Store.addEvent(ctx, e), DataEvents)
+}
+
+func (c *cachedStore) updateEvent(ctx context.Context, e Event) error {
+	return c.changed(c.Store.updateEvent(ctx, e), DataEvents|DataCompetitions)
+}
+
+func (c *cachedStore) deleteEvent(ctx context.Context, ID int) error {
+	return c.changed(c.Store.deleteEvent(ctx, ID), DataEvents)
+}
+
+func (c *cachedStore) addAthlete(ctx context.Context, name string, isMale bool, birthday time.Time, countryCode string) error {
+	return c.changed(c.Store.addAthlete(ctx, name, isMale, birthday, countryCode), DataAthletes)
+}
+
+func (c *cachedStore) updateAthlete(ctx context.Context, ID int, name string, isMale bool, birthday time.Time, countryCode string) error {
+	return c.changed(c.Store.updateAthlete(ctx, ID, name, isMale, birthday, countryCode),
+		DataAthletes|DataTeams|DataResults)
+}
+
+// Спортсмен удаляется и из составов команд
+func (c *cachedStore) deleteAthlete(ctx context.Context, ID int) error {
+	return c.changed(c.Store.deleteAthlete(ctx, ID), DataAthletes|DataTeams)
+}
+
+func (c *cachedStore) addSite(ctx context.Context, name string) error {
+	return c.changed(c.Store.addSite(ctx, name), DataSites)
+}
+
+func (c *cachedStore) updateSite(ctx context.Context, ID int, name string) error {
+	return c.changed(c.Store.updateSite(ctx, ID, name), DataSites|DataCompetitions)
+}
+
+func (c *cachedStore) deleteSite(ctx context.Context, ID int) error {
+	return c.changed(c.Store.deleteSite(ctx, ID), DataSites)
+}
+
+func (c *cachedStore) addTeam(ctx context.Context, name string, countryCode string, sportCode string) error {
+	return c.changed(c.Store.addTeam(ctx, name, countryCode, sportCode), DataTeams)
+}
+
+func (c *cachedStore) updateTeam(ctx context.Context, ID int, name string, countryCode string, sportCode string) error {
+	return c.changed(c.Store.updateTeam(ctx, ID, name, countryCode, sportCode), DataTeams|DataResults)
+}
+
+func (c *cachedStore) deleteTeam(ctx context.Context, ID int) error {
+	return c.changed(c.Store.deleteTeam(ctx, ID), DataTeams)
+}
+
+func (c *cachedStore) addAthleteToTeam(ctx context.Context, teamID int, athleteID int) error {
+	return c.changed(c.Store.addAthleteToTeam(ctx, teamID, athleteID), DataTeams)
+}
+
+func (c *cachedStore) deleteAthleteFromTeam(ctx context.Context, teamID int, athleteID int) error {
+	return c.changed(c.Store.deleteAthleteFromTeam(ctx, teamID, athleteID), DataTeams)
+}
+
+func (c *cachedStore) addCompetition(ctx context.Context, t time.Time, duration int, status CompetitionStatus, eventID int, siteID int) error {
+	return c.changed(c.Store.addCompetition(ctx, t, duration, status, eventID, siteID), DataCompetitions)
+}
+
+func (c *cachedStore) updateCompetition(ctx context.Context, ID int, t time.Time, duration int, status CompetitionStatus, eventID int, siteID int,
+	stage CompetitionStage, nextStageID int, advanceCount int) error {
+	return c.changed(c.Store.updateCompetition(ctx, ID, t, duration, status, eventID, siteID,
+		stage, nextStageID, advanceCount), DataCompetitions)
+}
+
+// Результаты соревнования удаляются вместе с ним
+func (c *cachedStore) deleteCompetition(ctx context.Context, ID int) error {
+	return c.changed(c.Store.deleteCompetition(ctx, ID), DataCompetitions|DataResults)
+}
+
+func (c *cachedStore) setCompetitionStage(ctx context.Context, ID int, stage CompetitionStage, nextStageID int, advanceCount int) error {
+	return c.changed(c.Store.setCompetitionStage(ctx, ID, stage, nextStageID, advanceCount), DataCompetitions)
+}
+
+func (c *cachedStore) addResult(ctx context.Context, competitionID int, isTeam bool, participantID int, place int) error {
+	return c.changed(c.Store.addResult(ctx, competitionID, isTeam, participantID, place), DataResults)
+}
+
+func (c *cachedStore) addStageQualifiers(ctx context.Context, competitionID int) error {
+	return c.changed(c.Store.addStageQualifiers(ctx, competitionID), DataResults)
+}
+
+func (c *cachedStore) setResultPlace(ctx context.Context, r Result, place int) error {
+	return c.changed(c.Store.setResultPlace(ctx, r, place), DataResults)
+}
+
+func (c *cachedStore) deleteResult(ctx context.Context, r Result) error {
+	return c.changed(c.Store.deleteResult(ctx, r), DataResults)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCachedStore(t *testing.T) {
+	ctx := context.Background()
+	mem := newMemStore()
+	seed(t, mem)
+
+	c := newCachedStore(mem)
+	var notified []DataKind
+	c.onChange(func(kinds DataKind) { notified = append(notified, kinds) })
+
+	countries, err := c.getCountries(ctx)
+	must(t, err)
+	if len(countries) != 2 {
+		t.Fatalf("страны: %v", countries)
+	}
+
+	// Изменение в обход кэша не видно, пока о нём не сообщат
+	must(t, mem.addCountry(ctx, "CHN", "Китай"))
+	countries, err = c.getCountries(ctx)
+	must(t, err)
+	if len(countries) != 2 {
+		t.Fatalf("страны из кэша: %v", countries)
+	}
+	c.notify(DataAll)
+	countries, err = c.getCountries(ctx)
+	must(t, err)
+	if len(countries) != 3 {
+		t.Fatalf("страны после notify: %v", countries)
+	}
+
+	// Список — копия, его можно менять
+	countries[0].Name = "Изменено"
+	countries, err = c.getCountries(ctx)
+	must(t, err)
+	if countries[0].Name == "Изменено" {
+		t.Error("кэш отдал свой список, а не копию")
+	}
+
+	// Неудачное изменение ни о чём не сообщает
+	notified = nil
+	mustFail(t, c.addCountry(ctx, "RUS", "Россия"), "повтор кода")
+	if len(notified) != 0 {
+		t.Errorf("уведомления после ошибки: %v", notified)
+	}
+
+	// Смена кода страны затрагивает и спортсменов
+	_, err = c.getAthletes(ctx)
+	must(t, err)
+	must(t, c.updateCountry(ctx, "RUS", "RU", "Россия"))
+	if len(notified) != 1 || notified[0]&DataCountries == 0 || notified[0]&DataAthletes == 0 {
+		t.Errorf("уведомления о смене кода страны: %v", notified)
+	}
+	athletes, err := c.getAthletes(ctx)
+	must(t, err)
+	if athletes[0].CountryCode != "RU" {
+		t.Errorf("спортсмены из кэша после смены кода страны: %+v", athletes[0])
+	}
+
+	notified = nil
+	must(t, c.addSite(ctx, "Бассейн"))
+	if len(notified) != 1 || notified[0] != DataSites {
+		t.Errorf("уведомления о новом месте: %v", notified)
+	}
+}
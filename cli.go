@@ -0,0 +1,871 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const cliUsage = `Использование: course-db-2025 [--db ФАЙЛ] [--operator ИМЯ] [КОМАНДА]
+
+Без команды запускается графический интерфейс.
+Путь к базе также можно задать переменной окружения OLYMPIAD_DB. Если не
+задано ни то, ни другое, то интерфейс открывает последнюю использованную
+базу, а команды работают с db.sqlite3 в текущем каталоге.
+Изменения записываются в журнал от имени --operator, по умолчанию это
+переменная окружения OLYMPIAD_OPERATOR или имя пользователя в системе.
+
+Команды:
+  list СУЩНОСТЬ [--format table|csv|json|xlsx]
+      СУЩНОСТЬ: countries, sports, events, athletes, sites, teams, competitions
+  list results ID_СОРЕВНОВАНИЯ [--format table|csv|json|xlsx]
+  list qualifiers ID_СОРЕВНОВАНИЯ [--format table|csv|json|xlsx]
+      участники, прошедшие на этап с предыдущих этапов, и их места там
+  medals [--format table|csv|json|xlsx] [--all] [--by-event]
+      --by-event — призёры каждой дисциплины вместо зачёта по странам
+  add country КОД НАЗВАНИЕ
+  add sport КОД НАЗВАНИЕ [--team] [--min-players N] [--max-players N] [--gender M|F]
+      для командных видов: ограничения на размер и пол состава команд,
+      без --gender команды смешанные
+  add event КОД_СПОРТА НАЗВАНИЕ [--gender M|F] [--min-age ЛЕТ] [--max-age ЛЕТ] [--weight КАТЕГОРИЯ]
+      дисциплина вида спорта; без --gender смешанная, возраст — на день
+      соревнования, весовая категория не проверяется
+  add athlete ИМЯ M|F ГГГГ-ММ-ДД КОД_СТРАНЫ
+  add site НАЗВАНИЕ
+  add team НАЗВАНИЕ КОД_СТРАНЫ КОД_СПОРТА
+  add member ID_КОМАНДЫ ID_СПОРТСМЕНА
+  add competition "ГГГГ-ММ-ДД ЧЧ:ММ" ID_ДИСЦИПЛИНЫ ID_МЕСТА [--status СТАТУС] [--duration МИНУТ]
+      СТАТУС: scheduled (по умолчанию), in_progress, finished, cancelled
+      время — по UTC, длительность по умолчанию 120 минут
+  add result ID_СОРЕВНОВАНИЯ ID_УЧАСТНИКА [МЕСТО]
+      без места участник только записывается в стартовый лист; места
+      ставятся только завершённому соревнованию и в нём обязательны,
+      в отменённое соревнование не записывают
+  stage ID_СОРЕВНОВАНИЯ ЭТАП [--next ID_СОРЕВНОВАНИЯ] [--advance N]
+      ЭТАП: heat, quarterfinal, semifinal, final (по умолчанию у новых)
+      первые N мест (без --advance — все) проходят в этап --next той же
+      дисциплины; если на этап ведут другие, в нём выступают только
+      прошедшие отбор. Медали дают только финалы
+  advance ID_СОРЕВНОВАНИЯ
+      записать без места всех прошедших отбор на этап, кого в нём ещё нет
+  delete country|sport КОД
+  delete event|athlete|site|team|competition ID
+  delete member ID_КОМАНДЫ ID_СПОРТСМЕНА
+  delete result ID_СОРЕВНОВАНИЯ ID_УЧАСТНИКА
+      перед удалением спортсмена или соревнования делается снимок базы
+  conflicts [--format table|csv|json|xlsx]
+      спортсмены, заявленные в пересекающиеся по времени соревнования
+  free-slot ID_МЕСТА ГГГГ-ММ-ДД [--duration МИНУТ]
+      первое свободное время на месте в этот день
+  import ФАЙЛ.sql
+  import СУЩНОСТЬ ФАЙЛ.csv [--dry-run] [--map ПОЛЕ=СТОЛБЕЦ,...]
+      СУЩНОСТЬ: countries, sports, events, athletes, sites, teams, members, competitions
+      Столбцы сопоставляются по заголовкам, --map задаёт их явно
+      (заголовком или номером с единицы). Ссылки на страны, виды спорта,
+      дисциплины, места, команды и спортсменов — кодом/ID или названием.
+      Дисциплину соревнования можно не указывать, если она у вида спорта
+      одна. Даты и время — по UTC (ГГГГ-ММ-ДД, ГГГГ-ММ-ДД ЧЧ:ММ[:СС]), как
+      и в остальных командах. Если хоть одна строка с ошибкой, не
+      импортируется ничего
+  dump ФАЙЛ.json
+      все данные базы в формате JSON, "-" вместо файла — вывести в консоль
+  restore ФАЙЛ.json [--on-conflict fail|skip|overwrite]
+      загрузить дамп; если запись с таким ключом уже есть: fail — ничего
+      не загружать (по умолчанию), skip — оставить как есть, overwrite —
+      заменить данными из дампа
+  undo
+  redo
+      отменить последнее изменение или повторить отменённое
+  history
+      история изменений, которые можно отменить
+  audit [--table ТАБЛИЦА] [--action insert|update|delete] [--operator ИМЯ]
+        [--search ТЕКСТ] [--from ГГГГ-ММ-ДД] [--to ГГГГ-ММ-ДД] [--format table|csv|json|xlsx]
+      журнал изменений спортсменов, команд, соревнований и результатов,
+      самые новые первыми (не больше 1000 записей)
+      ТАБЛИЦА: athletes, teams, team_members, competitions,
+      competition_athletes, competition_teams
+  backup [ФАЙЛ]
+      копия базы, даже если она открыта в интерфейсе; без файла — снимок
+      в каталоге backups рядом с базой (хранятся последние 20 с каждой
+      причиной: startup, manual, before-import, ...)
+  snapshots
+      список снимков базы, самые свежие первыми
+  restore-backup ФАЙЛ
+      заменить базу копией, перед этим делается снимок текущей
+  export СУЩНОСТЬ ФАЙЛ [--format csv|json|xlsx]
+      СУЩНОСТЬ: любая из list, а также medals
+      формат по умолчанию определяется по расширению файла
+`
+
+func runCLI(ctx context.Context, store *sqliteStore, args []string) error {
+	cmd, args := args[0], args[1:]
+
+	switch cmd {
+	case "list": return cliList(ctx, store, args)
+	case "medals": return cliMedals(ctx, store, args)
+	case "add": return cliAdd(ctx, store, args)
+	case "delete": return cliDelete(ctx, store, args)
+	case "stage": return cliStage(ctx, store, args)
+	case "advance": return cliAdvance(ctx, store, args)
+	case "free-slot": return cliFreeSlot(ctx, store, args)
+	case "conflicts": return cliConflicts(ctx, store, args)
+	case "import": return cliImport(ctx, store, args)
+	case "export": return cliExport(ctx, store, args)
+	case "dump": return cliDump(ctx, store, args)
+	case "restore": return cliRestore(ctx, store, args)
+	case "undo": return cliUndo(ctx, store, args, false)
+	case "redo": return cliUndo(ctx, store, args, true)
+	case "history": return cliHistory(ctx, store, args)
+	case "audit": return cliAudit(ctx, store, args)
+	case "backup": return cliBackup(ctx, store, args)
+	case "snapshots": return cliSnapshots(ctx, store, args)
+	case "restore-backup": return cliRestoreBackup(ctx, store, args)
+	case "help":
+		fmt.Print(cliUsage)
+		return nil
+	default: return fmt.Errorf("неизвестная команда %q, см. help", cmd)
+	}
+}
+
+// flag останавливается на первом позиционном аргументе, а нам удобно
+// писать флаги и после него (list athletes --format csv)
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func wantArgs(args []string, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("ожидалось аргументов: %d, получено: %d", n, len(args))
+	}
+	return nil
+}
+
+func parseID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("некорректный ID %q", s)
+	}
+	return id, nil
+}
+
+// Таблица из заголовков, типов столбцов и строк, общая для list и export
+func entityTable(ctx context.Context, store *sqliteStore, entity string) ([]string, []columnType, [][]string, error) {
+	switch entity {
+	case "countries":
+		countries, err := store.getCountries(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		headers, types, rows := countriesTable(pointers(countries))
+		return headers, types, rows, nil
+	case "sports":
+		sports, err := store.getSports(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		headers, types, rows := sportsTable(pointers(sports))
+		return headers, types, rows, nil
+	case "events":
+		events, err := store.getEvents(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		headers, types, rows := eventsTable(pointers(events))
+		return headers, types, rows, nil
+	case "athletes":
+		athletes, err := store.getAthletes(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		headers, types, rows := athletesTable(pointers(athletes))
+		return headers, types, rows, nil
+	case "sites":
+		sites, err := store.getSites(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		headers, types, rows := sitesTable(pointers(sites))
+		return headers, types, rows, nil
+	case "teams":
+		teams, err := store.getTeams(ctx, true)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		headers, types, rows := teamsTable(pointers(teams))
+		return headers, types, rows, nil
+	case "competitions":
+		competitions, err := store.getCompetitions(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		headers, types, rows := competitionsTable(pointers(competitions))
+		return headers, types, rows, nil
+	case "medals":
+		medals, err := store.getCountryMedals(ctx, false)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		headers, types, rows := medalsTable(pointers(medals))
+		return headers, types, rows, nil
+	default:
+		return nil, nil, nil, fmt.Errorf("неизвестная сущность %q", entity)
+	}
+}
+
+// Кроме форматов выгрузки умеет ещё выровненную таблицу для терминала
+func writeTable(w io.Writer, format string, headers []string, types []columnType, rows [][]string) error {
+	if format != "table" {
+		return writeExport(w, format, headers, types, rows)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func cliList(ctx context.Context, store *sqliteStore, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	format := fs.String("format", "table", "формат вывода: table, csv, json или xlsx")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("не указана сущность")
+	}
+
+	var headers []string
+	var types []columnType
+	var rows [][]string
+	if args[0] == "results" || args[0] == "qualifiers" {
+		if err := wantArgs(args, 2); err != nil {
+			return err
+		}
+		var id int
+		if id, err = parseID(args[1]); err != nil {
+			return err
+		}
+		var results []Result
+		if args[0] == "results" {
+			if results, err = store.getCompetitionResults(ctx, id); err == nil {
+				headers, types, rows = resultsTable(pointers(results))
+			}
+		} else if results, err = store.getStageQualifiers(ctx, id); err == nil {
+			headers, types, rows = qualifiersTable(pointers(results))
+		}
+	} else {
+		if err := wantArgs(args, 1); err != nil {
+			return err
+		}
+		headers, types, rows, err = entityTable(ctx, store, args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeTable(os.Stdout, *format, headers, types, rows)
+}
+
+func cliMedals(ctx context.Context, store *sqliteStore, args []string) error {
+	fs := flag.NewFlagSet("medals", flag.ContinueOnError)
+	format := fs.String("format", "table", "формат вывода: table, csv, json или xlsx")
+	all := fs.Bool("all", false, "включать страны без медалей")
+	byEvent := fs.Bool("by-event", false, "призёры по дисциплинам")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(args, 0); err != nil {
+		return err
+	}
+
+	if *byEvent {
+		medals, err := store.getEventMedals(ctx)
+		if err != nil {
+			return err
+		}
+		headers, types, rows := eventMedalsTable(pointers(medals))
+		return writeTable(os.Stdout, *format, headers, types, rows)
+	}
+
+	medals, err := store.getCountryMedals(ctx, *all)
+	if err != nil {
+		return err
+	}
+	headers, types, rows := medalsTable(pointers(medals))
+
+	return writeTable(os.Stdout, *format, headers, types, rows)
+}
+
+func cliAdd(ctx context.Context, store *sqliteStore, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	team := fs.Bool("team", false, "командный вид спорта")
+	minPlayers := fs.Int("min-players", 0, "минимум игроков в команде")
+	maxPlayers := fs.Int("max-players", 0, "максимум игроков в команде")
+	gender := fs.String("gender", "", "пол участников команд вида спорта или дисциплины")
+	minAge := fs.Int("min-age", 0, "минимальный возраст в дисциплине")
+	maxAge := fs.Int("max-age", 0, "максимальный возраст в дисциплине")
+	weight := fs.String("weight", "", "весовая категория дисциплины")
+	status := fs.String("status", string(StatusScheduled), "статус соревнования")
+	duration := fs.Int("duration", 120, "длительность соревнования в минутах")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("не указана сущность")
+	}
+
+	entity, args := args[0], args[1:]
+	switch entity {
+	case "country":
+		if err := wantArgs(args, 2); err != nil {
+			return err
+		}
+		return store.addCountry(ctx, args[0], args[1])
+	case "sport":
+		if err := wantArgs(args, 2); err != nil {
+			return err
+		}
+		teamGender, err := parseTeamGender(*gender)
+		if err != nil {
+			return err
+		}
+		return store.addSport(ctx, Sport{Code: args[0], Name: args[1], IsTeam: *team,
+			MinPlayers: *minPlayers, MaxPlayers: *maxPlayers, TeamGender: teamGender})
+	case "event":
+		if err := wantArgs(args, 2); err != nil {
+			return err
+		}
+		eventGender, err := parseTeamGender(*gender)
+		if err != nil {
+			return err
+		}
+		return store.addEvent(ctx, Event{SportCode: args[0], Name: args[1], Gender: eventGender,
+			MinAge: *minAge, MaxAge: *maxAge, WeightClass: *weight})
+	case "athlete":
+		if err := wantArgs(args, 4); err != nil {
+			return err
+		}
+		isMale, err := parseGender(args[1])
+		if err != nil {
+			return err
+		}
+		birthday, err := time.Parse(time.DateOnly, args[2])
+		if err != nil {
+			return err
+		}
+		return store.addAthlete(ctx, args[0], isMale, birthday, args[3])
+	case "site":
+		if err := wantArgs(args, 1); err != nil {
+			return err
+		}
+		return store.addSite(ctx, args[0])
+	case "team":
+		if err := wantArgs(args, 3); err != nil {
+			return err
+		}
+		return store.addTeam(ctx, args[0], args[1], args[2])
+	case "member":
+		if err := wantArgs(args, 2); err != nil {
+			return err
+		}
+		teamID, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		athleteID, err := parseID(args[1])
+		if err != nil {
+			return err
+		}
+		return store.addAthleteToTeam(ctx, teamID, athleteID)
+	case "competition":
+		if err := wantArgs(args, 3); err != nil {
+			return err
+		}
+		t, err := time.Parse("2006-01-02 15:04", args[0])
+		if err != nil {
+			return err
+		}
+		eventID, err := parseID(args[1])
+		if err != nil {
+			return err
+		}
+		siteID, err := parseID(args[2])
+		if err != nil {
+			return err
+		}
+		return store.addCompetition(ctx, t, *duration, CompetitionStatus(*status), eventID, siteID)
+	case "result":
+		if len(args) != 2 {
+			if err := wantArgs(args, 3); err != nil {
+				return err
+			}
+		}
+		competition, err := findCompetition(ctx, store, args[0])
+		if err != nil {
+			return err
+		}
+		participantID, err := parseID(args[1])
+		if err != nil {
+			return err
+		}
+		// Без места — запись в стартовый лист
+		place := 0
+		if len(args) == 3 {
+			if place, err = strconv.Atoi(args[2]); err != nil || place <= 0 {
+				return fmt.Errorf("некорректное место %q", args[2])
+			}
+		}
+		return store.addResult(ctx, competition.ID, competition.Sport.IsTeam, participantID, place)
+	default:
+		return fmt.Errorf("неизвестная сущность %q", entity)
+	}
+}
+
+func cliStage(ctx context.Context, store *sqliteStore, args []string) error {
+	fs := flag.NewFlagSet("stage", flag.ContinueOnError)
+	next := fs.Int("next", 0, "ID следующего этапа")
+	advance := fs.Int("advance", 0, "сколько первых мест проходит в следующий этап")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(args, 2); err != nil {
+		return err
+	}
+
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	stage := CompetitionStage(args[1])
+	if !slices.Contains(competitionStages, stage) {
+		return fmt.Errorf("неизвестный этап %q", args[1])
+	}
+	return store.setCompetitionStage(ctx, id, stage, *next, *advance)
+}
+
+func cliAdvance(ctx context.Context, store *sqliteStore, args []string) error {
+	if err := wantArgs(args, 1); err != nil {
+		return err
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	return store.addStageQualifiers(ctx, id)
+}
+
+func cliFreeSlot(ctx context.Context, store *sqliteStore, args []string) error {
+	fs := flag.NewFlagSet("free-slot", flag.ContinueOnError)
+	duration := fs.Int("duration", 120, "длительность соревнования в минутах")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(args, 2); err != nil {
+		return err
+	}
+
+	siteID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	day, err := time.Parse(time.DateOnly, args[1])
+	if err != nil {
+		return err
+	}
+
+	slot, err := store.findFreeSlot(ctx, siteID, day, *duration)
+	if err != nil {
+		return err
+	}
+	fmt.Println(slot.Format("2006-01-02 15:04"))
+	return nil
+}
+
+func cliConflicts(ctx context.Context, store *sqliteStore, args []string) error {
+	fs := flag.NewFlagSet("conflicts", flag.ContinueOnError)
+	format := fs.String("format", "table", "формат вывода: table, csv, json или xlsx")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(args, 0); err != nil {
+		return err
+	}
+
+	conflicts, err := store.getAthleteConflicts(ctx)
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, c := range conflicts {
+		rows = append(rows, []string{c.AthleteName,
+			strconv.Itoa(c.First.ID), c.First.Time.Format("2006-01-02 15:04"), c.First.title(), c.First.Site.Name,
+			strconv.Itoa(c.Second.ID), c.Second.Time.Format("2006-01-02 15:04"), c.Second.title(), c.Second.Site.Name})
+	}
+	headers := []string{"Спортсмен",
+		"ID", "Дата и время", "Дисциплина", "Место",
+		"ID", "Дата и время", "Дисциплина", "Место"}
+	types := []columnType{columnText,
+		columnInt, columnText, columnText, columnText,
+		columnInt, columnText, columnText, columnText}
+
+	return writeTable(os.Stdout, *format, headers, types, rows)
+}
+
+func findCompetition(ctx context.Context, store *sqliteStore, idArg string) (Competition, error) {
+	id, err := parseID(idArg)
+	if err != nil {
+		return Competition{}, err
+	}
+	return store.getCompetition(ctx, id)
+}
+
+func cliDelete(ctx context.Context, store *sqliteStore, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("не указана сущность")
+	}
+
+	entity, args := args[0], args[1:]
+	switch entity {
+	case "country", "sport":
+		if err := wantArgs(args, 1); err != nil {
+			return err
+		}
+		if entity == "country" {
+			return store.deleteCountry(ctx, args[0])
+		}
+		return store.deleteSport(ctx, args[0])
+	case "event", "athlete", "site", "team", "competition":
+		if err := wantArgs(args, 1); err != nil {
+			return err
+		}
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		switch entity {
+		case "event": return store.deleteEvent(ctx, id)
+		case "athlete": return store.deleteAthlete(ctx, id)
+		case "site": return store.deleteSite(ctx, id)
+		case "team": return store.deleteTeam(ctx, id)
+		default: return store.deleteCompetition(ctx, id)
+		}
+	case "member":
+		if err := wantArgs(args, 2); err != nil {
+			return err
+		}
+		teamID, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		athleteID, err := parseID(args[1])
+		if err != nil {
+			return err
+		}
+		return store.deleteAthleteFromTeam(ctx, teamID, athleteID)
+	case "result":
+		if err := wantArgs(args, 2); err != nil {
+			return err
+		}
+		competition, err := findCompetition(ctx, store, args[0])
+		if err != nil {
+			return err
+		}
+		participantID, err := parseID(args[1])
+		if err != nil {
+			return err
+		}
+		return store.deleteResult(ctx, Result{
+			CompetitionID: competition.ID,
+			IsTeam: competition.Sport.IsTeam,
+			ParticipantID: participantID,
+		})
+	default:
+		return fmt.Errorf("неизвестная сущность %q", entity)
+	}
+}
+
+// Выполняет SQL-скрипт (например populate.sql) целиком в одной транзакции
+func cliImport(ctx context.Context, store *sqliteStore, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "только проверить, ничего не записывая")
+	mapSpec := fs.String("map", "", "сопоставление полей и столбцов")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 {
+		return cliImportCSV(ctx, store, args[0], args[1], *mapSpec, *dryRun)
+	}
+	if err := wantArgs(args, 1); err != nil {
+		return err
+	}
+
+	script, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	if _, err := store.snapshot(ctx, "before-import"); err != nil {
+		return err
+	}
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return store.recordUndoStep(ctx, "Импорт SQL-скрипта " + filepath.Base(args[0]))
+}
+
+func cliImportCSV(ctx context.Context, store *sqliteStore, entity string, path string, mapSpec string, dryRun bool) error {
+	if _, ok := importFields[entity]; !ok {
+		return fmt.Errorf("неизвестная сущность %q", entity)
+	}
+
+	headers, records, err := readCSV(path)
+	if err != nil {
+		return err
+	}
+	mapping, err := parseImportMapping(entity, headers, mapSpec)
+	if err != nil {
+		return err
+	}
+
+	results, err := store.importCSV(ctx, entity, records, mapping, dryRun)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "строка %d: %s\n", r.Line, r.Err)
+		}
+	}
+	switch {
+	case failed > 0:
+		return fmt.Errorf("строк с ошибками: %d из %d, ничего не импортировано", failed, len(results))
+	case dryRun:
+		fmt.Printf("проверено строк: %d, ошибок нет\n", len(results))
+	default:
+		fmt.Printf("импортировано строк: %d\n", len(results))
+	}
+	return nil
+}
+
+func cliExport(ctx context.Context, store *sqliteStore, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "", "формат файла: csv, json или xlsx")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(args, 2); err != nil {
+		return err
+	}
+
+	if *format == "" {
+		if *format = exportFormatFromPath(args[1]); *format == "" {
+			*format = "csv"
+		}
+	}
+
+	headers, types, rows, err := entityTable(ctx, store, args[0])
+	if err != nil {
+		return err
+	}
+
+	return exportToFile(args[1], *format, headers, types, rows)
+}
+
+func cliDump(ctx context.Context, store *sqliteStore, args []string) error {
+	if err := wantArgs(args, 1); err != nil {
+		return err
+	}
+	if args[0] == "-" {
+		return store.writeDump(ctx, os.Stdout)
+	}
+	return store.dumpToFile(ctx, args[0])
+}
+
+func cliRestore(ctx context.Context, store *sqliteStore, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	onConflict := fs.String("on-conflict", string(ConflictFail), "fail, skip или overwrite")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(args, 1); err != nil {
+		return err
+	}
+
+	policy := ConflictPolicy(*onConflict)
+	if !slices.Contains(conflictPolicies, policy) {
+		return fmt.Errorf("неизвестная политика %q, нужно fail, skip или overwrite", *onConflict)
+	}
+
+	stats, err := store.restoreFromFile(ctx, args[0], policy)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("добавлено: %d, обновлено: %d, пропущено: %d\n", stats.Inserted, stats.Updated, stats.Skipped)
+	return nil
+}
+
+func cliBackup(ctx context.Context, store *sqliteStore, args []string) error {
+	if len(args) == 0 {
+		path, err := store.snapshot(ctx, "manual")
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+		return nil
+	}
+	if err := wantArgs(args, 1); err != nil {
+		return err
+	}
+	if _, err := os.Stat(args[0]); err == nil {
+		return fmt.Errorf("файл %s уже существует", args[0])
+	}
+	return backupDatabase(ctx, store.db, args[0])
+}
+
+func cliSnapshots(ctx context.Context, store *sqliteStore, args []string) error {
+	if err := wantArgs(args, 0); err != nil {
+		return err
+	}
+
+	snapshots, err := listSnapshots(store.path)
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, s := range snapshots {
+		rows = append(rows, []string{s.Time.Format(time.DateTime), s.Reason, s.Path})
+	}
+	return writeTable(os.Stdout, "table", []string{"Время", "Причина", "Файл"}, nil, rows)
+}
+
+func cliRestoreBackup(ctx context.Context, store *sqliteStore, args []string) error {
+	if err := wantArgs(args, 1); err != nil {
+		return err
+	}
+	return store.restoreBackup(ctx, args[0])
+}
+
+func cliUndo(ctx context.Context, store *sqliteStore, args []string, redoing bool) error {
+	if err := wantArgs(args, 0); err != nil {
+		return err
+	}
+
+	var description string
+	var err error
+	if redoing {
+		description, err = store.redo(ctx)
+	} else {
+		description, err = store.undo(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(description)
+	return nil
+}
+
+func cliHistory(ctx context.Context, store *sqliteStore, args []string) error {
+	if err := wantArgs(args, 0); err != nil {
+		return err
+	}
+
+	steps, err := store.getUndoHistory(ctx)
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, s := range steps {
+		state := ""
+		if s.Undone {
+			state = "отменено"
+		}
+		rows = append(rows, []string{s.Time.Local().Format(time.DateTime), s.Description, state})
+	}
+	return writeTable(os.Stdout, "table", []string{"Время", "Изменение", ""}, nil, rows)
+}
+
+func cliAudit(ctx context.Context, store *sqliteStore, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	format := fs.String("format", "table", "формат вывода: table, csv, json или xlsx")
+	var filter AuditFilter
+	fs.StringVar(&filter.Table, "table", "", "таблица")
+	fs.StringVar(&filter.Action, "action", "", "действие: insert, update или delete")
+	fs.StringVar(&filter.Operator, "operator", "", "оператор")
+	fs.StringVar(&filter.Search, "search", "", "текст в значениях")
+	from := fs.String("from", "", "с даты")
+	to := fs.String("to", "", "по дату")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(args, 0); err != nil {
+		return err
+	}
+
+	if filter.Table != "" && !slices.Contains(auditTables, filter.Table) {
+		return fmt.Errorf("неизвестная таблица %q", filter.Table)
+	}
+	if filter.Action != "" && !slices.Contains(auditActions, filter.Action) {
+		return fmt.Errorf("неизвестное действие %q", filter.Action)
+	}
+	if filter.From, err = parseAuditDate(*from); err != nil {
+		return err
+	}
+	if filter.To, err = parseAuditDate(*to); err != nil {
+		return err
+	}
+
+	entries, err := store.getAuditLog(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	headers, types, rows := auditTable(pointers(entries))
+	return writeTable(os.Stdout, *format, headers, types, rows)
+}
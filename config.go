@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const maxRecentFiles = 10
+
+// Переменная окружения с путём к базе, флаг --db важнее неё
+const dbPathEnv = "OLYMPIAD_DB"
+
+// Переменная окружения с именем оператора для журнала аудита
+const operatorEnv = "OLYMPIAD_OPERATOR"
+
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "course-db-2025"), nil
+}
+
+// Недавно открытые базы, самая свежая первой. Ошибки чтения не важны,
+// в худшем случае список просто будет пустым
+func loadRecentFiles() []string {
+	var recent []string
+
+	dir, err := configDir()
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "recent.json"))
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &recent); err != nil {
+		return nil
+	}
+
+	return recent
+}
+
+func addRecentFile(path string) ([]string, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	recent := loadRecentFiles()
+	recent = slices.DeleteFunc(recent, func(p string) bool { return p == path })
+	recent = slices.Insert(recent, 0, path)
+	if len(recent) > maxRecentFiles {
+		recent = recent[:maxRecentFiles]
+	}
+
+	dir, err := configDir()
+	if err != nil {
+		return recent, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return recent, err
+	}
+	data, err := json.MarshalIndent(recent, "", "  ")
+	if err != nil {
+		return recent, err
+	}
+
+	return recent, os.WriteFile(filepath.Join(dir, "recent.json"), data, 0644)
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
-	_ "embed"
 
 	"database/sql"
 	_ "github.com/mattn/go-sqlite3"
@@ -18,6 +20,80 @@ type Sport struct {
 	Code string
 	Name string
 	IsTeam bool
+
+	// Правила состава команд: 0 — без ограничения, пустой пол — смешанные
+	// команды. Заполняются в списках видов спорта и команд
+	MinPlayers int
+	MaxPlayers int
+	TeamGender string
+}
+
+// Столбцы вида спорта s в запросе и поля, в которые они читаются
+const sportColumns = "s.code, s.name, s.is_team, coalesce(s.min_players, 0), coalesce(s.max_players, 0), coalesce(s.team_gender, '')"
+
+func sportFields(sport *Sport) []any {
+	return []any{&sport.Code, &sport.Name, &sport.IsTeam, &sport.MinPlayers, &sport.MaxPlayers, &sport.TeamGender}
+}
+
+// Дисциплина внутри вида спорта. Пустой пол — смешанная дисциплина,
+// нулевой возраст — без ограничения
+type Event struct {
+	ID int
+	SportCode string
+	Name string
+	Gender string
+	MinAge int
+	MaxAge int
+	WeightClass string
+}
+
+// Столбцы дисциплины с псевдонимом таблицы alias в запросе, порядок как
+// в eventFields
+func eventColumns(alias string) string {
+	return fmt.Sprintf("%[1]s.id, %[1]s.sport_code, %[1]s.name, coalesce(%[1]s.gender, ''), "+
+		"coalesce(%[1]s.min_age, 0), coalesce(%[1]s.max_age, 0), coalesce(%[1]s.weight_class, '')", alias)
+}
+
+func eventFields(e *Event) []any {
+	return []any{&e.ID, &e.SportCode, &e.Name, &e.Gender, &e.MinAge, &e.MaxAge, &e.WeightClass}
+}
+
+// Условия участия для подписи в интерфейсе: «женщины, 18-23 лет, до 60 кг»
+func (e Event) conditions() string {
+	var parts []string
+	switch e.Gender {
+	case "M": parts = append(parts, "мужчины")
+	case "F": parts = append(parts, "женщины")
+	}
+	switch {
+	case e.MinAge > 0 && e.MaxAge > 0: parts = append(parts, fmt.Sprintf("%d-%d лет", e.MinAge, e.MaxAge))
+	case e.MinAge > 0: parts = append(parts, fmt.Sprintf("от %d лет", e.MinAge))
+	case e.MaxAge > 0: parts = append(parts, fmt.Sprintf("до %d лет", e.MaxAge))
+	}
+	if e.WeightClass != "" {
+		parts = append(parts, e.WeightClass)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Подходит ли спортсмен дисциплине по полу и по возрасту на момент
+// соревнования t, как в триггерах ensure_event_*
+func (e Event) fits(a Athlete, t time.Time) (byGender bool, byAge bool) {
+	age := ageAt(a.Birthday, t)
+	byGender = e.Gender == "" || a.Gender == e.Gender
+	byAge = (e.MinAge == 0 || age >= e.MinAge) && (e.MaxAge == 0 || age <= e.MaxAge)
+	return byGender, byAge
+}
+
+// Полных лет на момент t. Считается в UTC, как в представлении
+// athlete_ages: дата рождения хранится полночью UTC
+func ageAt(birthday time.Time, t time.Time) int {
+	birthday, t = birthday.UTC(), t.UTC()
+	age := t.Year() - birthday.Year()
+	if t.Month() < birthday.Month() || t.Month() == birthday.Month() && t.Day() < birthday.Day() {
+		age--
+	}
+	return age
 }
 
 type Athlete struct {
@@ -25,6 +101,7 @@ type Athlete struct {
 	Name string
 	Gender string
 	Birthday time.Time
+	CountryCode string
 	CountryName string
 }
 
@@ -35,7 +112,29 @@ type Team struct {
 	Country Country
 	Sport Sport
 
+	// Members заполняется только по запросу, а MemberCount всегда
 	Members []Athlete
+	MemberCount int
+	// Участники не того пола, такие остаются от составов, собранных до
+	// появления правил у вида спорта
+	WrongGenderCount int
+}
+
+// Что не так с составом команды по правилам её вида спорта, пустая
+// строка — всё в порядке
+func (t Team) rosterProblem() string {
+	switch {
+	case !t.Sport.IsTeam:
+		return "вид спорта не командный"
+	case t.WrongGenderCount > 0:
+		return fmt.Sprintf("участников другого пола: %d", t.WrongGenderCount)
+	case t.Sport.MaxPlayers > 0 && t.MemberCount > t.Sport.MaxPlayers:
+		return fmt.Sprintf("игроков больше максимума: %d из %d", t.MemberCount, t.Sport.MaxPlayers)
+	case t.MemberCount < max(t.Sport.MinPlayers, 1):
+		return fmt.Sprintf("не хватает игроков: %d из %d", t.MemberCount, max(t.Sport.MinPlayers, 1))
+	default:
+		return ""
+	}
 }
 
 type Site struct {
@@ -43,12 +142,207 @@ type Site struct {
 	Name string
 }
 
+type CompetitionStatus string
+
+const (
+	StatusScheduled CompetitionStatus = "scheduled"
+	StatusInProgress CompetitionStatus = "in_progress"
+	StatusFinished CompetitionStatus = "finished"
+	StatusCancelled CompetitionStatus = "cancelled"
+)
+
+var competitionStatuses = []CompetitionStatus{StatusScheduled, StatusInProgress, StatusFinished, StatusCancelled}
+
+func (s CompetitionStatus) name() string {
+	switch s {
+	case StatusScheduled: return "Запланировано"
+	case StatusInProgress: return "Идёт"
+	case StatusFinished: return "Завершено"
+	case StatusCancelled: return "Отменено"
+	default: return string(s)
+	}
+}
+
+// Этап в сетке дисциплины, в порядке от первого к финалу
+type CompetitionStage string
+
+const (
+	StageHeat CompetitionStage = "heat"
+	StageQuarterfinal CompetitionStage = "quarterfinal"
+	StageSemifinal CompetitionStage = "semifinal"
+	StageFinal CompetitionStage = "final"
+)
+
+var competitionStages = []CompetitionStage{StageHeat, StageQuarterfinal, StageSemifinal, StageFinal}
+
+func (s CompetitionStage) name() string {
+	switch s {
+	case StageHeat: return "Предварительный этап"
+	case StageQuarterfinal: return "Четвертьфинал"
+	case StageSemifinal: return "Полуфинал"
+	case StageFinal: return "Финал"
+	default: return string(s)
+	}
+}
+
+// Место в сетке, как в представлении stage_ranks
+func (s CompetitionStage) rank() int {
+	return slices.Index(competitionStages, s) + 1
+}
+
 type Competition struct {
 	ID int
 	Time time.Time
+	Duration int // в минутах
+	Status CompetitionStatus
 
 	Sport Sport
+	Event Event
 	Site Site
+
+	// Из этапа, кроме финала, первые AdvanceCount мест (0 — все)
+	// проходят в этап NextStageID (0 — этап ни с чем не связан)
+	Stage CompetitionStage
+	NextStageID int
+	AdvanceCount int
+}
+
+func (c Competition) End() time.Time {
+	return c.Time.Add(time.Duration(c.Duration) * time.Minute)
+}
+
+// Вид спорта, дисциплина и этап, если это не финал: «Плавание: 100 м
+// вольным стилем, полуфинал»
+func (c Competition) title() string {
+	title := c.Sport.Name + ": " + c.Event.Name
+	if c.Stage != StageFinal && c.Stage != "" {
+		title += ", " + strings.ToLower(c.Stage.name())
+	}
+	return title
+}
+
+// Столбцы соревнования для competitionFields. Таблицы подключаются через
+// competitionJoins с тем же суффиксом псевдонимов, чтобы в одном запросе
+// можно было прочитать два соревнования
+func competitionColumns(suffix string) string {
+	return fmt.Sprintf("comp%[1]s.id, comp%[1]s.time, comp%[1]s.duration, comp%[1]s.status, "+
+		"s%[1]s.code, s%[1]s.name, s%[1]s.is_team, ", suffix) +
+		eventColumns("e"+suffix) + fmt.Sprintf(", st%[1]s.id, st%[1]s.name, "+
+		"comp%[1]s.stage, coalesce(comp%[1]s.next_stage_id, 0), coalesce(comp%[1]s.advance_count, 0)", suffix)
+}
+
+func competitionJoins(suffix string) string {
+	return fmt.Sprintf(`
+		JOIN sports s%[1]s ON s%[1]s.code = comp%[1]s.sport_code
+		JOIN events e%[1]s ON e%[1]s.id = comp%[1]s.event_id
+		JOIN sites st%[1]s ON st%[1]s.id = comp%[1]s.site_id`, suffix)
+}
+
+func competitionFields(c *Competition) []any {
+	fields := []any{&c.ID, &c.Time, &c.Duration, &c.Status, &c.Sport.Code, &c.Sport.Name, &c.Sport.IsTeam}
+	fields = append(fields, eventFields(&c.Event)...)
+	return append(fields, &c.Site.ID, &c.Site.Name, &c.Stage, &c.NextStageID, &c.AdvanceCount)
+}
+
+// Результат участника (спортсмена или команды) в соревновании
+type Result struct {
+	CompetitionID int
+	// 0 — места нет: соревнование ещё не завершено или участник сошёл
+	Place int
+
+	IsTeam bool
+	ParticipantID int
+	ParticipantName string
+	CountryName string
+}
+
+// Место для показа: у записанного, но ещё не расставленного участника
+// места нет
+func placeName(place int) string {
+	if place == 0 {
+		return "без места"
+	}
+	return fmt.Sprintf("место %d", place)
+}
+
+// Порядок результатов: по месту, участники без места в конце
+func comparePlaces(a int, b int) int {
+	switch {
+	case a == b: return 0
+	case a == 0: return 1
+	case b == 0: return -1
+	default: return a - b
+	}
+}
+
+const maxDependentsShown = 10
+
+// Участие спортсмена в соревновании, лично или в составе команды
+type AthleteCompetition struct {
+	Competition Competition
+	Place int
+
+	// Пустое если спортсмен выступал сам по себе
+	TeamName string
+}
+
+type AthleteProfile struct {
+	Athlete Athlete
+	Teams []Team
+	Competitions []AthleteCompetition
+
+	Gold int
+	Silver int
+	Bronze int
+}
+
+// Удаление отклонено, потому что на запись ссылаются другие записи
+type DependentsError struct {
+	What string
+	Dependents []string
+}
+
+func (e *DependentsError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Нельзя удалить %s, есть зависимые записи:", e.What)
+	for i, d := range e.Dependents {
+		if i == maxDependentsShown {
+			fmt.Fprintf(&sb, "\n  ...и ещё %d", len(e.Dependents)-i)
+			break
+		}
+		sb.WriteString("\n  ")
+		sb.WriteString(d)
+	}
+	return sb.String()
+}
+
+// Спортсмен заявлен в двух соревнованиях, которые идут одновременно
+type AthleteConflict struct {
+	AthleteID int
+	AthleteName string
+	First Competition
+	Second Competition
+}
+
+// Участника нельзя добавить: его спортсмены в это время уже выступают
+// в других соревнованиях. В каждом конфликте First — то соревнование,
+// куда добавляют, Second — то, с которым оно пересекается
+type AthleteConflictsError struct {
+	Conflicts []AthleteConflict
+}
+
+func (e *AthleteConflictsError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("Спортсмен в это время участвует в другом соревновании:")
+	for i, c := range e.Conflicts {
+		if i == maxDependentsShown {
+			fmt.Fprintf(&sb, "\n  ...и ещё %d", len(e.Conflicts)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "\n  %s: %s, %s, %s", c.AthleteName, c.Second.title(),
+			c.Second.Site.Name, c.Second.Time.Format("2006-01-02 15:04"))
+	}
+	return sb.String()
 }
 
 type CountryMedals struct {
@@ -59,29 +353,63 @@ type CountryMedals struct {
     Total   int
 }
 
-//go:embed schema.sql
-var dbSchema string
+// Призёр дисциплины
+type EventMedal struct {
+    Event           Event
+    SportName       string
+    Place           int
+    ParticipantName string
+    CountryName     string
+}
 
-var db *sql.DB
+// Хранилище в файле SQLite
+type sqliteStore struct {
+	db *sql.DB
+	path string
+}
 
-func dbOpen(path string) error {
-	var err error
+// Открывает базу по пути path и приводит её схему к последней версии.
+// Если create == false, а файла нет, то возвращается ошибка, а не
+// создаётся пустая база
+func openSQLiteStore(ctx context.Context, path string, create bool) (*sqliteStore, error) {
+	mode := "rw"
+	if create {
+		mode = "rwc"
+	}
 
-	if db, err = sql.Open("sqlite3", fmt.Sprintf("file:%s", path)); err != nil {
-		return fmt.Errorf("failed to open database: %s", err)
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on&mode=%s", path, mode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %s", err)
 	}
 
-	if _, err = db.Exec(dbSchema); err != nil {
-		return fmt.Errorf("failed to init db schema: %s", err)
+	if err = dbMigrate(ctx, db, path); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate db schema: %s", err)
+	}
+	if err = installTriggers(ctx, db); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	return nil
+	return &sqliteStore{db: db, path: path}, nil
+}
+
+func (s *sqliteStore) close() error {
+	return s.db.Close()
 }
 
-func getCountries() ([]Country, error) {
+// Триггеры журналов отмены и аудита
+func installTriggers(ctx context.Context, d *sql.DB) error {
+	if err := installUndoTriggers(ctx, d); err != nil {
+		return err
+	}
+	return installAuditTriggers(ctx, d)
+}
+
+func (s *sqliteStore) getCountries(ctx context.Context) ([]Country, error) {
 	var countries []Country
 
-	rows, err := db.Query("SELECT code, name FROM countries;")
+	rows, err := s.db.QueryContext(ctx, "SELECT code, name FROM countries;")
 	if err != nil {
 		return nil, err
 	}
@@ -101,20 +429,35 @@ func getCountries() ([]Country, error) {
 	return countries, nil
 }
 
-func addCountry(code string, name string) error {
-	_, err := db.Exec("INSERT INTO countries ( code, name ) VALUES ( ?, ? );", code, name)
-	return err
+func (s *sqliteStore) addCountry(ctx context.Context, code string, name string) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO countries ( code, name ) VALUES ( ?, ? );", code, name)
+	return s.undoStep(ctx, err, "Добавление страны %s", code)
 }
 
-func deleteCountry(code string) error {
-	_, err := db.Exec("DELETE FROM countries WHERE code = ?;", code)
-	return err
+// Смена кода переносится на athletes и teams через ON UPDATE CASCADE
+func (s *sqliteStore) updateCountry(ctx context.Context, oldCode string, code string, name string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE countries SET code = ?, name = ? WHERE code = ?;", code, name, oldCode)
+	return s.undoStep(ctx, err, "Изменение страны %s", oldCode)
 }
 
-func getSports() ([]Sport, error) {
+func (s *sqliteStore) deleteCountry(ctx context.Context, code string) error {
+	err := s.checkDependents(ctx, "страну "+code, `
+		SELECT 'Спортсмен: ' || name FROM athletes WHERE country_code = ?1
+		UNION ALL
+		SELECT 'Команда: ' || name FROM teams WHERE country_code = ?1;
+	`, code)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM countries WHERE code = ?;", code)
+	return s.undoStep(ctx, err, "Удаление страны %s", code)
+}
+
+func (s *sqliteStore) getSports(ctx context.Context) ([]Sport, error) {
 	var sports []Sport
 
-	rows, err := db.Query("SELECT code, name, is_team FROM sports;")
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sportColumns+" FROM sports s;")
 	if err != nil {
 		return nil, err
 	}
@@ -122,7 +465,7 @@ func getSports() ([]Sport, error) {
 
 	for rows.Next() {
 		sport := Sport{}
-		if err := rows.Scan(&sport.Code, &sport.Name, &sport.IsTeam); err != nil {
+		if err := rows.Scan(sportFields(&sport)...); err != nil {
 			return nil, err
 		}
 		sports = append(sports, sport)
@@ -134,20 +477,99 @@ func getSports() ([]Sport, error) {
 	return sports, nil
 }
 
-func addSport(code string, name string, team bool) error {
-	_, err := db.Exec("INSERT INTO sports ( code, name, is_team ) VALUES ( ?, ?, ? );", code, name, team)
-	return err
+// Правила состава без ограничений хранятся как NULL
+func (s *sqliteStore) addSport(ctx context.Context, sport Sport) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO sports ( code, name, is_team, min_players, max_players, team_gender )
+		VALUES ( ?, ?, ?, NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, '') );
+	`, sport.Code, sport.Name, sport.IsTeam, sport.MinPlayers, sport.MaxPlayers, sport.TeamGender)
+	return s.undoStep(ctx, err, "Добавление вида спорта %s", sport.Code)
+}
+
+// Смена кода переносится на teams, events и competitions через ON UPDATE CASCADE
+func (s *sqliteStore) updateSport(ctx context.Context, oldCode string, sport Sport) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE sports
+		SET code = ?, name = ?, is_team = ?,
+		    min_players = NULLIF(?, 0), max_players = NULLIF(?, 0), team_gender = NULLIF(?, '')
+		WHERE code = ?;
+	`, sport.Code, sport.Name, sport.IsTeam, sport.MinPlayers, sport.MaxPlayers, sport.TeamGender, oldCode)
+	return s.undoStep(ctx, err, "Изменение вида спорта %s", oldCode)
 }
 
-func deleteSport(code string) error {
-	_, err := db.Exec("DELETE FROM sports WHERE code = ?;", code)
-	return err
+func (s *sqliteStore) deleteSport(ctx context.Context, code string) error {
+	err := s.checkDependents(ctx, "вид спорта "+code, `
+		SELECT 'Команда: ' || name FROM teams WHERE sport_code = ?1
+		UNION ALL
+		SELECT 'Соревнование №' || id FROM competitions WHERE sport_code = ?1;
+	`, code)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM sports WHERE code = ?;", code)
+	return s.undoStep(ctx, err, "Удаление вида спорта %s", code)
 }
 
-func getAthletes() ([]Athlete, error) {
+func (s *sqliteStore) getEvents(ctx context.Context) ([]Event, error) {
+	var events []Event
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+eventColumns("e")+" FROM events e ORDER BY e.sport_code, e.name;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := Event{}
+		if err := rows.Scan(eventFields(&e)...); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Условия без ограничений хранятся как NULL
+func (s *sqliteStore) addEvent(ctx context.Context, e Event) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO events ( sport_code, name, gender, min_age, max_age, weight_class )
+		VALUES ( ?, ?, NULLIF(?, ''), NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, '') );
+	`, e.SportCode, e.Name, e.Gender, e.MinAge, e.MaxAge, e.WeightClass)
+	return s.undoStep(ctx, err, "Добавление дисциплины %s", e.Name)
+}
+
+// Вид спорта у дисциплины не меняется, условия участия меняются, только
+// пока по ней нет результатов
+func (s *sqliteStore) updateEvent(ctx context.Context, e Event) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE events
+		SET name = ?, gender = NULLIF(?, ''), min_age = NULLIF(?, 0), max_age = NULLIF(?, 0), weight_class = NULLIF(?, '')
+		WHERE id = ?;
+	`, e.Name, e.Gender, e.MinAge, e.MaxAge, e.WeightClass, e.ID)
+	return s.undoStep(ctx, err, "Изменение дисциплины %s", e.Name)
+}
+
+func (s *sqliteStore) deleteEvent(ctx context.Context, ID int) error {
+	err := s.checkDependents(ctx, fmt.Sprintf("дисциплину №%d", ID), `
+		SELECT 'Соревнование №' || id FROM competitions WHERE event_id = ?;
+	`, ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM events WHERE id = ?;", ID)
+	return s.undoStep(ctx, err, "Удаление дисциплины №%d", ID)
+}
+
+func (s *sqliteStore) getAthletes(ctx context.Context) ([]Athlete, error) {
 	var athletes []Athlete
 
-	rows, err := db.Query("SELECT a.id, a.name, a.gender, a.birthday, c.name FROM athletes a JOIN countries c ON c.code = a.country_code;")
+	rows, err := s.db.QueryContext(ctx, "SELECT a.id, a.name, a.gender, a.birthday, c.code, c.name FROM athletes a JOIN countries c ON c.code = a.country_code;")
 	if err != nil {
 		return nil, err
 	}
@@ -155,7 +577,7 @@ func getAthletes() ([]Athlete, error) {
 
 	for rows.Next() {
 		athlete := Athlete{}
-		err := rows.Scan(&athlete.ID, &athlete.Name, &athlete.Gender, &athlete.Birthday, &athlete.CountryName)
+		err := rows.Scan(&athlete.ID, &athlete.Name, &athlete.Gender, &athlete.Birthday, &athlete.CountryCode, &athlete.CountryName)
 		if err != nil {
 			return nil, err
 		}
@@ -168,27 +590,161 @@ func getAthletes() ([]Athlete, error) {
 	return athletes, nil
 }
 
-func addAthlete(name string, isMale bool, birthday time.Time, countryCode string) error {
-	var gender string
+// Собирает всё о спортсмене: команды, соревнования (в том числе
+// командные, через его текущие команды), места и медали
+func (s *sqliteStore) getAthleteProfile(ctx context.Context, ID int) (AthleteProfile, error) {
+	p := AthleteProfile{}
+
+	a := &p.Athlete
+	err := s.db.QueryRowContext(ctx, `
+		SELECT a.id, a.name, a.gender, a.birthday, c.code, c.name
+		FROM athletes a
+		JOIN countries c ON c.code = a.country_code
+		WHERE a.id = ?;
+	`, ID).Scan(&a.ID, &a.Name, &a.Gender, &a.Birthday, &a.CountryCode, &a.CountryName)
+	if err == sql.ErrNoRows {
+		return p, fmt.Errorf("спортсмен %d не найден", ID)
+	} else if err != nil {
+		return p, err
+	}
+
+	teamRows, err := s.db.QueryContext(ctx, `
+		SELECT t.id, t.name,
+		       c.code, c.name,
+		       s.code, s.name, s.is_team
+		FROM team_members tm
+		JOIN teams t ON t.id = tm.team_id
+		JOIN countries c ON c.code = t.country_code
+		JOIN sports s ON s.code = t.sport_code
+		WHERE tm.athlete_id = ?
+		ORDER BY t.name;
+	`, ID)
+	if err != nil {
+		return p, err
+	}
+	defer teamRows.Close()
+
+	for teamRows.Next() {
+		t := Team{}
+		err := teamRows.Scan(&t.ID, &t.Name, &t.Country.Code, &t.Country.Name,
+			&t.Sport.Code, &t.Sport.Name, &t.Sport.IsTeam)
+		if err != nil {
+			return p, err
+		}
+		p.Teams = append(p.Teams, t)
+	}
+	if err = teamRows.Err(); err != nil {
+		return p, err
+	}
+	teamRows.Close()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+competitionColumns("")+`,
+		       coalesce(ca.place, 0), ''
+		FROM competition_athletes ca
+		JOIN competitions comp ON comp.id = ca.competition_id`+competitionJoins("")+`
+		WHERE ca.athlete_id = ?1
+		UNION ALL
+		SELECT `+competitionColumns("")+`,
+		       coalesce(ct.place, 0), t.name
+		FROM team_members tm
+		JOIN teams t ON t.id = tm.team_id
+		JOIN competition_teams ct ON ct.team_id = tm.team_id
+		JOIN competitions comp ON comp.id = ct.competition_id`+competitionJoins("")+`
+		WHERE tm.athlete_id = ?1
+		ORDER BY 2;
+	`, ID)
+	if err != nil {
+		return p, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ac := AthleteCompetition{}
+		c := &ac.Competition
+		err := rows.Scan(append(competitionFields(c), &ac.Place, &ac.TeamName)...)
+		if err != nil {
+			return p, err
+		}
+
+		// Медали дают только завершённые финалы
+		if c.Stage == StageFinal && c.Status == StatusFinished {
+			switch ac.Place {
+			case 1: p.Gold++
+			case 2: p.Silver++
+			case 3: p.Bronze++
+			}
+		}
+		p.Competitions = append(p.Competitions, ac)
+	}
+	if err = rows.Err(); err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+func genderCode(isMale bool) string {
 	if isMale {
-		gender = "M"
-	} else {
-		gender = "F"
+		return "M"
 	}
-	_, err := db.Exec("INSERT INTO athletes ( name, gender, birthday, country_code ) VALUES ( ?, ?, ?, ? );",
+	return "F"
+}
+
+func (s *sqliteStore) addAthlete(ctx context.Context, name string, isMale bool, birthday time.Time, countryCode string) error {
+	gender := genderCode(isMale)
+	_, err := s.db.ExecContext(ctx, "INSERT INTO athletes ( name, gender, birthday, country_code ) VALUES ( ?, ?, ?, ? );",
 		name, gender, birthday.Unix(), countryCode)
-	return err
+	return s.undoStep(ctx, err, "Добавление спортсмена %s", name)
 }
 
-func deleteAthlete(ID int) error {
-	_, err := db.Exec("DELETE FROM athletes WHERE id = ?;", ID)
-	return err
+func (s *sqliteStore) updateAthlete(ctx context.Context, ID int, name string, isMale bool, birthday time.Time, countryCode string) error {
+	gender := genderCode(isMale)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE athletes SET name = ?, gender = ?, birthday = ?, country_code = ? WHERE id = ?;",
+		name, gender, birthday.Unix(), countryCode, ID)
+	if err != nil {
+		return err
+	}
+	if err := checkTeamMembersCountry(ctx, tx, "tm.athlete_id = ?", ID); err != nil {
+		return err
+	}
+
+	return s.undoStep(ctx, tx.Commit(), "Изменение спортсмена %s", name)
 }
 
-func getSites() ([]Site, error) {
+// Членство в командах удаляется каскадно, а результаты в соревнованиях
+// не дают удалить спортсмена
+func (s *sqliteStore) deleteAthlete(ctx context.Context, ID int) error {
+	err := s.checkDependents(ctx, fmt.Sprintf("спортсмена №%d", ID), `
+		SELECT 'Результат: соревнование №' || comp.id || ' (' || s.name || '), ' || coalesce('место ' || ca.place, 'без места')
+		FROM competition_athletes ca
+		JOIN competitions comp ON comp.id = ca.competition_id
+		JOIN sports s ON s.code = comp.sport_code
+		WHERE ca.athlete_id = ?;
+	`, ID)
+	if err != nil {
+		return err
+	}
+
+	// Вместе со спортсменом каскадом удаляется его членство в командах
+	if _, err := s.snapshot(ctx, "before-delete"); err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "DELETE FROM athletes WHERE id = ?;", ID)
+	return s.undoStep(ctx, err, "Удаление спортсмена №%d", ID)
+}
+
+func (s *sqliteStore) getSites(ctx context.Context) ([]Site, error) {
 	var sites []Site
 
-	rows, err := db.Query("SELECT id, name FROM sites;")
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name FROM sites;")
 	if err != nil {
 		return nil, err
 	}
@@ -209,23 +765,44 @@ func getSites() ([]Site, error) {
 	return sites, nil
 }
 
-func addSite(name string) error {
-	_, err := db.Exec("INSERT INTO sites ( name ) VALUES ( ? );", name)
-	return err
+func (s *sqliteStore) addSite(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO sites ( name ) VALUES ( ? );", name)
+	return s.undoStep(ctx, err, "Добавление места %s", name)
 }
 
-func deleteSite(ID int) error {
-	_, err := db.Exec("DELETE FROM sites WHERE id = ?;", ID)
-	return err
+func (s *sqliteStore) updateSite(ctx context.Context, ID int, name string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE sites SET name = ? WHERE id = ?;", name, ID)
+	return s.undoStep(ctx, err, "Изменение места %s", name)
 }
 
-func getTeams() ([]Team, error) {
+func (s *sqliteStore) deleteSite(ctx context.Context, ID int) error {
+	err := s.checkDependents(ctx, fmt.Sprintf("место проведения №%d", ID), `
+		SELECT 'Соревнование №' || comp.id || ' (' || s.name || ')'
+		FROM competitions comp
+		JOIN sports s ON s.code = comp.sport_code
+		WHERE comp.site_id = ?;
+	`, ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM sites WHERE id = ?;", ID)
+	return s.undoStep(ctx, err, "Удаление места №%d", ID)
+}
+
+// Составы команд нужны не везде, а для больших команд это основная
+// часть данных, поэтому без withMembers заполняется только MemberCount
+func (s *sqliteStore) getTeams(ctx context.Context, withMembers bool) ([]Team, error) {
 	var teams []Team
 
-	rows, err := db.Query(`
+	rows, err := s.db.QueryContext(ctx, `
 		SELECT t.id, t.name,
 		       c.code, c.name,
-		       s.code, s.name, s.is_team
+		       `+sportColumns+`,
+		       (SELECT COUNT(*) FROM team_members tm WHERE tm.team_id = t.id),
+		       (SELECT COUNT(*) FROM team_members tm
+		        JOIN athletes a ON a.id = tm.athlete_id
+		        WHERE tm.team_id = t.id AND a.gender != s.team_gender)
 		FROM teams t
 		JOIN countries c ON c.code = t.country_code
 		JOIN sports s ON s.code = t.sport_code
@@ -241,84 +818,151 @@ func getTeams() ([]Team, error) {
 		country := Country{}
 		sport := Sport{}
 
-		err := rows.Scan(&team.ID, &team.Name, &country.Code, &country.Name, &sport.Code, &sport.Name, &sport.IsTeam)
+		fields := append([]any{&team.ID, &team.Name, &country.Code, &country.Name}, sportFields(&sport)...)
+		err := rows.Scan(append(fields, &team.MemberCount, &team.WrongGenderCount)...)
 		if err != nil {
 			return nil, err
 		}
 
 		team.Country = country
 		team.Sport = sport
+		teams = append(teams, team)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if !withMembers {
+		return teams, nil
+	}
+
+	// Все составы одним запросом, раскладываются по командам уже здесь
+	members, err := s.queryTeamMembers(ctx, "1")
+	if err != nil {
+		return nil, err
+	}
+	for i := range teams {
+		teams[i].Members = members[teams[i].ID]
+	}
+
+	return teams, nil
+}
+
+func (s *sqliteStore) getTeamMembers(ctx context.Context, teamID int) ([]Athlete, error) {
+	members, err := s.queryTeamMembers(ctx, "tm.team_id = ?", teamID)
+	return members[teamID], err
+}
 
-		memberRows, err := db.Query(`
-			SELECT a.id, a.name, a.gender, a.birthday, c2.name
-			FROM team_members tm
-			JOIN athletes a ON a.id = tm.athlete_id
-			JOIN countries c2 ON c2.code = a.country_code
-			WHERE tm.team_id = ?;
-		`, team.ID)
+// Участники команд, подходящих под условие where, по ID команды
+func (s *sqliteStore) queryTeamMembers(ctx context.Context, where string, args ...any) (map[int][]Athlete, error) {
+	members := make(map[int][]Athlete)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT tm.team_id, a.id, a.name, a.gender, a.birthday, c.code, c.name
+		FROM team_members tm
+		JOIN athletes a ON a.id = tm.athlete_id
+		JOIN countries c ON c.code = a.country_code
+		WHERE `+where+`
+		ORDER BY tm.team_id, a.id;
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var teamID int
+		athlete := Athlete{}
+		err := rows.Scan(&teamID, &athlete.ID, &athlete.Name, &athlete.Gender,
+			&athlete.Birthday, &athlete.CountryCode, &athlete.CountryName)
 		if err != nil {
 			return nil, err
 		}
+		members[teamID] = append(members[teamID], athlete)
+	}
 
-		var members []Athlete
-		for memberRows.Next() {
-			athlete := Athlete{}
-			err := memberRows.Scan(&athlete.ID, &athlete.Name, &athlete.Gender,
-				&athlete.Birthday, &athlete.CountryName)
-			if err != nil {
-				memberRows.Close()
-				return nil, err
-			}
-			members = append(members, athlete)
-		}
-		memberRows.Close()
+	return members, rows.Err()
+}
 
-		if err = memberRows.Err(); err != nil {
-			return nil, err
-		}
+func (s *sqliteStore) addTeam(ctx context.Context, name string, countryCode string, sportCode string) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO teams (name, country_code, sport_code) VALUES (?, ?, ?);",
+		name, countryCode, sportCode)
+	return s.undoStep(ctx, err, "Добавление команды %s", name)
+}
 
-		team.Members = members
-		teams = append(teams, team)
+func (s *sqliteStore) updateTeam(ctx context.Context, ID int, name string, countryCode string, sportCode string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+	_, err = tx.ExecContext(ctx, "UPDATE teams SET name = ?, country_code = ?, sport_code = ? WHERE id = ?;",
+		name, countryCode, sportCode, ID)
+	if err != nil {
+		return err
+	}
+	if err := checkTeamMembersCountry(ctx, tx, "tm.team_id = ?", ID); err != nil {
+		return err
 	}
 
-	return teams, nil
+	return s.undoStep(ctx, tx.Commit(), "Изменение команды %s", name)
 }
 
-func addTeam(name string, countryCode string, sportCode string) error {
-	_, err := db.Exec("INSERT INTO teams (name, country_code, sport_code) VALUES (?, ?, ?);",
-		name, countryCode, sportCode)
-	return err
+// Триггер ensure_team_members_country срабатывает только на вставку в team_members,
+// поэтому при смене страны у команды или спортсмена проверяем состав вручную
+func checkTeamMembersCountry(ctx context.Context, tx *sql.Tx, where string, arg any) error {
+	var count int
+	err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM team_members tm
+		JOIN athletes a ON a.id = tm.athlete_id
+		JOIN teams t ON t.id = tm.team_id
+		WHERE a.country_code != t.country_code AND `+where+`;
+	`, arg).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("Спортсмен не соответствует команде по стране")
+	}
+	return nil
 }
 
-func deleteTeam(ID int) error {
-	_, err := db.Exec("DELETE FROM teams WHERE id = ?;", ID)
-	return err
+// Состав команды удаляется каскадно, а результаты в соревнованиях
+// не дают удалить команду
+func (s *sqliteStore) deleteTeam(ctx context.Context, ID int) error {
+	err := s.checkDependents(ctx, fmt.Sprintf("команду №%d", ID), `
+		SELECT 'Результат: соревнование №' || comp.id || ' (' || s.name || '), ' || coalesce('место ' || ct.place, 'без места')
+		FROM competition_teams ct
+		JOIN competitions comp ON comp.id = ct.competition_id
+		JOIN sports s ON s.code = comp.sport_code
+		WHERE ct.team_id = ?;
+	`, ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM teams WHERE id = ?;", ID)
+	return s.undoStep(ctx, err, "Удаление команды №%d", ID)
 }
 
-func addAthleteToTeam(teamID int, athleteID int) error {
-	_, err := db.Exec("INSERT INTO team_members (team_id, athlete_id) VALUES (?, ?);", teamID, athleteID)
-	return err
+func (s *sqliteStore) addAthleteToTeam(ctx context.Context, teamID int, athleteID int) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO team_members (team_id, athlete_id) VALUES (?, ?);", teamID, athleteID)
+	return s.undoStep(ctx, err, "Добавление спортсмена №%d в команду №%d", athleteID, teamID)
 }
 
-func deleteAthleteFromTeam(teamID int, athleteID int) error {
-	_, err := db.Exec("DELETE FROM team_members WHERE team_id = ? AND athlete_id = ?;", teamID, athleteID)
-	return err
+func (s *sqliteStore) deleteAthleteFromTeam(ctx context.Context, teamID int, athleteID int) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM team_members WHERE team_id = ? AND athlete_id = ?;", teamID, athleteID)
+	return s.undoStep(ctx, err, "Исключение спортсмена №%d из команды №%d", athleteID, teamID)
 }
 
-func getCompetitions() ([]Competition, error) {
+func (s *sqliteStore) getCompetitions(ctx context.Context) ([]Competition, error) {
 	var competitions []Competition
 
-	rows, err := db.Query(`
-		SELECT comp.id, comp.time,
-		       s.code, s.name, s.is_team,
-		       st.id, st.name
-		FROM competitions comp
-		JOIN sports s ON s.code = comp.sport_code
-		JOIN sites st ON st.id = comp.site_id
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+competitionColumns("")+`
+		FROM competitions comp`+competitionJoins("")+`
 		ORDER BY comp.time;
 	`)
 	if err != nil {
@@ -329,15 +973,7 @@ func getCompetitions() ([]Competition, error) {
 	for rows.Next() {
 		c := Competition{}
 
-		err := rows.Scan(
-			&c.ID,
-			&c.Time,
-			&c.Sport.Code,
-			&c.Sport.Name,
-			&c.Sport.IsTeam,
-			&c.Site.ID,
-			&c.Site.Name,
-		)
+		err := rows.Scan(competitionFields(&c)...)
 		if err != nil {
 			return nil, err
 		}
@@ -352,24 +988,394 @@ func getCompetitions() ([]Competition, error) {
 	return competitions, nil
 }
 
-func addCompetition(t time.Time, sportCode string, siteID int) error {
-	_, err := db.Exec(`
-		INSERT INTO competitions (time, sport_code, site_id)
-		VALUES (?, ?, ?);
-	`, t.Unix(), sportCode, siteID)
-	return err
+func (s *sqliteStore) getCompetition(ctx context.Context, ID int) (Competition, error) {
+	c := Competition{}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT `+competitionColumns("")+`
+		FROM competitions comp`+competitionJoins("")+`
+		WHERE comp.id = ?;
+	`, ID).Scan(competitionFields(&c)...)
+	if err == sql.ErrNoRows {
+		return c, fmt.Errorf("соревнование %d не найдено", ID)
+	}
+
+	return c, err
+}
+
+// То же самое проверяет CHECK в таблице, но его сообщение
+// пользователю ни о чём не скажет
+func checkCompetitionTime(t time.Time, status CompetitionStatus) error {
+	if t.After(time.Now()) && status != StatusScheduled && status != StatusCancelled {
+		return fmt.Errorf("Соревнование в будущем может быть только запланированным или отменённым")
+	}
+	return nil
+}
+
+// Пересечение с другим соревнованием на том же месте отклоняет триггер.
+// Вид спорта берётся из дисциплины
+func (s *sqliteStore) addCompetition(ctx context.Context, t time.Time, duration int, status CompetitionStatus, eventID int, siteID int) error {
+	if err := checkCompetitionTime(t, status); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO competitions (time, duration, status, sport_code, event_id, site_id)
+		VALUES (?1, ?2, ?3, (SELECT sport_code FROM events WHERE id = ?4), NULLIF(?4, 0), ?5);
+	`, t.Unix(), duration, status, eventID, siteID)
+	return s.undoStep(ctx, err, "Добавление соревнования %s", t.Format("2006-01-02 15:04"))
+}
+
+// Этап меняется тем же запросом, что и остальное: если триггер
+// отклонит этап, не изменится ничего, а в истории это один шаг
+func (s *sqliteStore) updateCompetition(ctx context.Context, ID int, t time.Time, duration int, status CompetitionStatus, eventID int, siteID int,
+	stage CompetitionStage, nextStageID int, advanceCount int) error {
+	if err := checkCompetitionTime(t, status); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE competitions
+		SET time = ?1, duration = ?2, status = ?3,
+		    sport_code = (SELECT sport_code FROM events WHERE id = ?4), event_id = NULLIF(?4, 0), site_id = ?5,
+		    stage = ?6, next_stage_id = NULLIF(?7, 0), advance_count = NULLIF(?8, 0)
+		WHERE id = ?9;
+	`, t.Unix(), duration, status, eventID, siteID, stage, nextStageID, advanceCount, ID)
+	return s.undoStep(ctx, err, "Изменение соревнования №%d", ID)
+}
+
+// Этап соревнования в сетке дисциплины. Связь с другой дисциплиной или
+// с этапом не дальше по сетке отклоняет триггер
+func (s *sqliteStore) setCompetitionStage(ctx context.Context, ID int, stage CompetitionStage, nextStageID int, advanceCount int) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE competitions SET stage = ?1, next_stage_id = NULLIF(?2, 0), advance_count = NULLIF(?3, 0)
+		WHERE id = ?4;
+	`, stage, nextStageID, advanceCount, ID)
+	return s.undoStep(ctx, err, "Изменение этапа соревнования №%d", ID)
+}
+
+// Участники, прошедшие на этап competitionID с предыдущих этапов. В
+// результатах CompetitionID и Place — этап, где прошёл отбор, и место в нём
+func (s *sqliteStore) getStageQualifiers(ctx context.Context, competitionID int) ([]Result, error) {
+	var qualifiers []Result
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT sq.from_competition_id, sq.place, sq.participant_type = 'team', sq.participant_id,
+		       COALESCE(a.name, t.name), c.name
+		FROM stage_qualifiers sq
+		LEFT JOIN athletes a ON sq.participant_type = 'athlete' AND a.id = sq.participant_id
+		LEFT JOIN teams t ON sq.participant_type = 'team' AND t.id = sq.participant_id
+		JOIN countries c ON c.code = COALESCE(a.country_code, t.country_code)
+		WHERE sq.competition_id = ?1
+		ORDER BY sq.place, sq.from_competition_id;
+	`, competitionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := Result{}
+		err := rows.Scan(&r.CompetitionID, &r.Place, &r.IsTeam,
+			&r.ParticipantID, &r.ParticipantName, &r.CountryName)
+		if err != nil {
+			return nil, err
+		}
+		qualifiers = append(qualifiers, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return qualifiers, nil
+}
+
+// Пары соревнований, которые идут на одном месте в одно время. Новые
+// такие пары база не пропустит, но они могли остаться в старых данных.
+// Ключ — ID соревнования, значение — ID тех, с кем оно пересекается
+func (s *sqliteStore) getSiteConflicts(ctx context.Context) (map[int][]int, error) {
+	conflicts := make(map[int][]int)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT a.id, b.id
+		FROM competition_intervals a
+		JOIN competition_intervals b
+		  ON a.site_id = b.site_id AND a.id != b.id
+		 AND a.start < b.finish AND b.start < a.finish
+		WHERE a.status != 'cancelled' AND b.status != 'cancelled'
+		ORDER BY a.id, b.start;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, other int
+		if err := rows.Scan(&id, &other); err != nil {
+			return nil, err
+		}
+		conflicts[id] = append(conflicts[id], other)
+	}
+
+	return conflicts, rows.Err()
+}
+
+// Рабочее время мест проведения, в его пределах ищется свободное окно
+const (
+	siteOpenHour  = 8
+	siteCloseHour = 22
+)
+
+// Самое раннее время в день day, когда на месте siteID можно провести
+// соревнование длительностью duration минут
+func (s *sqliteStore) findFreeSlot(ctx context.Context, siteID int, day time.Time, duration int) (time.Time, error) {
+	opens := time.Date(day.Year(), day.Month(), day.Day(), siteOpenHour, 0, 0, 0, day.Location())
+	closes := time.Date(day.Year(), day.Month(), day.Day(), siteCloseHour, 0, 0, 0, day.Location())
+	length := time.Duration(duration) * time.Minute
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT start, finish
+		FROM competition_intervals
+		WHERE site_id = ? AND status != 'cancelled'
+		  AND start < ? AND finish > ?
+		ORDER BY start;
+	`, siteID, closes.Unix(), opens.Unix())
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer rows.Close()
+
+	slot := opens
+	for rows.Next() {
+		var start, finish int64
+		if err := rows.Scan(&start, &finish); err != nil {
+			return time.Time{}, err
+		}
+		if !slot.Add(length).After(time.Unix(start, 0)) {
+			break
+		}
+		if end := time.Unix(finish, 0).In(day.Location()); end.After(slot) {
+			slot = end
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	if slot.Add(length).After(closes) {
+		return time.Time{}, fmt.Errorf("Свободного времени на месте в этот день нет")
+	}
+	return slot, nil
+}
+
+// Результаты соревнования удаляются каскадно
+func (s *sqliteStore) deleteCompetition(ctx context.Context, ID int) error {
+	// Вместе с соревнованием каскадом удаляются все его результаты, а
+	// предыдущие этапы теряют ссылку на него
+	if _, err := s.snapshot(ctx, "before-delete"); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, "DELETE FROM competitions WHERE id = ?;", ID)
+	return s.undoStep(ctx, err, "Удаление соревнования №%d", ID)
 }
 
-func deleteCompetition(ID int) error {
-	_, err := db.Exec("DELETE FROM competitions WHERE id = ?;", ID)
-	return err
+// Запрос должен возвращать по одной строке-описанию на каждую
+// запись, которая мешает удалению
+func (s *sqliteStore) checkDependents(ctx context.Context, what string, query string, args ...any) error {
+	var dependents []string
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d string
+		if err := rows.Scan(&d); err != nil {
+			return err
+		}
+		dependents = append(dependents, d)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(dependents) > 0 {
+		return &DependentsError{What: what, Dependents: dependents}
+	}
+	return nil
 }
 
+func (s *sqliteStore) getCompetitionResults(ctx context.Context, competitionID int) ([]Result, error) {
+	var results []Result
+
+	// Участники без места идут после расставленных
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT competition_id, coalesce(place, 0), is_team, id, name, country
+		FROM (
+			SELECT ca.competition_id, ca.place, FALSE AS is_team, a.id, a.name, c.name AS country
+			FROM competition_athletes ca
+			JOIN athletes a ON a.id = ca.athlete_id
+			JOIN countries c ON c.code = a.country_code
+			WHERE ca.competition_id = ?1
+			UNION ALL
+			SELECT ct.competition_id, ct.place, TRUE, t.id, t.name, c.name
+			FROM competition_teams ct
+			JOIN teams t ON t.id = ct.team_id
+			JOIN countries c ON c.code = t.country_code
+			WHERE ct.competition_id = ?1
+		)
+		ORDER BY place IS NULL, place;
+	`, competitionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-func getCountryMedals() ([]CountryMedals, error) {
+	for rows.Next() {
+		r := Result{}
+		err := rows.Scan(&r.CompetitionID, &r.Place, &r.IsTeam,
+			&r.ParticipantID, &r.ParticipantName, &r.CountryName)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s *sqliteStore) addAthleteToCompetition(ctx context.Context, competitionID int, athleteID int, place int) error {
+	if err := s.checkAthleteConflicts(ctx, competitionID, false, athleteID); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, "INSERT INTO competition_athletes (competition_id, athlete_id, place) VALUES (?, ?, NULLIF(?, 0));",
+		competitionID, athleteID, place)
+	return s.undoStep(ctx, err, "Результат спортсмена №%d в соревновании №%d", athleteID, competitionID)
+}
+
+func (s *sqliteStore) setAthletePlace(ctx context.Context, competitionID int, athleteID int, place int) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE competition_athletes SET place = NULLIF(?, 0) WHERE competition_id = ? AND athlete_id = ?;",
+		place, competitionID, athleteID)
+	return s.undoStep(ctx, err, "Место спортсмена №%d в соревновании №%d", athleteID, competitionID)
+}
+
+func (s *sqliteStore) deleteAthleteFromCompetition(ctx context.Context, competitionID int, athleteID int) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM competition_athletes WHERE competition_id = ? AND athlete_id = ?;",
+		competitionID, athleteID)
+	return s.undoStep(ctx, err, "Удаление результата спортсмена №%d в соревновании №%d", athleteID, competitionID)
+}
+
+func (s *sqliteStore) addTeamToCompetition(ctx context.Context, competitionID int, teamID int, place int) error {
+	if err := s.checkAthleteConflicts(ctx, competitionID, true, teamID); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, "INSERT INTO competition_teams (competition_id, team_id, place) VALUES (?, ?, NULLIF(?, 0));",
+		competitionID, teamID, place)
+	return s.undoStep(ctx, err, "Результат команды №%d в соревновании №%d", teamID, competitionID)
+}
+
+func (s *sqliteStore) setTeamPlace(ctx context.Context, competitionID int, teamID int, place int) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE competition_teams SET place = NULLIF(?, 0) WHERE competition_id = ? AND team_id = ?;",
+		place, competitionID, teamID)
+	return s.undoStep(ctx, err, "Место команды №%d в соревновании №%d", teamID, competitionID)
+}
+
+func (s *sqliteStore) deleteTeamFromCompetition(ctx context.Context, competitionID int, teamID int) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM competition_teams WHERE competition_id = ? AND team_id = ?;",
+		competitionID, teamID)
+	return s.undoStep(ctx, err, "Удаление результата команды №%d в соревновании №%d", teamID, competitionID)
+}
+
+// Выбирает нужную таблицу (competition_athletes или competition_teams)
+// в зависимости от типа участника
+func (s *sqliteStore) addResult(ctx context.Context, competitionID int, isTeam bool, participantID int, place int) error {
+	if isTeam {
+		return s.addTeamToCompetition(ctx, competitionID, participantID, place)
+	}
+	return s.addAthleteToCompetition(ctx, competitionID, participantID, place)
+}
+
+func (s *sqliteStore) setResultPlace(ctx context.Context, r Result, place int) error {
+	if r.IsTeam {
+		return s.setTeamPlace(ctx, r.CompetitionID, r.ParticipantID, place)
+	}
+	return s.setAthletePlace(ctx, r.CompetitionID, r.ParticipantID, place)
+}
+
+// Записывает в этап competitionID без места всех прошедших на него
+// отбор, кого там ещё нет. Пересечения по времени проверяются заранее
+// для каждого, а записываются все разом или никто
+func (s *sqliteStore) addStageQualifiers(ctx context.Context, competitionID int) error {
+	qualifiers, err := s.getStageQualifiers(ctx, competitionID)
+	if err != nil {
+		return err
+	}
+	entered, err := s.getCompetitionResults(ctx, competitionID)
+	if err != nil {
+		return err
+	}
+	for _, q := range qualifiers {
+		if slices.ContainsFunc(entered, func(r Result) bool { return r.IsTeam == q.IsTeam && r.ParticipantID == q.ParticipantID }) {
+			continue
+		}
+		if err := s.checkAthleteConflicts(ctx, competitionID, q.IsTeam, q.ParticipantID); err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Участник мог пройти сразу с нескольких этапов, поэтому DISTINCT
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO competition_athletes (competition_id, athlete_id)
+		SELECT DISTINCT competition_id, participant_id
+		FROM stage_qualifiers
+		WHERE competition_id = ?1 AND participant_type = 'athlete'
+		  AND participant_id NOT IN ( SELECT athlete_id FROM competition_athletes WHERE competition_id = ?1 );
+	`, competitionID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO competition_teams (competition_id, team_id)
+		SELECT DISTINCT competition_id, participant_id
+		FROM stage_qualifiers
+		WHERE competition_id = ?1 AND participant_type = 'team'
+		  AND participant_id NOT IN ( SELECT team_id FROM competition_teams WHERE competition_id = ?1 );
+	`, competitionID)
+	if err != nil {
+		return err
+	}
+
+	return s.undoStep(ctx, tx.Commit(), "Запись прошедших отбор в соревнование №%d", competitionID)
+}
+
+func (s *sqliteStore) deleteResult(ctx context.Context, r Result) error {
+	if r.IsTeam {
+		return s.deleteTeamFromCompetition(ctx, r.CompetitionID, r.ParticipantID)
+	}
+	return s.deleteAthleteFromCompetition(ctx, r.CompetitionID, r.ParticipantID)
+}
+
+
+// withoutMedals: включать ли страны, не получившие ни одной медали
+func (s *sqliteStore) getCountryMedals(ctx context.Context, withoutMedals bool) ([]CountryMedals, error) {
     var medals []CountryMedals
 
-    rows, err := db.Query("SELECT country, gold, silver, bronze, total FROM country_medals;")
+    rows, err := s.db.QueryContext(ctx, "SELECT country, gold, silver, bronze, total FROM country_medals WHERE ? OR total > 0;",
+        withoutMedals)
     if err != nil {
         return nil, err
     }
@@ -390,3 +1396,120 @@ func getCountryMedals() ([]CountryMedals, error) {
     return medals, nil
 }
 
+// Призёры по дисциплинам, по порядку видов спорта и дисциплин
+func (s *sqliteStore) getEventMedals(ctx context.Context) ([]EventMedal, error) {
+    var medals []EventMedal
+
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT `+eventColumns("e")+`, s.name, em.place, em.participant_name, c.name
+        FROM event_medals em
+        JOIN events e ON e.id = em.event_id
+        JOIN sports s ON s.code = e.sport_code
+        JOIN countries c ON c.code = em.country_code
+        ORDER BY s.name, e.name, em.place, em.participant_name;
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        m := EventMedal{}
+        err := rows.Scan(append(eventFields(&m.Event), &m.SportName, &m.Place, &m.ParticipantName, &m.CountryName)...)
+        if err != nil {
+            return nil, err
+        }
+        medals = append(medals, m)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return medals, nil
+}
+
+func scanConflict(rows *sql.Rows) (AthleteConflict, error) {
+	c := AthleteConflict{}
+	fields := append([]any{&c.AthleteID, &c.AthleteName}, competitionFields(&c.First)...)
+	err := rows.Scan(append(fields, competitionFields(&c.Second)...)...)
+	return c, err
+}
+
+// Соревнования, которые пересекаются по времени с competitionID и в
+// которых уже выступает спортсмен participantID или кто-то из команды
+func (s *sqliteStore) findAthleteConflicts(ctx context.Context, competitionID int, isTeam bool, participantID int) ([]AthleteConflict, error) {
+	var conflicts []AthleteConflict
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH participants(athlete_id) AS (
+			SELECT ?2 WHERE NOT ?1
+			UNION
+			SELECT athlete_id FROM team_members WHERE ?1 AND team_id = ?2
+		)
+		SELECT a.id, a.name, `+competitionColumns("")+`, `+competitionColumns("2")+`
+		FROM participants p
+		JOIN athletes a ON a.id = p.athlete_id
+		JOIN competition_intervals target ON target.id = ?3
+		JOIN athlete_entries ae ON ae.athlete_id = a.id AND ae.competition_id != ?3
+		JOIN competition_intervals ci ON ci.id = ae.competition_id
+		JOIN competitions comp ON comp.id = ?3`+competitionJoins("")+`
+		JOIN competitions comp2 ON comp2.id = ae.competition_id`+competitionJoins("2")+`
+		WHERE target.status != 'cancelled' AND ci.status != 'cancelled'
+		  AND ci.start < target.finish AND target.start < ci.finish
+		ORDER BY a.name, ci.start;
+	`, isTeam, participantID, competitionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanConflict(rows)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, c)
+	}
+
+	return conflicts, rows.Err()
+}
+
+func (s *sqliteStore) checkAthleteConflicts(ctx context.Context, competitionID int, isTeam bool, participantID int) error {
+	conflicts, err := s.findAthleteConflicts(ctx, competitionID, isTeam, participantID)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return &AthleteConflictsError{Conflicts: conflicts}
+	}
+	return nil
+}
+
+// Все пересечения, которые уже есть в базе, например появившиеся после
+// переноса соревнования или добавления спортсмена в команду
+func (s *sqliteStore) getAthleteConflicts(ctx context.Context) ([]AthleteConflict, error) {
+	var conflicts []AthleteConflict
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT a.id, a.name, `+competitionColumns("")+`, `+competitionColumns("2")+`
+		FROM athlete_conflicts ac
+		JOIN athletes a ON a.id = ac.athlete_id
+		JOIN competitions comp ON comp.id = ac.first_id`+competitionJoins("")+`
+		JOIN competitions comp2 ON comp2.id = ac.second_id`+competitionJoins("2")+`
+		ORDER BY a.name, comp.time;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanConflict(rows)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, c)
+	}
+
+	return conflicts, rows.Err()
+}
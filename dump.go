@@ -0,0 +1,619 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Версия формата дампа. Увеличивается, когда старое приложение уже не
+// сможет правильно загрузить новый дамп. Старые версии загружаются:
+//   2 — правила составов у видов спорта
+//   3 — дисциплины и дисциплина у соревнований
+//   4 — этапы соревнований
+//   5 — участники без места (стартовые листы)
+const dumpVersion = 5
+
+// Все данные базы в переносимом виде. Записи ссылаются друг на друга
+// теми же кодами и ID, что и в базе. Время хранится строками в UTC,
+// а не как в базе (там бывают и числа, и строки)
+type Dump struct {
+	Version int `json:"version"`
+	SchemaVersion int `json:"schema_version"`
+	Created string `json:"created"`
+
+	Countries []DumpCountry `json:"countries"`
+	Sports []DumpSport `json:"sports"`
+	Events []DumpEvent `json:"events"`
+	Athletes []DumpAthlete `json:"athletes"`
+	Sites []DumpSite `json:"sites"`
+	Teams []DumpTeam `json:"teams"`
+	TeamMembers []DumpTeamMember `json:"team_members"`
+	Competitions []DumpCompetition `json:"competitions"`
+	AthleteResults []DumpAthleteResult `json:"competition_athletes"`
+	TeamResults []DumpTeamResult `json:"competition_teams"`
+}
+
+type DumpCountry struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+type DumpSport struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	IsTeam bool `json:"is_team"`
+	MinPlayers int `json:"min_players,omitempty"`
+	MaxPlayers int `json:"max_players,omitempty"`
+	TeamGender string `json:"team_gender,omitempty"`
+}
+
+type DumpEvent struct {
+	ID int `json:"id"`
+	Sport string `json:"sport"`
+	Name string `json:"name"`
+	Gender string `json:"gender,omitempty"`
+	MinAge int `json:"min_age,omitempty"`
+	MaxAge int `json:"max_age,omitempty"`
+	WeightClass string `json:"weight_class,omitempty"`
+}
+
+type DumpAthlete struct {
+	ID int `json:"id"`
+	Name string `json:"name"`
+	Gender string `json:"gender"`
+	Birthday string `json:"birthday"`
+	Country string `json:"country"`
+}
+
+type DumpSite struct {
+	ID int `json:"id"`
+	Name string `json:"name"`
+}
+
+type DumpTeam struct {
+	ID int `json:"id"`
+	Name string `json:"name"`
+	Country string `json:"country"`
+	Sport string `json:"sport"`
+}
+
+type DumpTeamMember struct {
+	Team int `json:"team"`
+	Athlete int `json:"athlete"`
+}
+
+type DumpCompetition struct {
+	ID int `json:"id"`
+	Time string `json:"time"`
+	Duration int `json:"duration"`
+	Status CompetitionStatus `json:"status"`
+	Sport string `json:"sport"`
+	Event int `json:"event,omitempty"`
+	Site int `json:"site"`
+	Stage CompetitionStage `json:"stage,omitempty"`
+	NextStage int `json:"next_stage,omitempty"`
+	AdvanceCount int `json:"advance_count,omitempty"`
+}
+
+type DumpAthleteResult struct {
+	Competition int `json:"competition"`
+	Athlete int `json:"athlete"`
+	Place int `json:"place,omitempty"`
+}
+
+type DumpTeamResult struct {
+	Competition int `json:"competition"`
+	Team int `json:"team"`
+	Place int `json:"place,omitempty"`
+}
+
+// Что делать, если запись с таким же ключом уже есть в базе
+type ConflictPolicy string
+
+const (
+	ConflictFail ConflictPolicy = "fail"
+	ConflictSkip ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+)
+
+var conflictPolicies = []ConflictPolicy{ConflictFail, ConflictSkip, ConflictOverwrite}
+
+func (p ConflictPolicy) name() string {
+	switch p {
+	case ConflictFail: return "Прервать"
+	case ConflictSkip: return "Пропустить"
+	case ConflictOverwrite: return "Перезаписать"
+	default: return string(p)
+	}
+}
+
+type RestoreStats struct {
+	Inserted int
+	Updated int
+	Skipped int
+}
+
+const dumpTimeLayout = time.DateTime
+
+// Одна выборка на таблицу, строки добавляются в дамп через scan
+func (s *sqliteStore) dumpQuery(ctx context.Context, query string, scan func(rows *sql.Rows) error) error {
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) dumpDatabase(ctx context.Context) (Dump, error) {
+	d := Dump{Version: dumpVersion, Created: time.Now().UTC().Format(dumpTimeLayout)}
+
+	if err := s.db.QueryRowContext(ctx, "PRAGMA user_version;").Scan(&d.SchemaVersion); err != nil {
+		return d, err
+	}
+
+	err := s.dumpQuery(ctx, "SELECT code, name FROM countries ORDER BY code;", func(rows *sql.Rows) error {
+		var c DumpCountry
+		err := rows.Scan(&c.Code, &c.Name)
+		d.Countries = append(d.Countries, c)
+		return err
+	})
+	if err != nil {
+		return d, err
+	}
+
+	err = s.dumpQuery(ctx, `
+		SELECT code, name, is_team, coalesce(min_players, 0), coalesce(max_players, 0), coalesce(team_gender, '')
+		FROM sports ORDER BY code;
+	`, func(rows *sql.Rows) error {
+		var sport DumpSport
+		err := rows.Scan(&sport.Code, &sport.Name, &sport.IsTeam, &sport.MinPlayers, &sport.MaxPlayers, &sport.TeamGender)
+		d.Sports = append(d.Sports, sport)
+		return err
+	})
+	if err != nil {
+		return d, err
+	}
+
+	err = s.dumpQuery(ctx, `
+		SELECT id, sport_code, name, coalesce(gender, ''), coalesce(min_age, 0), coalesce(max_age, 0), coalesce(weight_class, '')
+		FROM events ORDER BY id;
+	`, func(rows *sql.Rows) error {
+		var e DumpEvent
+		err := rows.Scan(&e.ID, &e.Sport, &e.Name, &e.Gender, &e.MinAge, &e.MaxAge, &e.WeightClass)
+		d.Events = append(d.Events, e)
+		return err
+	})
+	if err != nil {
+		return d, err
+	}
+
+	err = s.dumpQuery(ctx, "SELECT id, name, gender, birthday, country_code FROM athletes ORDER BY id;", func(rows *sql.Rows) error {
+		var a DumpAthlete
+		var birthday time.Time
+		err := rows.Scan(&a.ID, &a.Name, &a.Gender, &birthday, &a.Country)
+		a.Birthday = birthday.UTC().Format(time.DateOnly)
+		d.Athletes = append(d.Athletes, a)
+		return err
+	})
+	if err != nil {
+		return d, err
+	}
+
+	err = s.dumpQuery(ctx, "SELECT id, name FROM sites ORDER BY id;", func(rows *sql.Rows) error {
+		var site DumpSite
+		err := rows.Scan(&site.ID, &site.Name)
+		d.Sites = append(d.Sites, site)
+		return err
+	})
+	if err != nil {
+		return d, err
+	}
+
+	err = s.dumpQuery(ctx, "SELECT id, name, country_code, sport_code FROM teams ORDER BY id;", func(rows *sql.Rows) error {
+		var t DumpTeam
+		err := rows.Scan(&t.ID, &t.Name, &t.Country, &t.Sport)
+		d.Teams = append(d.Teams, t)
+		return err
+	})
+	if err != nil {
+		return d, err
+	}
+
+	err = s.dumpQuery(ctx, "SELECT team_id, athlete_id FROM team_members ORDER BY team_id, athlete_id;", func(rows *sql.Rows) error {
+		var m DumpTeamMember
+		err := rows.Scan(&m.Team, &m.Athlete)
+		d.TeamMembers = append(d.TeamMembers, m)
+		return err
+	})
+	if err != nil {
+		return d, err
+	}
+
+	err = s.dumpQuery(ctx, `
+		SELECT id, time, duration, status, sport_code, event_id, site_id,
+		       stage, coalesce(next_stage_id, 0), coalesce(advance_count, 0)
+		FROM competitions ORDER BY id;
+	`, func(rows *sql.Rows) error {
+		var c DumpCompetition
+		var t time.Time
+		err := rows.Scan(&c.ID, &t, &c.Duration, &c.Status, &c.Sport, &c.Event, &c.Site,
+			&c.Stage, &c.NextStage, &c.AdvanceCount)
+		c.Time = t.UTC().Format(dumpTimeLayout)
+		d.Competitions = append(d.Competitions, c)
+		return err
+	})
+	if err != nil {
+		return d, err
+	}
+
+	err = s.dumpQuery(ctx, `
+		SELECT competition_id, athlete_id, coalesce(place, 0) FROM competition_athletes ORDER BY competition_id, place;
+	`, func(rows *sql.Rows) error {
+		var r DumpAthleteResult
+		err := rows.Scan(&r.Competition, &r.Athlete, &r.Place)
+		d.AthleteResults = append(d.AthleteResults, r)
+		return err
+	})
+	if err != nil {
+		return d, err
+	}
+
+	err = s.dumpQuery(ctx, `
+		SELECT competition_id, team_id, coalesce(place, 0) FROM competition_teams ORDER BY competition_id, place;
+	`, func(rows *sql.Rows) error {
+		var r DumpTeamResult
+		err := rows.Scan(&r.Competition, &r.Team, &r.Place)
+		d.TeamResults = append(d.TeamResults, r)
+		return err
+	})
+
+	return d, err
+}
+
+func (s *sqliteStore) writeDump(ctx context.Context, w io.Writer) error {
+	d, err := s.dumpDatabase(ctx)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+func (s *sqliteStore) dumpToFile(ctx context.Context, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := s.writeDump(ctx, file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func readDump(r io.Reader) (Dump, error) {
+	var d Dump
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return d, err
+	}
+
+	// Версия проверяется до разбора остального: в дампе новой версии
+	// есть поля, о которых это приложение не знает, и ошибка про них
+	// только запутала бы
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return d, fmt.Errorf("некорректный дамп: %w", err)
+	}
+	if header.Version < 1 || header.Version > dumpVersion {
+		return d, fmt.Errorf("версия дампа %d не поддерживается, поддерживается до %d", header.Version, dumpVersion)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&d); err != nil {
+		return d, fmt.Errorf("некорректный дамп: %w", err)
+	}
+
+	return d, nil
+}
+
+// Загружает дамп одной транзакцией: при любой ошибке база остаётся как
+// была. Ключи записей сохраняются, так что при skip записи дампа могут
+// ссылаться на уже существовавшие в базе записи с теми же ключами
+func (s *sqliteStore) restoreDump(ctx context.Context, d Dump, policy ConflictPolicy) (RestoreStats, error) {
+	var stats RestoreStats
+
+	if _, err := s.snapshot(ctx, "before-restore"); err != nil {
+		return stats, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return stats, err
+	}
+	defer tx.Rollback()
+
+	restore := func(table string, keys []string, columns []string, values ...any) error {
+		err := restoreRow(ctx, tx, &stats, policy, table, keys, columns, values)
+		if err != nil {
+			return fmt.Errorf("%s %v: %w", table, values[:len(keys)], err)
+		}
+		return nil
+	}
+
+	for _, c := range d.Countries {
+		if err := restore("countries", []string{"code"}, []string{"name"}, c.Code, c.Name); err != nil {
+			return stats, err
+		}
+	}
+	for _, sport := range d.Sports {
+		if err := restore("sports", []string{"code"}, []string{"name", "is_team", "min_players", "max_players", "team_gender"},
+			sport.Code, sport.Name, sport.IsTeam, nullIfZero(sport.MinPlayers), nullIfZero(sport.MaxPlayers),
+			nullIfZero(sport.TeamGender)); err != nil {
+			return stats, err
+		}
+	}
+	for _, e := range d.Events {
+		if err := restore("events", []string{"id"}, []string{"sport_code", "name", "gender", "min_age", "max_age", "weight_class"},
+			e.ID, e.Sport, e.Name, nullIfZero(e.Gender), nullIfZero(e.MinAge), nullIfZero(e.MaxAge),
+			nullIfZero(e.WeightClass)); err != nil {
+			return stats, err
+		}
+	}
+	for _, a := range d.Athletes {
+		birthday, err := time.Parse(time.DateOnly, a.Birthday)
+		if err != nil {
+			return stats, fmt.Errorf("athletes [%d]: %w", a.ID, err)
+		}
+		if err := restore("athletes", []string{"id"}, []string{"name", "gender", "birthday", "country_code"},
+			a.ID, a.Name, a.Gender, birthday.Unix(), a.Country); err != nil {
+			return stats, err
+		}
+	}
+	for _, site := range d.Sites {
+		if err := restore("sites", []string{"id"}, []string{"name"}, site.ID, site.Name); err != nil {
+			return stats, err
+		}
+	}
+	for _, t := range d.Teams {
+		if err := restore("teams", []string{"id"}, []string{"name", "country_code", "sport_code"},
+			t.ID, t.Name, t.Country, t.Sport); err != nil {
+			return stats, err
+		}
+	}
+	for _, m := range d.TeamMembers {
+		if err := restore("team_members", []string{"team_id", "athlete_id"}, nil,
+			m.Team, m.Athlete); err != nil {
+			return stats, err
+		}
+	}
+	// Этапы ссылаются на следующие, а результаты следующих этапов
+	// проверяются по предыдущим. Поэтому соревнования загружаются от
+	// финалов к первым этапам, а результаты — наоборот. В дампах до
+	// появления этапов все соревнования — финалы
+	competitions := slices.Clone(d.Competitions)
+	athleteResults, teamResults := slices.Clone(d.AthleteResults), slices.Clone(d.TeamResults)
+	stageRank := make(map[int]int)
+	for i, c := range competitions {
+		if c.Stage == "" {
+			competitions[i].Stage = StageFinal
+		}
+		stageRank[c.ID] = competitions[i].Stage.rank()
+	}
+	// Внутри этапа сначала идут участники без места: в завершённое
+	// соревнование их можно записать только до смены статуса, см. ниже
+	byStage := func(aCompetition, aPlace, bCompetition, bPlace int) int {
+		if d := stageRank[aCompetition] - stageRank[bCompetition]; d != 0 {
+			return d
+		}
+		return min(aPlace, 1) - min(bPlace, 1)
+	}
+	slices.SortStableFunc(competitions, func(a, b DumpCompetition) int { return b.Stage.rank() - a.Stage.rank() })
+	slices.SortStableFunc(athleteResults, func(a, b DumpAthleteResult) int {
+		return byStage(a.Competition, a.Place, b.Competition, b.Place)
+	})
+	slices.SortStableFunc(teamResults, func(a, b DumpTeamResult) int {
+		return byStage(a.Competition, a.Place, b.Competition, b.Place)
+	})
+
+	// В завершённое соревнование без места не записывают, в отменённое
+	// не записывают совсем, но такие участники могли остаться с тех
+	// пор, когда соревнование ещё шло. Такие соревнования загружаются
+	// сначала идущими или запланированными, а настоящий статус получают,
+	// когда записаны участники без места (или перед участниками
+	// следующих этапов, которым нужен завершённый этап)
+	hasEntries, hasUnplaced := make(map[int]bool), make(map[int]bool)
+	for _, r := range athleteResults {
+		hasEntries[r.Competition] = true
+		if r.Place == 0 {
+			hasUnplaced[r.Competition] = true
+		}
+	}
+	for _, r := range teamResults {
+		hasEntries[r.Competition] = true
+		if r.Place == 0 {
+			hasUnplaced[r.Competition] = true
+		}
+	}
+	staged := make(map[int]CompetitionStatus)
+	finish := func(id int) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE competitions SET status = ? WHERE id = ?;", staged[id], id); err != nil {
+			return fmt.Errorf("competitions [%d]: %w", id, err)
+		}
+		delete(staged, id)
+		return nil
+	}
+	// Перед участником с местом или участником следующего этапа
+	finishBefore := func(competitionID int, place int) error {
+		for id := range staged {
+			if stageRank[id] < stageRank[competitionID] || id == competitionID && place != 0 {
+				if err := finish(id); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, c := range competitions {
+		t, err := time.Parse(dumpTimeLayout, c.Time)
+		if err != nil {
+			return stats, fmt.Errorf("competitions [%d]: %w", c.ID, err)
+		}
+		// В дампах до появления дисциплин их нет. Тогда, как и при
+		// миграции, соревнование относится к общему зачёту вида спорта
+		if c.Event == 0 {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO events (sport_code, name) SELECT ?1, 'Общий зачёт'
+				WHERE NOT EXISTS (SELECT 1 FROM events WHERE sport_code = ?1);
+			`, c.Sport)
+			if err != nil {
+				return stats, fmt.Errorf("competitions [%d]: %w", c.ID, err)
+			}
+			if c.Event, err = resolveEvent(ctx, tx, c.Sport, ""); err != nil {
+				return stats, fmt.Errorf("competitions [%d]: %w", c.ID, err)
+			}
+		}
+		// Временный статус нужен только новым соревнованиям, у
+		// существующих участники уже записаны
+		var exists bool
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM competitions WHERE id = ?);", c.ID).Scan(&exists)
+		if err != nil {
+			return stats, fmt.Errorf("competitions [%d]: %w", c.ID, err)
+		}
+		status := c.Status
+		switch {
+		case exists:
+		case status == StatusFinished && hasUnplaced[c.ID]: status = StatusInProgress
+		case status == StatusCancelled && hasEntries[c.ID]: status = StatusScheduled
+		}
+		if err := restore("competitions", []string{"id"},
+			[]string{"time", "duration", "status", "sport_code", "event_id", "site_id", "stage", "next_stage_id", "advance_count"},
+			c.ID, t.Unix(), c.Duration, status, c.Sport, c.Event, c.Site,
+			c.Stage, nullIfZero(c.NextStage), nullIfZero(c.AdvanceCount)); err != nil {
+			return stats, err
+		}
+		if status != c.Status {
+			staged[c.ID] = c.Status
+		}
+	}
+	for _, r := range athleteResults {
+		if err := finishBefore(r.Competition, r.Place); err != nil {
+			return stats, err
+		}
+		if err := restore("competition_athletes", []string{"competition_id", "athlete_id"}, []string{"place"},
+			r.Competition, r.Athlete, nullIfZero(r.Place)); err != nil {
+			return stats, err
+		}
+	}
+	for _, r := range teamResults {
+		if err := finishBefore(r.Competition, r.Place); err != nil {
+			return stats, err
+		}
+		if err := restore("competition_teams", []string{"competition_id", "team_id"}, []string{"place"},
+			r.Competition, r.Team, nullIfZero(r.Place)); err != nil {
+			return stats, err
+		}
+	}
+	for id := range staged {
+		if err := finish(id); err != nil {
+			return stats, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return stats, err
+	}
+	return stats, s.recordUndoStep(ctx, "Загрузка дампа")
+}
+
+// values — сначала значения ключей, потом остальных столбцов. Имена
+// таблиц и столбцов задаются только в restoreDump, не пользователем
+func restoreRow(ctx context.Context, tx *sql.Tx, stats *RestoreStats, policy ConflictPolicy,
+	table string, keys []string, columns []string, values []any) error {
+	where := strings.Join(keys, " = ? AND ") + " = ?"
+	keyValues := values[:len(keys)]
+
+	var exists bool
+	err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE %s);", table, where),
+		keyValues...).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		all := append(append([]string{}, keys...), columns...)
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(all)), ", ")
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);",
+			table, strings.Join(all, ", "), placeholders), values...)
+		if err == nil {
+			stats.Inserted++
+		}
+		return err
+	}
+
+	switch policy {
+	case ConflictSkip:
+		stats.Skipped++
+		return nil
+	case ConflictOverwrite:
+		if len(columns) == 0 {
+			stats.Skipped++
+			return nil
+		}
+		set := strings.Join(columns, " = ?, ") + " = ?"
+		args := append(append([]any{}, values[len(keys):]...), keyValues...)
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET %s WHERE %s;", table, set, where), args...)
+		if err == nil {
+			stats.Updated++
+		}
+		return err
+	default:
+		return fmt.Errorf("запись уже есть в базе")
+	}
+}
+
+func (s *sqliteStore) restoreFromFile(ctx context.Context, path string, policy ConflictPolicy) (RestoreStats, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return RestoreStats{}, err
+	}
+	defer file.Close()
+
+	d, err := readDump(file)
+	if err != nil {
+		return RestoreStats{}, err
+	}
+	return s.restoreDump(ctx, d, policy)
+}
+
+// В дампе отсутствие ограничения записывается нулём или пустой строкой,
+// а в базе это NULL
+func nullIfZero[T comparable](v T) any {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Форматы, в которые можно выгрузить любую таблицу
+var exportFormats = []string{"csv", "json", "xlsx"}
+
+// Тип значений столбца. В JSON и XLSX числа и флаги пишутся значениями,
+// а не строками, пустое число — null или пустая ячейка. Тип задаёт
+// построитель таблицы: по тексту ячейки не отличить число от кода "007"
+type columnType int
+
+const (
+	columnText columnType = iota
+	columnInt
+	columnBool
+)
+
+// Тип j-го столбца; столбцы без типа считаются текстом
+func columnTypeAt(types []columnType, j int) columnType {
+	if j < len(types) {
+		return types[j]
+	}
+	return columnText
+}
+
+// Таблицы для выгрузки из списков в том виде, в котором они показаны
+// в интерфейсе (с фильтрами и сортировкой). CLI использует их же,
+// передавая списки целиком
+
+func countriesTable(countries []*Country) ([]string, []columnType, [][]string) {
+	var rows [][]string
+	for _, c := range countries {
+		rows = append(rows, []string{c.Code, c.Name})
+	}
+	return []string{"Код", "Название"}, []columnType{columnText, columnText}, rows
+}
+
+func sportsTable(sports []*Sport) ([]string, []columnType, [][]string) {
+	var rows [][]string
+	for _, s := range sports {
+		rows = append(rows, []string{s.Code, s.Name, strconv.FormatBool(s.IsTeam),
+			strconv.Itoa(s.MinPlayers), strconv.Itoa(s.MaxPlayers), s.TeamGender})
+	}
+	return []string{"Код", "Название", "Командный", "Минимум игроков", "Максимум игроков", "Пол команды"},
+		[]columnType{columnText, columnText, columnBool, columnInt, columnInt, columnText}, rows
+}
+
+func eventsTable(events []*Event) ([]string, []columnType, [][]string) {
+	var rows [][]string
+	for _, e := range events {
+		rows = append(rows, []string{strconv.Itoa(e.ID), e.SportCode, e.Name, e.Gender,
+			strconv.Itoa(e.MinAge), strconv.Itoa(e.MaxAge), e.WeightClass})
+	}
+	return []string{"ID", "Вид спорта", "Название", "Пол", "Возраст от", "Возраст до", "Весовая категория"},
+		[]columnType{columnInt, columnText, columnText, columnText, columnInt, columnInt, columnText}, rows
+}
+
+func athletesTable(athletes []*Athlete) ([]string, []columnType, [][]string) {
+	var rows [][]string
+	for _, a := range athletes {
+		rows = append(rows, []string{strconv.Itoa(a.ID), a.Name, a.Gender,
+			a.Birthday.Format(time.DateOnly), a.CountryCode})
+	}
+	return []string{"ID", "Имя", "Пол", "День рождения", "Страна"},
+		[]columnType{columnInt, columnText, columnText, columnText, columnText}, rows
+}
+
+func sitesTable(sites []*Site) ([]string, []columnType, [][]string) {
+	var rows [][]string
+	for _, s := range sites {
+		rows = append(rows, []string{strconv.Itoa(s.ID), s.Name})
+	}
+	return []string{"ID", "Название"}, []columnType{columnInt, columnText}, rows
+}
+
+func teamsTable(teams []*Team) ([]string, []columnType, [][]string) {
+	var rows [][]string
+	for _, t := range teams {
+		members := make([]string, 0, len(t.Members))
+		for _, m := range t.Members {
+			members = append(members, m.Name)
+		}
+		rows = append(rows, []string{strconv.Itoa(t.ID), t.Name, t.Country.Code, t.Sport.Code,
+			strings.Join(members, ", "), t.rosterProblem()})
+	}
+	return []string{"ID", "Название", "Страна", "Вид спорта", "Участники", "Проблемы состава"},
+		[]columnType{columnInt, columnText, columnText, columnText, columnText, columnText}, rows
+}
+
+func competitionsTable(competitions []*Competition) ([]string, []columnType, [][]string) {
+	var rows [][]string
+	for _, c := range competitions {
+		var next, advance string
+		if c.NextStageID != 0 {
+			next = strconv.Itoa(c.NextStageID)
+		}
+		if c.AdvanceCount != 0 {
+			advance = strconv.Itoa(c.AdvanceCount)
+		}
+		rows = append(rows, []string{strconv.Itoa(c.ID), c.Time.Format("2006-01-02 15:04"),
+			strconv.Itoa(c.Duration), c.Sport.Code, c.Event.Name, c.Site.Name, string(c.Status),
+			string(c.Stage), next, advance})
+	}
+	return []string{"ID", "Дата и время", "Минут", "Вид спорта", "Дисциплина", "Место", "Статус",
+		"Этап", "Следующий этап", "Проходят"},
+		[]columnType{columnInt, columnText, columnInt, columnText, columnText, columnText, columnText,
+			columnText, columnInt, columnInt}, rows
+}
+
+func medalsTable(medals []*CountryMedals) ([]string, []columnType, [][]string) {
+	var rows [][]string
+	for _, m := range medals {
+		rows = append(rows, []string{m.Country, strconv.Itoa(m.Gold), strconv.Itoa(m.Silver),
+			strconv.Itoa(m.Bronze), strconv.Itoa(m.Total)})
+	}
+	return []string{"Страна", "Золото", "Серебро", "Бронза", "Всего"},
+		[]columnType{columnText, columnInt, columnInt, columnInt, columnInt}, rows
+}
+
+func eventMedalsTable(medals []*EventMedal) ([]string, []columnType, [][]string) {
+	var rows [][]string
+	for _, m := range medals {
+		rows = append(rows, []string{m.SportName, m.Event.Name, strconv.Itoa(m.Place), m.ParticipantName, m.CountryName})
+	}
+	return []string{"Вид спорта", "Дисциплина", "Место", "Участник", "Страна"},
+		[]columnType{columnText, columnText, columnInt, columnText, columnText}, rows
+}
+
+func resultsTable(results []*Result) ([]string, []columnType, [][]string) {
+	var rows [][]string
+	for _, r := range results {
+		place := ""
+		if r.Place != 0 {
+			place = strconv.Itoa(r.Place)
+		}
+		rows = append(rows, []string{place, strconv.Itoa(r.ParticipantID),
+			r.ParticipantName, r.CountryName})
+	}
+	return []string{"Место", "ID", "Участник", "Страна"},
+		[]columnType{columnInt, columnInt, columnText, columnText}, rows
+}
+
+// Прошедшие отбор: на каком этапе и каким местом
+func qualifiersTable(qualifiers []*Result) ([]string, []columnType, [][]string) {
+	var rows [][]string
+	for _, r := range qualifiers {
+		rows = append(rows, []string{strconv.Itoa(r.CompetitionID), strconv.Itoa(r.Place),
+			strconv.Itoa(r.ParticipantID), r.ParticipantName, r.CountryName})
+	}
+	return []string{"Этап", "Место", "ID", "Участник", "Страна"},
+		[]columnType{columnInt, columnInt, columnInt, columnText, columnText}, rows
+}
+
+func auditTable(entries []*AuditEntry) ([]string, []columnType, [][]string) {
+	var rows [][]string
+	for _, e := range entries {
+		oldValues, newValues := e.Changes()
+		rows = append(rows, []string{e.Time.Local().Format(time.DateTime), e.Operator,
+			auditTableName(e.Table), auditActionName(e.Action), oldValues, newValues})
+	}
+	return []string{"Время", "Оператор", "Таблица", "Действие", "Было", "Стало"},
+		[]columnType{columnText, columnText, columnText, columnText, columnText, columnText}, rows
+}
+
+// Списки из db.go хранят значения, а таблицам нужны указатели
+func pointers[T any](items []T) []*T {
+	ptrs := make([]*T, len(items))
+	for i := range items {
+		ptrs[i] = &items[i]
+	}
+	return ptrs
+}
+
+// Формат по расширению файла, "" если расширение незнакомое
+func exportFormatFromPath(path string) string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	for _, f := range exportFormats {
+		if f == ext {
+			return f
+		}
+	}
+	return ""
+}
+
+func writeExport(w io.Writer, format string, headers []string, types []columnType, rows [][]string) error {
+	switch format {
+	case "csv": return writeCSV(w, headers, rows)
+	case "json": return writeJSON(w, headers, types, rows)
+	case "xlsx": return writeXLSX(w, headers, types, rows)
+	default: return fmt.Errorf("неизвестный формат %q", format)
+	}
+}
+
+func exportToFile(path string, format string, headers []string, types []columnType, rows [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	if err := writeExport(w, format, headers, types, rows); err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func writeCSV(w io.Writer, headers []string, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	cw.Write(headers)
+	cw.WriteAll(rows)
+	return cw.Error()
+}
+
+// Массив объектов с ключами-заголовками. Порядок ключей сохраняется,
+// поэтому объекты пишутся вручную, а не через map
+func writeJSON(w io.Writer, headers []string, types []columnType, rows [][]string) error {
+	bw := bufio.NewWriter(w)
+
+	bw.WriteString("[")
+	for i, row := range rows {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		bw.WriteString("\n  {")
+		for j, h := range headers {
+			if j > 0 {
+				bw.WriteString(", ")
+			}
+			key, _ := json.Marshal(h)
+			bw.Write(key)
+			bw.WriteString(": ")
+			switch typ := columnTypeAt(types, j); {
+			case j >= len(row) || typ != columnText && row[j] == "":
+				bw.WriteString("null")
+			case typ == columnInt || typ == columnBool:
+				bw.WriteString(row[j])
+			default:
+				value, _ := json.Marshal(row[j])
+				bw.Write(value)
+			}
+		}
+		bw.WriteString("}")
+	}
+	if len(rows) > 0 {
+		bw.WriteString("\n")
+	}
+	bw.WriteString("]\n")
+
+	return bw.Flush()
+}
+
+// Минимальная книга XLSX с одним листом. Строки пишутся прямо в ячейки
+// (inlineStr), так что таблица общих строк и стили не нужны
+var xlsxStaticFiles = []struct{ name, content string }{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Лист1" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+func writeXLSX(w io.Writer, headers []string, types []columnType, rows [][]string) error {
+	zw := zip.NewWriter(w)
+
+	for _, f := range xlsxStaticFiles {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, f.content); err != nil {
+			return err
+		}
+	}
+
+	fw, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	sheet := bufio.NewWriter(fw)
+
+	sheet.WriteString(xml.Header)
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range append([][]string{headers}, rows...) {
+		fmt.Fprintf(sheet, `<row r="%d">`, i+1)
+		for j, v := range row {
+			ref := xlsxColumn(j) + strconv.Itoa(i+1)
+			if typ := columnTypeAt(types, j); i > 0 && typ != columnText {
+				switch {
+				case v == "":
+				case typ == columnBool && v == "true": fmt.Fprintf(sheet, `<c r="%s" t="b"><v>1</v></c>`, ref)
+				case typ == columnBool: fmt.Fprintf(sheet, `<c r="%s" t="b"><v>0</v></c>`, ref)
+				default: fmt.Fprintf(sheet, `<c r="%s"><v>%s</v></c>`, ref, v)
+				}
+				continue
+			}
+			fmt.Fprintf(sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+			if err := xml.EscapeText(sheet, []byte(v)); err != nil {
+				return err
+			}
+			sheet.WriteString(`</t></is></c>`)
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	if err := sheet.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Имя столбца в стиле Excel: 0 -> A, 25 -> Z, 26 -> AA
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
@@ -4,13 +4,5 @@ go 1.25.4
 
 require (
 	github.com/AllenDang/cimgui-go v1.4.1-0.20251124080118-c2099d1a8adc
-	gorm.io/driver/sqlite v1.6.0
-	gorm.io/gorm v1.31.1
-)
-
-require (
-	github.com/jinzhu/inflection v1.0.0 // indirect
-	github.com/jinzhu/now v1.1.5 // indirect
-	github.com/mattn/go-sqlite3 v1.14.22 // indirect
-	golang.org/x/text v0.20.0 // indirect
+	github.com/mattn/go-sqlite3 v1.14.22
 )
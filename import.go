@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Поле сущности, которое заполняется из столбца CSV
+type importField struct {
+	Name string
+	Title string
+	Required bool
+}
+
+// Сущности, которые можно импортировать из CSV, с их полями. Ссылки на
+// другие записи (страна, вид спорта, ...) можно указывать и кодом или
+// ID, и названием
+var importFields = map[string][]importField{
+	"countries": {
+		{"code", "Код", true},
+		{"name", "Название", true},
+	},
+	"sports": {
+		{"code", "Код", true},
+		{"name", "Название", true},
+		{"is_team", "Командный", false},
+		{"min_players", "Минимум игроков", false},
+		{"max_players", "Максимум игроков", false},
+		{"team_gender", "Пол команды", false},
+	},
+	"events": {
+		{"sport", "Вид спорта", true},
+		{"name", "Название", true},
+		{"gender", "Пол", false},
+		{"min_age", "Минимальный возраст", false},
+		{"max_age", "Максимальный возраст", false},
+		{"weight_class", "Весовая категория", false},
+	},
+	"athletes": {
+		{"name", "Имя", true},
+		{"gender", "Пол", true},
+		{"birthday", "День рождения", true},
+		{"country", "Страна", true},
+	},
+	"sites": {
+		{"name", "Название", true},
+	},
+	"teams": {
+		{"name", "Название", true},
+		{"country", "Страна", true},
+		{"sport", "Вид спорта", true},
+	},
+	"members": {
+		{"team", "Команда", true},
+		{"athlete", "Спортсмен", true},
+	},
+	"competitions": {
+		{"time", "Дата и время", true},
+		{"sport", "Вид спорта", true},
+		{"event", "Дисциплина", false},
+		{"site", "Место", true},
+		{"duration", "Длительность", false},
+		{"status", "Статус", false},
+		{"stage", "Этап", false},
+	},
+}
+
+// Подсказка к полю: формат значения и что будет, если его не указать
+func importFieldHelp(f importField) string {
+	switch f.Name {
+	case "birthday": return "ГГГГ-ММ-ДД, дата по UTC"
+	case "time": return "ГГГГ-ММ-ДД ЧЧ:ММ или ГГГГ-ММ-ДД ЧЧ:ММ:СС по UTC"
+	case "duration": return "В минутах, по умолчанию 120"
+	case "status": return "По умолчанию scheduled, если время (по UTC) ещё не наступило, иначе finished"
+	default: return ""
+	}
+}
+
+// Порядок для списков выбора, заодно порядок, в котором удобно
+// загружать данные, чтобы ссылки уже было на что ставить
+var importEntities = []string{"countries", "sports", "events", "athletes", "sites", "teams", "members", "competitions"}
+
+func importEntityName(entity string) string {
+	switch entity {
+	case "countries": return "Страны"
+	case "sports": return "Виды спорта"
+	case "events": return "Дисциплины"
+	case "athletes": return "Спортсмены"
+	case "sites": return "Места проведения"
+	case "teams": return "Команды"
+	case "members": return "Составы команд"
+	case "competitions": return "Соревнования"
+	default: return entity
+	}
+}
+
+// Результат импорта одной строки CSV. Line — номер строки в файле,
+// считая заголовок первой
+type ImportRowResult struct {
+	Line int
+	Err error
+}
+
+func readCSV(path string) ([]string, [][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("файл %s пуст", path)
+	}
+
+	// Excel любит сохранять CSV с BOM
+	records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff")
+
+	return records[0], records[1:], nil
+}
+
+// Сопоставление полей со столбцами по заголовкам: подходит и имя поля,
+// и его название в интерфейсе. -1 — столбец для поля не найден
+func guessImportMapping(entity string, headers []string) []int {
+	fields := importFields[entity]
+	mapping := make([]int, len(fields))
+	for i, f := range fields {
+		mapping[i] = -1
+		for j, h := range headers {
+			h = strings.TrimSpace(h)
+			if strings.EqualFold(h, f.Name) || strings.EqualFold(h, f.Title) {
+				mapping[i] = j
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+// Разбирает разметку вида "name=Имя,country=3": слева поле, справа
+// заголовок или номер столбца с единицы. Поля, которых нет в разметке,
+// сопоставляются по заголовкам
+func parseImportMapping(entity string, headers []string, spec string) ([]int, error) {
+	fields := importFields[entity]
+	mapping := guessImportMapping(entity, headers)
+	if spec == "" {
+		return mapping, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		name, column, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("некорректное сопоставление %q, нужно поле=столбец", pair)
+		}
+
+		field := -1
+		for i, f := range fields {
+			if f.Name == name {
+				field = i
+			}
+		}
+		if field == -1 {
+			return nil, fmt.Errorf("у сущности %s нет поля %q", entity, name)
+		}
+
+		mapping[field] = -1
+		if n, err := strconv.Atoi(column); err == nil && n >= 1 && n <= len(headers) {
+			mapping[field] = n - 1
+		} else {
+			for j, h := range headers {
+				if strings.TrimSpace(h) == column {
+					mapping[field] = j
+				}
+			}
+		}
+		if mapping[field] == -1 {
+			return nil, fmt.Errorf("столбец %q не найден", column)
+		}
+	}
+
+	return mapping, nil
+}
+
+// Вставляет записи одной транзакцией. Ошибки собираются по каждой
+// строке, а не на первой: ABORT в SQLite откатывает только упавший
+// запрос, а не всю транзакцию. Если хоть одна строка не прошла или
+// dryRun, транзакция откатывается, так что база меняется только целиком.
+// Возвращаемая ошибка — только если импорт не удалось провести вообще
+func (s *sqliteStore) importCSV(ctx context.Context, entity string, records [][]string, mapping []int, dryRun bool) ([]ImportRowResult, error) {
+	fields, ok := importFields[entity]
+	if !ok {
+		return nil, fmt.Errorf("неизвестная сущность %q", entity)
+	}
+	for i, f := range fields {
+		if f.Required && mapping[i] == -1 {
+			return nil, fmt.Errorf("не выбран столбец для поля \"%s\"", f.Title)
+		}
+	}
+
+	if !dryRun {
+		if _, err := s.snapshot(ctx, "before-import"); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]ImportRowResult, len(records))
+	failed := false
+	for i, record := range records {
+		row := make(map[string]string, len(fields))
+		for j, f := range fields {
+			if mapping[j] >= 0 && mapping[j] < len(record) {
+				row[f.Name] = strings.TrimSpace(record[mapping[j]])
+			}
+		}
+
+		results[i] = ImportRowResult{Line: i + 2, Err: importRow(ctx, tx, entity, row)}
+		if results[i].Err != nil {
+			failed = true
+		}
+	}
+
+	if failed || dryRun {
+		return results, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return results, err
+	}
+	return results, s.recordUndoStep(ctx, fmt.Sprintf("Импорт из CSV: %s", importEntityName(entity)))
+}
+
+func importRow(ctx context.Context, tx *sql.Tx, entity string, row map[string]string) error {
+	for _, f := range importFields[entity] {
+		if f.Required && row[f.Name] == "" {
+			return fmt.Errorf("не заполнено поле \"%s\"", f.Title)
+		}
+	}
+
+	var err error
+	switch entity {
+	case "countries":
+		_, err = tx.ExecContext(ctx, "INSERT INTO countries (code, name) VALUES (?, ?);", row["code"], row["name"])
+	case "sports":
+		isTeam := false
+		if row["is_team"] != "" {
+			if isTeam, err = parseBool(row["is_team"]); err != nil {
+				return err
+			}
+		}
+		var players [2]int
+		for i, field := range []string{"min_players", "max_players"} {
+			if row[field] != "" {
+				if players[i], err = strconv.Atoi(row[field]); err != nil {
+					return fmt.Errorf("некорректное число игроков %q", row[field])
+				}
+			}
+		}
+		teamGender, err := parseTeamGender(row["team_gender"])
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO sports (code, name, is_team, min_players, max_players, team_gender)
+			VALUES (?, ?, ?, ?, ?, ?);
+		`, row["code"], row["name"], isTeam, nullIfZero(players[0]), nullIfZero(players[1]), nullIfZero(teamGender))
+		return err
+	case "events":
+		sport, err := resolveRef(ctx, tx, "sports", "code", row["sport"])
+		if err != nil {
+			return err
+		}
+		gender, err := parseTeamGender(row["gender"])
+		if err != nil {
+			return err
+		}
+		var ages [2]int
+		for i, field := range []string{"min_age", "max_age"} {
+			if row[field] != "" {
+				if ages[i], err = strconv.Atoi(row[field]); err != nil {
+					return fmt.Errorf("некорректный возраст %q", row[field])
+				}
+			}
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO events (sport_code, name, gender, min_age, max_age, weight_class)
+			VALUES (?, ?, ?, ?, ?, ?);
+		`, sport, row["name"], nullIfZero(gender), nullIfZero(ages[0]), nullIfZero(ages[1]), nullIfZero(row["weight_class"]))
+		return err
+	case "athletes":
+		isMale, err := parseGender(row["gender"])
+		if err != nil {
+			return err
+		}
+		birthday, err := time.ParseInLocation(time.DateOnly, row["birthday"], time.UTC)
+		if err != nil {
+			return fmt.Errorf("некорректная дата %q, нужно ГГГГ-ММ-ДД", row["birthday"])
+		}
+		country, err := resolveRef(ctx, tx, "countries", "code", row["country"])
+		if err != nil {
+			return err
+		}
+		var gender string
+		if isMale {
+			gender = "M"
+		} else {
+			gender = "F"
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO athletes (name, gender, birthday, country_code) VALUES (?, ?, ?, ?);",
+			row["name"], gender, birthday.Unix(), country)
+		return err
+	case "sites":
+		_, err = tx.ExecContext(ctx, "INSERT INTO sites (name) VALUES (?);", row["name"])
+	case "teams":
+		country, err := resolveRef(ctx, tx, "countries", "code", row["country"])
+		if err != nil {
+			return err
+		}
+		sport, err := resolveRef(ctx, tx, "sports", "code", row["sport"])
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO teams (name, country_code, sport_code) VALUES (?, ?, ?);",
+			row["name"], country, sport)
+		return err
+	case "members":
+		team, err := resolveRef(ctx, tx, "teams", "id", row["team"])
+		if err != nil {
+			return err
+		}
+		athlete, err := resolveRef(ctx, tx, "athletes", "id", row["athlete"])
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO team_members (team_id, athlete_id) VALUES (?, ?);", team, athlete)
+		return err
+	case "competitions":
+		t, err := parseCompetitionTime(row["time"])
+		if err != nil {
+			return err
+		}
+		duration := 120
+		if row["duration"] != "" {
+			if duration, err = strconv.Atoi(row["duration"]); err != nil {
+				return fmt.Errorf("некорректная длительность %q", row["duration"])
+			}
+		}
+		status := CompetitionStatus(row["status"])
+		if status == "" {
+			status = StatusFinished
+			if t.After(time.Now()) {
+				status = StatusScheduled
+			}
+		}
+		if err := checkCompetitionTime(t, status); err != nil {
+			return err
+		}
+		sport, err := resolveRef(ctx, tx, "sports", "code", row["sport"])
+		if err != nil {
+			return err
+		}
+		stage := CompetitionStage(row["stage"])
+		if stage == "" {
+			stage = StageFinal
+		}
+		if !slices.Contains(competitionStages, stage) {
+			return fmt.Errorf("неизвестный этап %q", row["stage"])
+		}
+		event, err := resolveEvent(ctx, tx, sport, row["event"])
+		if err != nil {
+			return err
+		}
+		site, err := resolveRef(ctx, tx, "sites", "id", row["site"])
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO competitions (time, duration, status, sport_code, event_id, site_id, stage)
+			VALUES (?, ?, ?, ?, ?, ?, ?);
+		`, t.Unix(), duration, status, sport, event, site, stage)
+		return err
+	}
+
+	return err
+}
+
+// Находит ключ записи в table по значению ключа key или по названию.
+// Названия могут повторяться (спортсмены-тёзки), тогда нужен ключ
+func resolveRef(ctx context.Context, tx *sql.Tx, table string, key string, value string) (any, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(
+		"SELECT %[1]s FROM %[2]s WHERE CAST(%[1]s AS TEXT) = ?1 OR name = ?1 ORDER BY CAST(%[1]s AS TEXT) = ?1 DESC;",
+		key, table), value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []any
+	for rows.Next() {
+		var v any
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		found = append(found, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(found) == 0:
+		return nil, fmt.Errorf("%q не найдено в таблице %s", value, table)
+	case len(found) > 1 && fmt.Sprint(found[0]) != value:
+		return nil, fmt.Errorf("%q в таблице %s встречается несколько раз, укажите %s", value, table, key)
+	default:
+		return found[0], nil
+	}
+}
+
+// Находит дисциплину вида спорта по ID или названию. Названия дисциплин
+// повторяются в разных видах спорта, поэтому resolveRef не подходит.
+// Если дисциплина не указана, берётся единственная дисциплина вида спорта
+func resolveEvent(ctx context.Context, tx *sql.Tx, sport any, value string) (int, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id FROM events
+		WHERE sport_code = ?1 AND (?2 = '' OR CAST(id AS TEXT) = ?2 OR name = ?2)
+		ORDER BY CAST(id AS TEXT) = ?2 DESC;
+	`, sport, value)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var found []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		found = append(found, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case len(found) == 0 && value == "":
+		return 0, fmt.Errorf("у вида спорта %v нет дисциплин", sport)
+	case len(found) == 0:
+		return 0, fmt.Errorf("дисциплина %q не найдена у вида спорта %v", value, sport)
+	case len(found) > 1 && value == "":
+		return 0, fmt.Errorf("у вида спорта %v несколько дисциплин — укажите дисциплину", sport)
+	default:
+		return found[0], nil
+	}
+}
+
+func parseGender(s string) (bool, error) {
+	switch strings.ToUpper(s) {
+	case "M", "М": return true, nil
+	case "F", "Ж": return false, nil
+	default: return false, fmt.Errorf("пол должен быть M или F")
+	}
+}
+
+// Пол участников команд как в базе, пустая строка — смешанные команды
+func parseTeamGender(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	isMale, err := parseGender(s)
+	if isMale {
+		return "M", err
+	}
+	return "F", err
+}
+
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "true", "да", "yes": return true, nil
+	case "0", "false", "нет", "no": return false, nil
+	default: return false, fmt.Errorf("некорректное логическое значение %q", s)
+	}
+}
+
+// Время в файле — по UTC, как и при вводе в интерфейсе и CLI, и не
+// зависит от часового пояса машины, на которой идёт импорт
+func parseCompetitionTime(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04", time.DateTime} {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("некорректное время %q, нужно ГГГГ-ММ-ДД ЧЧ:ММ", s)
+}
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
 	"runtime"
 	"unsafe"
 	_ "embed"
@@ -21,8 +26,55 @@ func init() {
 }
 
 func main() {
-	if err := dbOpen("db.sqlite3"); err != nil {
-		panic(err)
+	dbPathFlag := flag.String("db", os.Getenv(dbPathEnv), "путь к файлу базы данных")
+	flag.StringVar(&operator, "operator", defaultOperator(), "имя оператора для журнала изменений")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), cliUsage)
+	}
+	flag.Parse()
+
+	gui := flag.NArg() == 0
+
+	// Без явного пути интерфейс открывает последнюю базу, с которой
+	// работали, а не создаёт новую в текущем каталоге
+	path := *dbPathFlag
+	if path == "" && gui {
+		for _, recent := range loadRecentFiles() {
+			if _, err := os.Stat(recent); err == nil {
+				path = recent
+				break
+			}
+		}
+	}
+	if path == "" {
+		path = "db.sqlite3"
+	}
+
+	// Ctrl+C прерывает долгую команду (импорт, загрузку дампа), и её
+	// транзакция откатывается
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	store, err := openSQLiteStore(ctx, path, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if !gui {
+		err := runCLI(ctx, store, flag.Args())
+		store.close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	addRecentFile(path)
+
+	if _, err := store.snapshot(ctx, "startup"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 	}
 
 	currentBackend, _ = backend.CreateBackend(glfwbackend.NewGLFWBackend())
@@ -37,7 +89,8 @@ func main() {
 
 	currentBackend.CreateWindow("Олимпиада", 1200, 900)
 
-	initUI()
+	initUI(store)
 	currentBackend.Run(runUI)
+	// Изменения, отправленные перед закрытием окна, должны дойти до базы
+	<-uiState.worker.stop()
 }
-
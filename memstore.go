@@ -0,0 +1,1632 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Хранилище в памяти для проверок без файла базы. Повторяет ограничения
+// схемы, которые заметны пользователю: уникальные ключи, ссылки между
+// записями, каскадные удаления, статусы соревнований и пересечения по
+// времени, с теми же сообщениями об ошибках
+type memStore struct {
+	mu sync.Mutex
+
+	countries []Country
+	sports []Sport
+	events []Event
+	athletes []memAthlete
+	sites []Site
+	teams []memTeam
+	members []memMember
+	competitions []memCompetition
+	athleteResults []memResult
+	teamResults []memResult
+}
+
+// Записи хранятся как строки таблиц: со ссылками по ключам, а не с
+// вложенными структурами, чтобы смена кода страны и т.п. работала так же
+
+type memAthlete struct {
+	ID int
+	Name string
+	Gender string
+	Birthday time.Time
+	CountryCode string
+}
+
+type memTeam struct {
+	ID int
+	Name string
+	CountryCode string
+	SportCode string
+}
+
+type memMember struct {
+	TeamID int
+	AthleteID int
+}
+
+type memCompetition struct {
+	ID int
+	Time time.Time
+	Duration int
+	Status CompetitionStatus
+	SportCode string
+	EventID int
+	SiteID int
+	Stage CompetitionStage
+	NextStageID int
+	AdvanceCount int
+}
+
+type memResult struct {
+	CompetitionID int
+	ParticipantID int
+	Place int
+}
+
+func newMemStore() *memStore {
+	return &memStore{}
+}
+
+func (m *memStore) close() error {
+	return nil
+}
+
+func (m *memStore) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	return nil
+}
+
+// Как INTEGER PRIMARY KEY без AUTOINCREMENT: на единицу больше
+// наибольшего существующего
+func nextID[T any](items []T, id func(T) int) int {
+	next := 1
+	for _, item := range items {
+		next = max(next, id(item)+1)
+	}
+	return next
+}
+
+var errForeignKey = fmt.Errorf("FOREIGN KEY constraint failed")
+
+func errUnique(columns string) error {
+	return fmt.Errorf("UNIQUE constraint failed: %s", columns)
+}
+
+func errCheck(table string) error {
+	return fmt.Errorf("CHECK constraint failed: %s", table)
+}
+
+func (m *memStore) countryIndex(code string) int {
+	return slices.IndexFunc(m.countries, func(c Country) bool { return c.Code == code })
+}
+
+func (m *memStore) sportIndex(code string) int {
+	return slices.IndexFunc(m.sports, func(s Sport) bool { return s.Code == code })
+}
+
+func (m *memStore) eventIndex(ID int) int {
+	return slices.IndexFunc(m.events, func(e Event) bool { return e.ID == ID })
+}
+
+func (m *memStore) athleteIndex(ID int) int {
+	return slices.IndexFunc(m.athletes, func(a memAthlete) bool { return a.ID == ID })
+}
+
+func (m *memStore) siteIndex(ID int) int {
+	return slices.IndexFunc(m.sites, func(s Site) bool { return s.ID == ID })
+}
+
+func (m *memStore) teamIndex(ID int) int {
+	return slices.IndexFunc(m.teams, func(t memTeam) bool { return t.ID == ID })
+}
+
+func (m *memStore) competitionIndex(ID int) int {
+	return slices.IndexFunc(m.competitions, func(c memCompetition) bool { return c.ID == ID })
+}
+
+func (m *memStore) athlete(a memAthlete) Athlete {
+	return Athlete{
+		ID: a.ID,
+		Name: a.Name,
+		Gender: a.Gender,
+		Birthday: a.Birthday,
+		CountryCode: a.CountryCode,
+		CountryName: m.countries[m.countryIndex(a.CountryCode)].Name,
+	}
+}
+
+func (m *memStore) team(t memTeam, withMembers bool) Team {
+	team := Team{
+		ID: t.ID,
+		Name: t.Name,
+		Country: m.countries[m.countryIndex(t.CountryCode)],
+		Sport: m.sports[m.sportIndex(t.SportCode)],
+	}
+	members := m.teamMembers(t.ID)
+	team.MemberCount = len(members)
+	team.WrongGenderCount = m.wrongGenderCount(t.ID, team.Sport.TeamGender)
+	if withMembers {
+		team.Members = members
+	}
+	return team
+}
+
+func (m *memStore) teamMembers(teamID int) []Athlete {
+	var members []Athlete
+	for _, tm := range m.members {
+		if tm.TeamID == teamID {
+			members = append(members, m.athlete(m.athletes[m.athleteIndex(tm.AthleteID)]))
+		}
+	}
+	slices.SortFunc(members, func(a, b Athlete) int { return a.ID - b.ID })
+	return members
+}
+
+// Участники команды не пола gender, для смешанных команд их нет
+func (m *memStore) wrongGenderCount(teamID int, gender string) int {
+	count := 0
+	for _, tm := range m.members {
+		if tm.TeamID == teamID && gender != "" && m.athletes[m.athleteIndex(tm.AthleteID)].Gender != gender {
+			count++
+		}
+	}
+	return count
+}
+
+func (m *memStore) memberCount(teamID int) int {
+	count := 0
+	for _, tm := range m.members {
+		if tm.TeamID == teamID {
+			count++
+		}
+	}
+	return count
+}
+
+// Подходит ли состав команды под правила вида спорта sport, как при
+// смене вида спорта у команды в ensure_team_rules_sport_change
+func (m *memStore) checkRoster(teamID int, sport Sport) error {
+	if !sport.IsTeam {
+		return fmt.Errorf("Индивидуальный вид спорта — команду создать нельзя")
+	}
+	if m.wrongGenderCount(teamID, sport.TeamGender) > 0 {
+		return fmt.Errorf("Спортсмен не подходит команде по полу")
+	}
+	if sport.MaxPlayers > 0 && m.memberCount(teamID) > sport.MaxPlayers {
+		return fmt.Errorf("В команде больше игроков, чем разрешено")
+	}
+	return nil
+}
+
+func (m *memStore) competition(c memCompetition) Competition {
+	return Competition{
+		ID: c.ID,
+		Time: c.Time,
+		Duration: c.Duration,
+		Status: c.Status,
+		Sport: m.sports[m.sportIndex(c.SportCode)],
+		Event: m.events[m.eventIndex(c.EventID)],
+		Site: m.sites[m.siteIndex(c.SiteID)],
+		Stage: c.Stage,
+		NextStageID: c.NextStageID,
+		AdvanceCount: c.AdvanceCount,
+	}
+}
+
+func (m *memStore) getCountries(ctx context.Context) ([]Country, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.countries), nil
+}
+
+func (m *memStore) checkCountry(c Country, except int) error {
+	if c.Code == "" || c.Name == "" {
+		return errCheck("countries")
+	}
+	for i, other := range m.countries {
+		if i == except {
+			continue
+		}
+		if other.Code == c.Code {
+			return errUnique("countries.code")
+		}
+		if other.Name == c.Name {
+			return errUnique("countries.name")
+		}
+	}
+	return nil
+}
+
+func (m *memStore) addCountry(ctx context.Context, code string, name string) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	c := Country{Code: code, Name: name}
+	if err := m.checkCountry(c, -1); err != nil {
+		return err
+	}
+	m.countries = append(m.countries, c)
+	return nil
+}
+
+func (m *memStore) updateCountry(ctx context.Context, oldCode string, code string, name string) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	i := m.countryIndex(oldCode)
+	if i == -1 {
+		return nil
+	}
+	c := Country{Code: code, Name: name}
+	if err := m.checkCountry(c, i); err != nil {
+		return err
+	}
+	m.countries[i] = c
+
+	// ON UPDATE CASCADE
+	for j := range m.athletes {
+		if m.athletes[j].CountryCode == oldCode {
+			m.athletes[j].CountryCode = code
+		}
+	}
+	for j := range m.teams {
+		if m.teams[j].CountryCode == oldCode {
+			m.teams[j].CountryCode = code
+		}
+	}
+	return nil
+}
+
+func (m *memStore) deleteCountry(ctx context.Context, code string) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	var dependents []string
+	for _, a := range m.athletes {
+		if a.CountryCode == code {
+			dependents = append(dependents, "Спортсмен: "+a.Name)
+		}
+	}
+	for _, t := range m.teams {
+		if t.CountryCode == code {
+			dependents = append(dependents, "Команда: "+t.Name)
+		}
+	}
+	if len(dependents) > 0 {
+		return &DependentsError{What: "страну " + code, Dependents: dependents}
+	}
+
+	m.countries = slices.DeleteFunc(m.countries, func(c Country) bool { return c.Code == code })
+	return nil
+}
+
+func (m *memStore) getSports(ctx context.Context) ([]Sport, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.sports), nil
+}
+
+func (m *memStore) checkSport(s Sport, except int) error {
+	if s.Code == "" || s.Name == "" || s.MinPlayers < 0 ||
+		s.MaxPlayers < 0 || (s.MaxPlayers > 0 && s.MaxPlayers < max(s.MinPlayers, 1)) ||
+		(s.TeamGender != "" && s.TeamGender != "M" && s.TeamGender != "F") {
+		return errCheck("sports")
+	}
+	for i, other := range m.sports {
+		if i == except {
+			continue
+		}
+		if other.Code == s.Code {
+			return errUnique("sports.code")
+		}
+		if other.Name == s.Name {
+			return errUnique("sports.name")
+		}
+	}
+	return nil
+}
+
+func (m *memStore) addSport(ctx context.Context, sport Sport) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	if err := m.checkSport(sport, -1); err != nil {
+		return err
+	}
+	m.sports = append(m.sports, sport)
+	return nil
+}
+
+func (m *memStore) updateSport(ctx context.Context, oldCode string, sport Sport) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	i := m.sportIndex(oldCode)
+	if i == -1 {
+		return nil
+	}
+	if err := m.checkSport(sport, i); err != nil {
+		return err
+	}
+
+	// Как в триггерах ensure_sport_with_teams и ensure_team_rules_change:
+	// правила проверяются, только если они поменялись
+	old := m.sports[i]
+	for _, t := range m.teams {
+		if t.SportCode != oldCode {
+			continue
+		}
+		if !sport.IsTeam {
+			return fmt.Errorf("У вида спорта есть команды — сначала удалите их")
+		}
+		if sport.TeamGender != old.TeamGender && m.wrongGenderCount(t.ID, sport.TeamGender) > 0 {
+			return fmt.Errorf("В командах этого вида спорта есть спортсмены другого пола")
+		}
+		if sport.MaxPlayers != old.MaxPlayers && sport.MaxPlayers > 0 && m.memberCount(t.ID) > sport.MaxPlayers {
+			return fmt.Errorf("В командах этого вида спорта больше игроков, чем новый максимум")
+		}
+	}
+	m.sports[i] = sport
+
+	for j := range m.teams {
+		if m.teams[j].SportCode == oldCode {
+			m.teams[j].SportCode = sport.Code
+		}
+	}
+	for j := range m.events {
+		if m.events[j].SportCode == oldCode {
+			m.events[j].SportCode = sport.Code
+		}
+	}
+	for j := range m.competitions {
+		if m.competitions[j].SportCode == oldCode {
+			m.competitions[j].SportCode = sport.Code
+		}
+	}
+	return nil
+}
+
+func (m *memStore) deleteSport(ctx context.Context, code string) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	var dependents []string
+	for _, t := range m.teams {
+		if t.SportCode == code {
+			dependents = append(dependents, "Команда: "+t.Name)
+		}
+	}
+	for _, c := range m.competitions {
+		if c.SportCode == code {
+			dependents = append(dependents, fmt.Sprintf("Соревнование №%d", c.ID))
+		}
+	}
+	if len(dependents) > 0 {
+		return &DependentsError{What: "вид спорта " + code, Dependents: dependents}
+	}
+
+	m.sports = slices.DeleteFunc(m.sports, func(s Sport) bool { return s.Code == code })
+	m.events = slices.DeleteFunc(m.events, func(e Event) bool { return e.SportCode == code })
+	return nil
+}
+
+// Как getEvents у sqliteStore: по коду вида спорта и названию
+func (m *memStore) getEvents(ctx context.Context) ([]Event, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	events := slices.Clone(m.events)
+	slices.SortStableFunc(events, func(a, b Event) int {
+		if c := compareStrings(a.SportCode, b.SportCode); c != 0 {
+			return c
+		}
+		return compareStrings(a.Name, b.Name)
+	})
+	return events, nil
+}
+
+func (m *memStore) checkEvent(e Event) error {
+	if e.Name == "" || (e.Gender != "" && e.Gender != "M" && e.Gender != "F") || e.MinAge < 0 ||
+		e.MaxAge < 0 || (e.MaxAge > 0 && e.MaxAge < max(e.MinAge, 1)) {
+		return errCheck("events")
+	}
+	if m.sportIndex(e.SportCode) == -1 {
+		return errForeignKey
+	}
+	for _, other := range m.events {
+		if other.ID != e.ID && other.SportCode == e.SportCode && other.Name == e.Name {
+			return errUnique("events.sport_code, events.name")
+		}
+	}
+	return nil
+}
+
+func (m *memStore) addEvent(ctx context.Context, e Event) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	e.ID = nextID(m.events, func(e Event) int { return e.ID })
+	if err := m.checkEvent(e); err != nil {
+		return err
+	}
+	m.events = append(m.events, e)
+	return nil
+}
+
+func (m *memStore) updateEvent(ctx context.Context, e Event) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	i := m.eventIndex(e.ID)
+	if i == -1 {
+		return nil
+	}
+	old := m.events[i]
+	e.SportCode = old.SportCode
+	if err := m.checkEvent(e); err != nil {
+		return err
+	}
+	// Как триггер ensure_event_rules_change
+	if e.Gender != old.Gender || e.MinAge != old.MinAge || e.MaxAge != old.MaxAge {
+		for _, c := range m.competitions {
+			if c.EventID == e.ID && m.hasResults(c.ID) {
+				return fmt.Errorf("По дисциплине уже есть результаты — условия участия менять нельзя")
+			}
+		}
+	}
+	m.events[i] = e
+	return nil
+}
+
+func (m *memStore) deleteEvent(ctx context.Context, ID int) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	var dependents []string
+	for _, c := range m.competitions {
+		if c.EventID == ID {
+			dependents = append(dependents, fmt.Sprintf("Соревнование №%d", c.ID))
+		}
+	}
+	if len(dependents) > 0 {
+		return &DependentsError{What: fmt.Sprintf("дисциплину №%d", ID), Dependents: dependents}
+	}
+
+	m.events = slices.DeleteFunc(m.events, func(e Event) bool { return e.ID == ID })
+	return nil
+}
+
+func (m *memStore) getAthletes(ctx context.Context) ([]Athlete, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	athletes := make([]Athlete, 0, len(m.athletes))
+	for _, a := range m.athletes {
+		athletes = append(athletes, m.athlete(a))
+	}
+	return athletes, nil
+}
+
+func (m *memStore) getAthleteProfile(ctx context.Context, ID int) (AthleteProfile, error) {
+	p := AthleteProfile{}
+
+	if err := m.lock(ctx); err != nil {
+		return p, err
+	}
+	defer m.mu.Unlock()
+
+	i := m.athleteIndex(ID)
+	if i == -1 {
+		return p, fmt.Errorf("спортсмен %d не найден", ID)
+	}
+	p.Athlete = m.athlete(m.athletes[i])
+
+	var teamIDs []int
+	for _, tm := range m.members {
+		if tm.AthleteID == ID {
+			teamIDs = append(teamIDs, tm.TeamID)
+			p.Teams = append(p.Teams, m.team(m.teams[m.teamIndex(tm.TeamID)], false))
+		}
+	}
+	slices.SortFunc(p.Teams, func(a, b Team) int { return compareStrings(a.Name, b.Name) })
+
+	for _, r := range m.athleteResults {
+		if r.ParticipantID == ID {
+			p.Competitions = append(p.Competitions, AthleteCompetition{
+				Competition: m.competition(m.competitions[m.competitionIndex(r.CompetitionID)]),
+				Place: r.Place,
+			})
+		}
+	}
+	for _, r := range m.teamResults {
+		if slices.Contains(teamIDs, r.ParticipantID) {
+			p.Competitions = append(p.Competitions, AthleteCompetition{
+				Competition: m.competition(m.competitions[m.competitionIndex(r.CompetitionID)]),
+				Place: r.Place,
+				TeamName: m.teams[m.teamIndex(r.ParticipantID)].Name,
+			})
+		}
+	}
+	slices.SortStableFunc(p.Competitions, func(a, b AthleteCompetition) int {
+		return a.Competition.Time.Compare(b.Competition.Time)
+	})
+
+	for _, c := range p.Competitions {
+		if c.Competition.Stage != StageFinal || c.Competition.Status != StatusFinished {
+			continue
+		}
+		switch c.Place {
+		case 1: p.Gold++
+		case 2: p.Silver++
+		case 3: p.Bronze++
+		}
+	}
+	return p, nil
+}
+
+func (m *memStore) checkAthlete(a memAthlete) error {
+	if a.Name == "" {
+		return errCheck("athletes")
+	}
+	if m.countryIndex(a.CountryCode) == -1 {
+		return errForeignKey
+	}
+	return nil
+}
+
+func (m *memStore) addAthlete(ctx context.Context, name string, isMale bool, birthday time.Time, countryCode string) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	a := memAthlete{
+		ID: nextID(m.athletes, func(a memAthlete) int { return a.ID }),
+		Name: name,
+		Gender: genderCode(isMale),
+		Birthday: birthday,
+		CountryCode: countryCode,
+	}
+	if err := m.checkAthlete(a); err != nil {
+		return err
+	}
+	m.athletes = append(m.athletes, a)
+	return nil
+}
+
+func (m *memStore) updateAthlete(ctx context.Context, ID int, name string, isMale bool, birthday time.Time, countryCode string) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	i := m.athleteIndex(ID)
+	if i == -1 {
+		return nil
+	}
+	a := memAthlete{ID: ID, Name: name, Gender: genderCode(isMale), Birthday: birthday, CountryCode: countryCode}
+	if err := m.checkAthlete(a); err != nil {
+		return err
+	}
+	// Как триггер ensure_event_athlete_update
+	if a.Gender != m.athletes[i].Gender || !a.Birthday.Equal(m.athletes[i].Birthday) {
+		var entries []memCompetition
+		for _, competitionID := range m.athleteEntries(ID) {
+			entries = append(entries, m.competitions[m.competitionIndex(competitionID)])
+		}
+		switch byGender, byAge := m.fitsCompetitions(m.athlete(a), entries); {
+		case !byGender: return fmt.Errorf("Спортсмен записан в соревнования, дисциплине которых он не подойдёт по полу")
+		case !byAge: return fmt.Errorf("Спортсмен записан в соревнования, дисциплине которых он не подойдёт по возрасту")
+		}
+	}
+	for _, tm := range m.members {
+		if tm.AthleteID != ID {
+			continue
+		}
+		t := m.teams[m.teamIndex(tm.TeamID)]
+		if t.CountryCode != countryCode {
+			return fmt.Errorf("Спортсмен не соответствует команде по стране")
+		}
+		gender := m.sports[m.sportIndex(t.SportCode)].TeamGender
+		if a.Gender != m.athletes[i].Gender && gender != "" && a.Gender != gender {
+			return fmt.Errorf("Спортсмен не подходит команде по полу")
+		}
+	}
+	m.athletes[i] = a
+	return nil
+}
+
+func (m *memStore) deleteAthlete(ctx context.Context, ID int) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	var dependents []string
+	for _, r := range m.athleteResults {
+		if r.ParticipantID == ID {
+			c := m.competition(m.competitions[m.competitionIndex(r.CompetitionID)])
+			dependents = append(dependents, fmt.Sprintf("Результат: соревнование №%d (%s), %s",
+				c.ID, c.Sport.Name, placeName(r.Place)))
+		}
+	}
+	if len(dependents) > 0 {
+		return &DependentsError{What: fmt.Sprintf("спортсмена №%d", ID), Dependents: dependents}
+	}
+
+	m.athletes = slices.DeleteFunc(m.athletes, func(a memAthlete) bool { return a.ID == ID })
+	m.members = slices.DeleteFunc(m.members, func(tm memMember) bool { return tm.AthleteID == ID })
+	return nil
+}
+
+func (m *memStore) getSites(ctx context.Context) ([]Site, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.sites), nil
+}
+
+func (m *memStore) checkSite(s Site) error {
+	if s.Name == "" {
+		return errCheck("sites")
+	}
+	for _, other := range m.sites {
+		if other.ID != s.ID && other.Name == s.Name {
+			return errUnique("sites.name")
+		}
+	}
+	return nil
+}
+
+func (m *memStore) addSite(ctx context.Context, name string) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	s := Site{ID: nextID(m.sites, func(s Site) int { return s.ID }), Name: name}
+	if err := m.checkSite(s); err != nil {
+		return err
+	}
+	m.sites = append(m.sites, s)
+	return nil
+}
+
+func (m *memStore) updateSite(ctx context.Context, ID int, name string) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	i := m.siteIndex(ID)
+	if i == -1 {
+		return nil
+	}
+	s := Site{ID: ID, Name: name}
+	if err := m.checkSite(s); err != nil {
+		return err
+	}
+	m.sites[i] = s
+	return nil
+}
+
+func (m *memStore) deleteSite(ctx context.Context, ID int) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	var dependents []string
+	for _, c := range m.competitions {
+		if c.SiteID == ID {
+			dependents = append(dependents, fmt.Sprintf("Соревнование №%d (%s)",
+				c.ID, m.sports[m.sportIndex(c.SportCode)].Name))
+		}
+	}
+	if len(dependents) > 0 {
+		return &DependentsError{What: fmt.Sprintf("место проведения №%d", ID), Dependents: dependents}
+	}
+
+	m.sites = slices.DeleteFunc(m.sites, func(s Site) bool { return s.ID == ID })
+	return nil
+}
+
+func (m *memStore) getTeams(ctx context.Context, withMembers bool) ([]Team, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	teams := make([]Team, 0, len(m.teams))
+	for _, t := range m.teams {
+		teams = append(teams, m.team(t, withMembers))
+	}
+	return teams, nil
+}
+
+func (m *memStore) getTeamMembers(ctx context.Context, teamID int) ([]Athlete, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	return m.teamMembers(teamID), nil
+}
+
+func (m *memStore) checkTeam(t memTeam) error {
+	if t.Name == "" {
+		return errCheck("teams")
+	}
+	if m.countryIndex(t.CountryCode) == -1 || m.sportIndex(t.SportCode) == -1 {
+		return errForeignKey
+	}
+	return nil
+}
+
+func (m *memStore) addTeam(ctx context.Context, name string, countryCode string, sportCode string) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	t := memTeam{
+		ID: nextID(m.teams, func(t memTeam) int { return t.ID }),
+		Name: name,
+		CountryCode: countryCode,
+		SportCode: sportCode,
+	}
+	if err := m.checkTeam(t); err != nil {
+		return err
+	}
+	if !m.sports[m.sportIndex(sportCode)].IsTeam {
+		return fmt.Errorf("Индивидуальный вид спорта — команду создать нельзя")
+	}
+	m.teams = append(m.teams, t)
+	return nil
+}
+
+func (m *memStore) updateTeam(ctx context.Context, ID int, name string, countryCode string, sportCode string) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	i := m.teamIndex(ID)
+	if i == -1 {
+		return nil
+	}
+	t := memTeam{ID: ID, Name: name, CountryCode: countryCode, SportCode: sportCode}
+	if err := m.checkTeam(t); err != nil {
+		return err
+	}
+	if sportCode != m.teams[i].SportCode {
+		if err := m.checkRoster(ID, m.sports[m.sportIndex(sportCode)]); err != nil {
+			return err
+		}
+	}
+	for _, tm := range m.members {
+		if tm.TeamID == ID && m.athletes[m.athleteIndex(tm.AthleteID)].CountryCode != countryCode {
+			return fmt.Errorf("Спортсмен не соответствует команде по стране")
+		}
+	}
+	m.teams[i] = t
+	return nil
+}
+
+func (m *memStore) deleteTeam(ctx context.Context, ID int) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	var dependents []string
+	for _, r := range m.teamResults {
+		if r.ParticipantID == ID {
+			c := m.competition(m.competitions[m.competitionIndex(r.CompetitionID)])
+			dependents = append(dependents, fmt.Sprintf("Результат: соревнование №%d (%s), %s",
+				c.ID, c.Sport.Name, placeName(r.Place)))
+		}
+	}
+	if len(dependents) > 0 {
+		return &DependentsError{What: fmt.Sprintf("команду №%d", ID), Dependents: dependents}
+	}
+
+	m.teams = slices.DeleteFunc(m.teams, func(t memTeam) bool { return t.ID == ID })
+	m.members = slices.DeleteFunc(m.members, func(tm memMember) bool { return tm.TeamID == ID })
+	return nil
+}
+
+func (m *memStore) addAthleteToTeam(ctx context.Context, teamID int, athleteID int) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	ti, ai := m.teamIndex(teamID), m.athleteIndex(athleteID)
+	if ti == -1 || ai == -1 {
+		return errForeignKey
+	}
+	if slices.Contains(m.members, memMember{teamID, athleteID}) {
+		return errUnique("team_members.team_id, team_members.athlete_id")
+	}
+	// Как триггер ensure_event_team_members
+	var entries []memCompetition
+	for _, r := range m.teamResults {
+		if r.ParticipantID == teamID {
+			entries = append(entries, m.competitions[m.competitionIndex(r.CompetitionID)])
+		}
+	}
+	switch byGender, byAge := m.fitsCompetitions(m.athlete(m.athletes[ai]), entries); {
+	case !byGender: return fmt.Errorf("Команда записана в соревнования, дисциплине которых спортсмен не подходит по полу")
+	case !byAge: return fmt.Errorf("Команда записана в соревнования, дисциплине которых спортсмен не подходит по возрасту")
+	}
+	if m.teams[ti].CountryCode != m.athletes[ai].CountryCode {
+		return fmt.Errorf("Спортсмен не соответствует команде по стране")
+	}
+	sport := m.sports[m.sportIndex(m.teams[ti].SportCode)]
+	if sport.TeamGender != "" && m.athletes[ai].Gender != sport.TeamGender {
+		return fmt.Errorf("Спортсмен не подходит команде по полу")
+	}
+	if sport.MaxPlayers > 0 && m.memberCount(teamID) >= sport.MaxPlayers {
+		return fmt.Errorf("Состав команды уже полный")
+	}
+	m.members = append(m.members, memMember{teamID, athleteID})
+	return nil
+}
+
+func (m *memStore) deleteAthleteFromTeam(ctx context.Context, teamID int, athleteID int) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	m.members = slices.DeleteFunc(m.members, func(tm memMember) bool {
+		return tm.TeamID == teamID && tm.AthleteID == athleteID
+	})
+	return nil
+}
+
+func (m *memStore) getCompetitions(ctx context.Context) ([]Competition, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	competitions := make([]Competition, 0, len(m.competitions))
+	for _, c := range m.competitions {
+		competitions = append(competitions, m.competition(c))
+	}
+	slices.SortStableFunc(competitions, func(a, b Competition) int { return a.Time.Compare(b.Time) })
+	return competitions, nil
+}
+
+func (m *memStore) getCompetition(ctx context.Context, ID int) (Competition, error) {
+	if err := m.lock(ctx); err != nil {
+		return Competition{}, err
+	}
+	defer m.mu.Unlock()
+
+	i := m.competitionIndex(ID)
+	if i == -1 {
+		return Competition{}, fmt.Errorf("соревнование %d не найдено", ID)
+	}
+	return m.competition(m.competitions[i]), nil
+}
+
+func (c memCompetition) end() time.Time {
+	return c.Time.Add(time.Duration(c.Duration) * time.Minute)
+}
+
+func (c memCompetition) overlaps(other memCompetition) bool {
+	return c.Status != StatusCancelled && other.Status != StatusCancelled &&
+		c.Time.Before(other.end()) && other.Time.Before(c.end())
+}
+
+func (m *memStore) hasResults(competitionID int) bool {
+	isResult := func(r memResult) bool { return r.CompetitionID == competitionID }
+	return slices.ContainsFunc(m.athleteResults, isResult) || slices.ContainsFunc(m.teamResults, isResult)
+}
+
+// Есть ли у соревнования участники с местом, а не только стартовый лист
+func (m *memStore) hasPlaces(competitionID int) bool {
+	isPlaced := func(r memResult) bool { return r.CompetitionID == competitionID && r.Place != 0 }
+	return slices.ContainsFunc(m.athleteResults, isPlaced) || slices.ContainsFunc(m.teamResults, isPlaced)
+}
+
+// Ограничения таблицы и триггеры ensure_site_free* и ensure_competition_event
+func (m *memStore) checkCompetition(c memCompetition) error {
+	if err := checkCompetitionTime(c.Time, c.Status); err != nil {
+		return err
+	}
+	if c.EventID == 0 {
+		return fmt.Errorf("Не указана дисциплина соревнования")
+	}
+	if c.Duration <= 0 || !slices.Contains(competitionStatuses, c.Status) {
+		return errCheck("competitions")
+	}
+	if m.eventIndex(c.EventID) == -1 || m.siteIndex(c.SiteID) == -1 {
+		return errForeignKey
+	}
+	for _, other := range m.competitions {
+		if other.ID != c.ID && other.SiteID == c.SiteID && c.overlaps(other) {
+			return fmt.Errorf("Место уже занято другим соревнованием в это время")
+		}
+	}
+	return nil
+}
+
+// Вид спорта соревнования, как и в sqliteStore, берётся из дисциплины
+func (m *memStore) eventSport(eventID int) string {
+	if i := m.eventIndex(eventID); i != -1 {
+		return m.events[i].SportCode
+	}
+	return ""
+}
+
+func (m *memStore) addCompetition(ctx context.Context, t time.Time, duration int, status CompetitionStatus, eventID int, siteID int) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	c := memCompetition{
+		ID: nextID(m.competitions, func(c memCompetition) int { return c.ID }),
+		Time: t,
+		Duration: duration,
+		Status: status,
+		SportCode: m.eventSport(eventID),
+		EventID: eventID,
+		SiteID: siteID,
+		Stage: StageFinal,
+	}
+	if err := m.checkCompetition(c); err != nil {
+		return err
+	}
+	m.competitions = append(m.competitions, c)
+	return nil
+}
+
+func (m *memStore) updateCompetition(ctx context.Context, ID int, t time.Time, duration int, status CompetitionStatus, eventID int, siteID int,
+	stage CompetitionStage, nextStageID int, advanceCount int) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	i := m.competitionIndex(ID)
+	if i == -1 {
+		return nil
+	}
+	old := m.competitions[i]
+	c := old
+	c.Time, c.Duration, c.Status = t, duration, status
+	c.SportCode, c.EventID, c.SiteID = m.eventSport(eventID), eventID, siteID
+	c.Stage, c.NextStageID, c.AdvanceCount = stage, nextStageID, advanceCount
+	if err := m.checkCompetition(c); err != nil {
+		return err
+	}
+	// Как триггер ensure_finished_with_results: места бывают только у
+	// завершённых
+	if status != old.Status && status != StatusFinished && m.hasPlaces(ID) {
+		return fmt.Errorf("У соревнования есть результаты — сначала удалите их")
+	}
+	// Как триггер ensure_event_time_update
+	if !t.Equal(old.Time) {
+		for _, athleteID := range m.competitionAthletes(ID) {
+			athlete := m.athlete(m.athletes[m.athleteIndex(athleteID)])
+			if _, byAge := m.fitsCompetitions(athlete, []memCompetition{c}); !byAge {
+				return fmt.Errorf("Участники соревнования не подойдут дисциплине по возрасту на новую дату")
+			}
+		}
+	}
+	// Как триггер ensure_stage_results_status
+	if old.Status == StatusFinished && status != StatusFinished && c.NextStageID != 0 && m.hasResults(c.NextStageID) {
+		return fmt.Errorf("На следующий этап уже записаны участники — результаты этапа менять нельзя")
+	}
+	if eventID != old.EventID && m.hasResults(ID) {
+		return fmt.Errorf("У соревнования есть результаты — сначала удалите их")
+	}
+	if err := m.checkStage(c, old); err != nil {
+		return err
+	}
+	m.competitions[i] = c
+	return nil
+}
+
+func (m *memStore) deleteCompetition(ctx context.Context, ID int) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	isResult := func(r memResult) bool { return r.CompetitionID == ID }
+	m.competitions = slices.DeleteFunc(m.competitions, func(c memCompetition) bool { return c.ID == ID })
+	m.athleteResults = slices.DeleteFunc(m.athleteResults, isResult)
+	m.teamResults = slices.DeleteFunc(m.teamResults, isResult)
+	// ON DELETE SET NULL
+	for i := range m.competitions {
+		if m.competitions[i].NextStageID == ID {
+			m.competitions[i].NextStageID = 0
+		}
+	}
+	return nil
+}
+
+// Как триггер ensure_competition_stage_update, old — запись до изменения
+func (m *memStore) checkStage(c memCompetition, old memCompetition) error {
+	if !slices.Contains(competitionStages, c.Stage) || c.AdvanceCount < 0 {
+		return errCheck("competitions")
+	}
+	if c.NextStageID != 0 {
+		i := m.competitionIndex(c.NextStageID)
+		switch {
+		case c.Stage == StageFinal: return fmt.Errorf("Из финала некуда проходить дальше")
+		case i == -1: return errForeignKey
+		case m.competitions[i].EventID != c.EventID: return fmt.Errorf("Следующий этап относится к другой дисциплине")
+		case m.competitions[i].Stage.rank() <= c.Stage.rank(): return fmt.Errorf("Следующий этап должен быть дальше по сетке")
+		}
+	}
+	for _, p := range m.competitions {
+		if p.NextStageID == c.ID && p.ID != c.ID && (p.EventID != c.EventID || p.Stage.rank() >= c.Stage.rank()) {
+			return fmt.Errorf("Этап не сходится с предыдущими этапами сетки")
+		}
+	}
+	if c.Stage != old.Stage && m.hasResults(c.ID) {
+		return fmt.Errorf("У соревнования есть результаты — сначала удалите их")
+	}
+	if c.NextStageID != 0 && (c.NextStageID != old.NextStageID || c.AdvanceCount != old.AdvanceCount) &&
+		(m.hasResults(old.NextStageID) || m.hasResults(c.NextStageID)) {
+		return fmt.Errorf("Следующий этап уже проведён — условия прохода менять нельзя")
+	}
+	return nil
+}
+
+func (m *memStore) setCompetitionStage(ctx context.Context, ID int, stage CompetitionStage, nextStageID int, advanceCount int) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	i := m.competitionIndex(ID)
+	if i == -1 {
+		return nil
+	}
+	c := m.competitions[i]
+	c.Stage, c.NextStageID, c.AdvanceCount = stage, nextStageID, advanceCount
+	if err := m.checkStage(c, m.competitions[i]); err != nil {
+		return err
+	}
+	m.competitions[i] = c
+	return nil
+}
+
+func (m *memStore) getSiteConflicts(ctx context.Context) (map[int][]int, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	byStart := slices.Clone(m.competitions)
+	slices.SortStableFunc(byStart, func(a, b memCompetition) int { return a.Time.Compare(b.Time) })
+
+	conflicts := make(map[int][]int)
+	for _, a := range m.competitions {
+		for _, b := range byStart {
+			if a.ID != b.ID && a.SiteID == b.SiteID && a.overlaps(b) {
+				conflicts[a.ID] = append(conflicts[a.ID], b.ID)
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+func (m *memStore) findFreeSlot(ctx context.Context, siteID int, day time.Time, duration int) (time.Time, error) {
+	if err := m.lock(ctx); err != nil {
+		return time.Time{}, err
+	}
+	defer m.mu.Unlock()
+
+	opens := time.Date(day.Year(), day.Month(), day.Day(), siteOpenHour, 0, 0, 0, day.Location())
+	closes := time.Date(day.Year(), day.Month(), day.Day(), siteCloseHour, 0, 0, 0, day.Location())
+	length := time.Duration(duration) * time.Minute
+
+	var busy []memCompetition
+	for _, c := range m.competitions {
+		if c.SiteID == siteID && c.Status != StatusCancelled && c.Time.Before(closes) && c.end().After(opens) {
+			busy = append(busy, c)
+		}
+	}
+	slices.SortStableFunc(busy, func(a, b memCompetition) int { return a.Time.Compare(b.Time) })
+
+	slot := opens
+	for _, c := range busy {
+		if !slot.Add(length).After(c.Time) {
+			break
+		}
+		if end := c.end().In(day.Location()); end.After(slot) {
+			slot = end
+		}
+	}
+
+	if slot.Add(length).After(closes) {
+		return time.Time{}, fmt.Errorf("Свободного времени на месте в этот день нет")
+	}
+	return slot, nil
+}
+
+// Как представление athlete_entries: соревнования, в которых спортсмен
+// выступает сам или в составе команды
+func (m *memStore) athleteEntries(athleteID int) []int {
+	var entries []int
+	for _, r := range m.athleteResults {
+		if r.ParticipantID == athleteID {
+			entries = append(entries, r.CompetitionID)
+		}
+	}
+	for _, r := range m.teamResults {
+		if slices.Contains(m.members, memMember{r.ParticipantID, athleteID}) &&
+			!slices.Contains(entries, r.CompetitionID) {
+			entries = append(entries, r.CompetitionID)
+		}
+	}
+	return entries
+}
+
+// Спортсмены соревнования, сами или в составе команд
+func (m *memStore) competitionAthletes(competitionID int) []int {
+	var athletes []int
+	for _, a := range m.athletes {
+		if slices.Contains(m.athleteEntries(a.ID), competitionID) {
+			athletes = append(athletes, a.ID)
+		}
+	}
+	return athletes
+}
+
+// Подходит ли спортсмен дисциплинам всех соревнований по полу и по
+// возрасту, как при записи в каждое из них
+func (m *memStore) fitsCompetitions(a Athlete, competitions []memCompetition) (byGender bool, byAge bool) {
+	byGender, byAge = true, true
+	for _, c := range competitions {
+		g, ag := m.events[m.eventIndex(c.EventID)].fits(a, c.Time)
+		byGender, byAge = byGender && g, byAge && ag
+	}
+	return byGender, byAge
+}
+
+func (m *memStore) conflict(athleteID int, firstID int, secondID int) AthleteConflict {
+	return AthleteConflict{
+		AthleteID: athleteID,
+		AthleteName: m.athletes[m.athleteIndex(athleteID)].Name,
+		First: m.competition(m.competitions[m.competitionIndex(firstID)]),
+		Second: m.competition(m.competitions[m.competitionIndex(secondID)]),
+	}
+}
+
+func sortConflicts(conflicts []AthleteConflict, byFirst bool) {
+	slices.SortStableFunc(conflicts, func(a, b AthleteConflict) int {
+		if c := compareStrings(a.AthleteName, b.AthleteName); c != 0 {
+			return c
+		}
+		if byFirst {
+			return a.First.Time.Compare(b.First.Time)
+		}
+		return a.Second.Time.Compare(b.Second.Time)
+	})
+}
+
+func (m *memStore) getAthleteConflicts(ctx context.Context) ([]AthleteConflict, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	var conflicts []AthleteConflict
+	for _, a := range m.athletes {
+		entries := m.athleteEntries(a.ID)
+		for _, first := range entries {
+			for _, second := range entries {
+				c1 := m.competitions[m.competitionIndex(first)]
+				c2 := m.competitions[m.competitionIndex(second)]
+				if second > first && c1.overlaps(c2) {
+					conflicts = append(conflicts, m.conflict(a.ID, first, second))
+				}
+			}
+		}
+	}
+	sortConflicts(conflicts, true)
+	return conflicts, nil
+}
+
+func (m *memStore) getCompetitionResults(ctx context.Context, competitionID int) ([]Result, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	return m.competitionResults(competitionID), nil
+}
+
+func (m *memStore) competitionResults(competitionID int) []Result {
+	var results []Result
+	for _, r := range m.athleteResults {
+		if r.CompetitionID == competitionID {
+			a := m.athlete(m.athletes[m.athleteIndex(r.ParticipantID)])
+			results = append(results, Result{CompetitionID: competitionID, Place: r.Place,
+				ParticipantID: a.ID, ParticipantName: a.Name, CountryName: a.CountryName})
+		}
+	}
+	for _, r := range m.teamResults {
+		if r.CompetitionID == competitionID {
+			t := m.team(m.teams[m.teamIndex(r.ParticipantID)], false)
+			results = append(results, Result{CompetitionID: competitionID, Place: r.Place, IsTeam: true,
+				ParticipantID: t.ID, ParticipantName: t.Name, CountryName: t.Country.Name})
+		}
+	}
+	slices.SortStableFunc(results, func(a, b Result) int { return comparePlaces(a.Place, b.Place) })
+	return results
+}
+
+func (m *memStore) getStageQualifiers(ctx context.Context, competitionID int) ([]Result, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	return m.stageQualifiers(competitionID), nil
+}
+
+// Как представление stage_qualifiers
+func (m *memStore) stageQualifiers(competitionID int) []Result {
+	var qualifiers []Result
+	for _, p := range m.competitions {
+		if p.NextStageID != competitionID || p.Status != StatusFinished {
+			continue
+		}
+		for _, r := range m.competitionResults(p.ID) {
+			if r.Place != 0 && (p.AdvanceCount == 0 || r.Place <= p.AdvanceCount) {
+				qualifiers = append(qualifiers, r)
+			}
+		}
+	}
+	slices.SortStableFunc(qualifiers, func(a, b Result) int {
+		if a.Place != b.Place {
+			return a.Place - b.Place
+		}
+		return a.CompetitionID - b.CompetitionID
+	})
+	return qualifiers
+}
+
+func (m *memStore) hasPreviousStages(competitionID int) bool {
+	return slices.ContainsFunc(m.competitions, func(c memCompetition) bool { return c.NextStageID == competitionID })
+}
+
+func (m *memStore) addResult(ctx context.Context, competitionID int, isTeam bool, participantID int, place int) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	return m.insertResult(competitionID, isTeam, participantID, place)
+}
+
+// Тело addResult без блокировки — общее с addStageQualifiers
+func (m *memStore) insertResult(competitionID int, isTeam bool, participantID int, place int) error {
+	ci := m.competitionIndex(competitionID)
+	if ci == -1 {
+		return errForeignKey
+	}
+	if isTeam && m.teamIndex(participantID) == -1 || !isTeam && m.athleteIndex(participantID) == -1 {
+		return errForeignKey
+	}
+
+	// Проверки как в addAthleteToCompetition/addTeamToCompetition и
+	// триггерах ensure_*_sport и ensure_finished_*
+	target := m.competitions[ci]
+	var participants []int
+	if isTeam {
+		for _, tm := range m.members {
+			if tm.TeamID == participantID {
+				participants = append(participants, tm.AthleteID)
+			}
+		}
+	} else {
+		participants = []int{participantID}
+	}
+	var conflicts []AthleteConflict
+	for _, athleteID := range participants {
+		for _, other := range m.athleteEntries(athleteID) {
+			if other != competitionID && target.overlaps(m.competitions[m.competitionIndex(other)]) {
+				conflicts = append(conflicts, m.conflict(athleteID, competitionID, other))
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		sortConflicts(conflicts, false)
+		return &AthleteConflictsError{Conflicts: conflicts}
+	}
+
+	if err := checkPlace(target, place, true); err != nil {
+		return err
+	}
+	isTeamSport := m.sports[m.sportIndex(target.SportCode)].IsTeam
+	if isTeam && !isTeamSport {
+		return fmt.Errorf("Индивидуальный спорт — нельзя добавлять команду")
+	}
+	if !isTeam && isTeamSport {
+		return fmt.Errorf("Командный спорт — нельзя добавлять одиночного атлета")
+	}
+
+	// Как триггеры ensure_event_athlete и ensure_event_team
+	event := m.events[m.eventIndex(target.EventID)]
+	for _, athleteID := range participants {
+		byGender, byAge := event.fits(m.athlete(m.athletes[m.athleteIndex(athleteID)]), target.Time)
+		switch {
+		case !byGender && isTeam: return fmt.Errorf("В команде есть спортсмены, не подходящие дисциплине по полу")
+		case !byGender: return fmt.Errorf("Спортсмен не подходит дисциплине по полу")
+		case !byAge && isTeam: return fmt.Errorf("В команде есть спортсмены, не подходящие дисциплине по возрасту")
+		case !byAge: return fmt.Errorf("Спортсмен не подходит дисциплине по возрасту")
+		}
+	}
+
+	// Как триггеры ensure_stage_qualified_*
+	if m.hasPreviousStages(competitionID) && !slices.ContainsFunc(m.stageQualifiers(competitionID), func(r Result) bool {
+		return r.IsTeam == isTeam && r.ParticipantID == participantID
+	}) {
+		return fmt.Errorf("Участник не прошёл отбор на этот этап")
+	}
+
+	results, table := &m.athleteResults, "competition_athletes.competition_id, competition_athletes.athlete_id"
+	if isTeam {
+		results, table = &m.teamResults, "competition_teams.competition_id, competition_teams.team_id"
+	}
+	if slices.ContainsFunc(*results, func(r memResult) bool {
+		return r.CompetitionID == competitionID && r.ParticipantID == participantID
+	}) {
+		return errUnique(table)
+	}
+	*results = append(*results, memResult{competitionID, participantID, place})
+	return nil
+}
+
+func (m *memStore) addStageQualifiers(ctx context.Context, competitionID int) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	// Как транзакция в sqliteStore: записываются все или никто
+	athleteResults, teamResults := slices.Clone(m.athleteResults), slices.Clone(m.teamResults)
+	entered := m.competitionResults(competitionID)
+	for _, q := range m.stageQualifiers(competitionID) {
+		if slices.ContainsFunc(entered, func(r Result) bool { return r.IsTeam == q.IsTeam && r.ParticipantID == q.ParticipantID }) {
+			continue
+		}
+		if err := m.insertResult(competitionID, q.IsTeam, q.ParticipantID, 0); err != nil {
+			m.athleteResults, m.teamResults = athleteResults, teamResults
+			return err
+		}
+		entered = append(entered, Result{IsTeam: q.IsTeam, ParticipantID: q.ParticipantID})
+	}
+	return nil
+}
+
+func (m *memStore) results(isTeam bool) []memResult {
+	if isTeam {
+		return m.teamResults
+	}
+	return m.athleteResults
+}
+
+func (m *memStore) setResultPlace(ctx context.Context, r Result, place int) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	ci := m.competitionIndex(r.CompetitionID)
+	if ci == -1 {
+		return nil
+	}
+	results := m.results(r.IsTeam)
+	for i := range results {
+		if results[i].CompetitionID == r.CompetitionID && results[i].ParticipantID == r.ParticipantID && results[i].Place != place {
+			if err := checkPlace(m.competitions[ci], place, false); err != nil {
+				return err
+			}
+			if err := m.checkStageResults(r.CompetitionID); err != nil {
+				return err
+			}
+			results[i].Place = place
+		}
+	}
+	return nil
+}
+
+// Как триггеры ensure_finished_*: в отменённое соревнование не
+// записывают, место ставится только завершённому и в нём обязательно,
+// 0 — без места. insert — запись участника, а не смена места
+func checkPlace(c memCompetition, place int, insert bool) error {
+	switch {
+	case insert && c.Status == StatusCancelled: return fmt.Errorf("Соревнование отменено — записывать участников нельзя")
+	case place > 0 && c.Status != StatusFinished: return fmt.Errorf("Соревнование не завершено — места ставятся только после завершения")
+	case place == 0 && c.Status == StatusFinished: return fmt.Errorf("Соревнование завершено — укажите место участника")
+	case place < 0: return errCheck("results")
+	}
+	return nil
+}
+
+func (m *memStore) deleteResult(ctx context.Context, r Result) error {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	isResult := func(res memResult) bool {
+		return res.CompetitionID == r.CompetitionID && res.ParticipantID == r.ParticipantID
+	}
+	if slices.ContainsFunc(m.results(r.IsTeam), isResult) {
+		if err := m.checkStageResults(r.CompetitionID); err != nil {
+			return err
+		}
+	}
+	if r.IsTeam {
+		m.teamResults = slices.DeleteFunc(m.teamResults, isResult)
+	} else {
+		m.athleteResults = slices.DeleteFunc(m.athleteResults, isResult)
+	}
+	return nil
+}
+
+// Как триггеры ensure_stage_results_*: по результатам этапа уже набран
+// следующий этап
+func (m *memStore) checkStageResults(competitionID int) error {
+	ci := m.competitionIndex(competitionID)
+	if ci != -1 && m.competitions[ci].NextStageID != 0 && m.hasResults(m.competitions[ci].NextStageID) {
+		return fmt.Errorf("На следующий этап уже записаны участники — результаты этапа менять нельзя")
+	}
+	return nil
+}
+
+// Медали дают только завершённые финалы
+func (m *memStore) isFinal(competitionID int) bool {
+	c := m.competitions[m.competitionIndex(competitionID)]
+	return c.Stage == StageFinal && c.Status == StatusFinished
+}
+
+// Как представление country_medals
+func (m *memStore) getCountryMedals(ctx context.Context, withoutMedals bool) ([]CountryMedals, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	byCountry := make(map[string]*CountryMedals)
+	for _, c := range m.countries {
+		byCountry[c.Code] = &CountryMedals{Country: c.Name}
+	}
+	count := func(countryCode string, place int) {
+		cm := byCountry[countryCode]
+		switch place {
+		case 1: cm.Gold++
+		case 2: cm.Silver++
+		case 3: cm.Bronze++
+		default: return
+		}
+		cm.Total++
+	}
+	for _, r := range m.athleteResults {
+		if m.isFinal(r.CompetitionID) {
+			count(m.athletes[m.athleteIndex(r.ParticipantID)].CountryCode, r.Place)
+		}
+	}
+	for _, r := range m.teamResults {
+		if m.isFinal(r.CompetitionID) {
+			count(m.teams[m.teamIndex(r.ParticipantID)].CountryCode, r.Place)
+		}
+	}
+
+	var medals []CountryMedals
+	for _, c := range m.countries {
+		if cm := byCountry[c.Code]; withoutMedals || cm.Total > 0 {
+			medals = append(medals, *cm)
+		}
+	}
+	slices.SortStableFunc(medals, func(a, b CountryMedals) int {
+		switch {
+		case a.Gold != b.Gold: return b.Gold - a.Gold
+		case a.Silver != b.Silver: return b.Silver - a.Silver
+		case a.Bronze != b.Bronze: return b.Bronze - a.Bronze
+		default: return compareStrings(a.Country, b.Country)
+		}
+	})
+	return medals, nil
+}
+
+// Как представление event_medals
+func (m *memStore) getEventMedals(ctx context.Context) ([]EventMedal, error) {
+	if err := m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	var medals []EventMedal
+	add := func(r memResult, name string, countryCode string) {
+		if r.Place == 0 || r.Place > 3 || !m.isFinal(r.CompetitionID) {
+			return
+		}
+		event := m.events[m.eventIndex(m.competitions[m.competitionIndex(r.CompetitionID)].EventID)]
+		medals = append(medals, EventMedal{
+			Event: event,
+			SportName: m.sports[m.sportIndex(event.SportCode)].Name,
+			Place: r.Place,
+			ParticipantName: name,
+			CountryName: m.countries[m.countryIndex(countryCode)].Name,
+		})
+	}
+	for _, r := range m.athleteResults {
+		a := m.athletes[m.athleteIndex(r.ParticipantID)]
+		add(r, a.Name, a.CountryCode)
+	}
+	for _, r := range m.teamResults {
+		t := m.teams[m.teamIndex(r.ParticipantID)]
+		add(r, t.Name, t.CountryCode)
+	}
+	slices.SortStableFunc(medals, func(a, b EventMedal) int {
+		switch {
+		case a.SportName != b.SportName: return compareStrings(a.SportName, b.SportName)
+		case a.Event.Name != b.Event.Name: return compareStrings(a.Event.Name, b.Event.Name)
+		case a.Place != b.Place: return a.Place - b.Place
+		default: return compareStrings(a.ParticipantName, b.ParticipantName)
+		}
+	})
+	return medals, nil
+}
+
+func compareStrings(a, b string) int {
+	switch {
+	case a < b: return -1
+	case a > b: return 1
+	default: return 0
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Миграции применяются по порядку номеров в имени файла (0001_init.sql,
+// 0002_...), номер последней применённой хранится в PRAGMA user_version
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name string
+	sql string
+}
+
+func loadMigrations() ([]migration, error) {
+	var migrations []migration
+
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		prefix, _, ok := strings.Cut(e.Name(), "_")
+		if !ok {
+			return nil, fmt.Errorf("bad migration file name %s", e.Name())
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration file name %s: %s", e.Name(), err)
+		}
+		if version != len(migrations)+1 {
+			return nil, fmt.Errorf("migration %s is out of order, expected version %d", e.Name(), len(migrations)+1)
+		}
+
+		data, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version, e.Name(), string(data)})
+	}
+
+	return migrations, nil
+}
+
+// Приводит схему базы к последней версии. Все недостающие миграции
+// выполняются в одной транзакции, так что база либо обновляется
+// целиком, либо остаётся как была. Перед миграцией существующей базы
+// делается её снимок, path нужен чтобы знать, куда его положить
+func dbMigrate(ctx context.Context, d *sql.DB, path string) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	// foreign_keys нельзя переключить внутри транзакции, а миграциям,
+	// которые пересоздают таблицы, нужно чтобы ключи не проверялись
+	// на ходу, поэтому держим одно соединение на всё время миграции
+	conn, err := d.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var version int
+	if err := conn.QueryRowContext(ctx, "PRAGMA user_version;").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, len(migrations))
+	}
+	if version == len(migrations) {
+		return nil
+	}
+	// Базе без версии тоже нужен снимок, если в ней уже есть таблицы:
+	// это база, созданная до появления миграций, и первые же миграции
+	// чистят в ней строки с битыми ссылками
+	var hasTables bool
+	err = conn.QueryRowContext(ctx,
+		"SELECT EXISTS ( SELECT 1 FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' );",
+	).Scan(&hasTables)
+	if err != nil {
+		return err
+	}
+	if hasTables {
+		if _, err := takeSnapshot(ctx, d, path, fmt.Sprintf("before-migration-%d", version+1)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF;"); err != nil {
+		return err
+	}
+	// Соединение вернётся в пул, так что ключи включаем и при отмене ctx
+	defer conn.ExecContext(context.WithoutCancel(ctx), "PRAGMA foreign_keys = ON;")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Триггеры журналов построены по старым столбцам и записали бы
+	// в журналы сами миграции
+	for _, prefix := range []string{"undo", "audit"} {
+		if err := dropTriggers(ctx, tx, prefix); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range migrations[version:] {
+		if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+			return fmt.Errorf("migration %s failed: %s", m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d;", m.version)); err != nil {
+			return err
+		}
+	}
+
+	// Журнал ссылается на строки по rowid, а пересоздание таблиц их
+	// меняет, так что старую историю отменить уже нельзя
+	if _, err := tx.ExecContext(ctx, "DELETE FROM undo_steps; DELETE FROM undo_log;"); err != nil {
+		return err
+	}
+
+	// Ключи во время миграции не проверялись, проверяем всё разом
+	var table, parent string
+	var rowid, fkid sql.NullInt64
+	err = tx.QueryRowContext(ctx, "PRAGMA foreign_key_check;").Scan(&table, &rowid, &parent, &fkid)
+	if err == nil {
+		return fmt.Errorf("foreign key check failed: row %d in %s references missing row in %s",
+			rowid.Int64, table, parent)
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -24,7 +24,9 @@ CREATE TABLE IF NOT EXISTS athletes (
 
     country_code TEXT NOT NULL,
 
+    -- Страну нельзя удалить пока в ней есть спортсмены
     FOREIGN KEY ( country_code ) REFERENCES countries ( code )
+        ON DELETE RESTRICT ON UPDATE CASCADE
 );
 
 CREATE INDEX IF NOT EXISTS idx_athletes_country_code ON athletes ( country_code );
@@ -39,8 +41,10 @@ CREATE TABLE IF NOT EXISTS teams (
     country_code TEXT NOT NULL,
     sport_code TEXT NOT NULL,
 
-    FOREIGN KEY ( country_code ) REFERENCES countries ( code ),
+    FOREIGN KEY ( country_code ) REFERENCES countries ( code )
+        ON DELETE RESTRICT ON UPDATE CASCADE,
     FOREIGN KEY ( sport_code ) REFERENCES sports ( code )
+        ON DELETE RESTRICT ON UPDATE CASCADE
 );
 
 CREATE INDEX IF NOT EXISTS idx_teams_country_code ON teams ( country_code );
@@ -52,8 +56,9 @@ CREATE TABLE IF NOT EXISTS team_members (
     athlete_id INTEGER,
 
     PRIMARY KEY ( team_id, athlete_id ),
-    FOREIGN KEY ( team_id ) REFERENCES teams ( id ),
-    FOREIGN KEY ( athlete_id ) REFERENCES athletes ( id )
+    -- Состав команды удаляется вместе с командой или спортсменом
+    FOREIGN KEY ( team_id ) REFERENCES teams ( id ) ON DELETE CASCADE,
+    FOREIGN KEY ( athlete_id ) REFERENCES athletes ( id ) ON DELETE CASCADE
 );
 
 -- Площадки
@@ -72,8 +77,9 @@ CREATE TABLE IF NOT EXISTS competitions (
     sport_code TEXT NOT NULL,
     site_id INTEGER NOT NULL,
 
-    FOREIGN KEY ( sport_code ) REFERENCES sports ( code ),
-    FOREIGN KEY ( site_id ) REFERENCES sites ( id )
+    FOREIGN KEY ( sport_code ) REFERENCES sports ( code )
+        ON DELETE RESTRICT ON UPDATE CASCADE,
+    FOREIGN KEY ( site_id ) REFERENCES sites ( id ) ON DELETE RESTRICT
 );
 
 CREATE INDEX IF NOT EXISTS idx_competitions_sport_code ON competitions ( sport_code );
@@ -91,8 +97,10 @@ CREATE TABLE IF NOT EXISTS competition_athletes (
     UNIQUE ( competition_id, place ),
 
     PRIMARY KEY ( competition_id, athlete_id ),
-    FOREIGN KEY ( competition_id ) REFERENCES competitions ( id ),
-    FOREIGN KEY ( athlete_id ) REFERENCES athletes ( id )
+    -- Результаты удаляются вместе с соревнованием, но спортсмена
+    -- с результатами удалить нельзя
+    FOREIGN KEY ( competition_id ) REFERENCES competitions ( id ) ON DELETE CASCADE,
+    FOREIGN KEY ( athlete_id ) REFERENCES athletes ( id ) ON DELETE RESTRICT
 );
 
 -- Таблица для связи команд и соревнований, в которых они участвовали
@@ -107,8 +115,8 @@ CREATE TABLE IF NOT EXISTS competition_teams (
     UNIQUE ( competition_id, place ),
 
     PRIMARY KEY ( competition_id, team_id ),
-    FOREIGN KEY ( competition_id ) REFERENCES competitions ( id ),
-    FOREIGN KEY ( team_id ) REFERENCES teams ( id )
+    FOREIGN KEY ( competition_id ) REFERENCES competitions ( id ) ON DELETE CASCADE,
+    FOREIGN KEY ( team_id ) REFERENCES teams ( id ) ON DELETE RESTRICT
 );
 
 -- вьюха которая объединяет индивидуальные и коммандные результаты
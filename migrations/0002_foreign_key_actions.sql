@@ -0,0 +1,203 @@
+-- Базы, созданные до появления ON DELETE / ON UPDATE в схеме, хранят
+-- внешние ключи без действий, а CREATE TABLE IF NOT EXISTS их не меняет.
+-- Пересоздаём таблицы со ссылками по стандартной схеме sqlite:
+-- создать новую, скопировать данные, удалить старую, переименовать.
+-- Вьюхи и триггеры ссылаются на эти таблицы и помешают переименованию,
+-- поэтому удаляем их и создаём заново в конце.
+
+DROP VIEW IF EXISTS country_medals;
+DROP VIEW IF EXISTS competition_results;
+DROP TRIGGER IF EXISTS ensure_individual_sport;
+DROP TRIGGER IF EXISTS ensure_team_sport;
+DROP TRIGGER IF EXISTS ensure_team_members_country;
+
+-- Спортсмены
+CREATE TABLE new_athletes (
+    id INTEGER PRIMARY KEY,
+
+    name TEXT NOT NULL CHECK ( length(name) > 0 ),
+    gender CHAR(1) NOT NULL CHECK ( gender IN ( 'F', 'M' ) ),
+    birthday TIMESTAMP NOT NULL CHECK ( birthday < CURRENT_TIMESTAMP ),
+
+    country_code TEXT NOT NULL,
+
+    FOREIGN KEY ( country_code ) REFERENCES countries ( code )
+        ON DELETE RESTRICT ON UPDATE CASCADE
+);
+INSERT INTO new_athletes SELECT id, name, gender, birthday, country_code FROM athletes;
+DROP TABLE athletes;
+ALTER TABLE new_athletes RENAME TO athletes;
+
+CREATE INDEX idx_athletes_country_code ON athletes ( country_code );
+CREATE INDEX idx_athletes_gender ON athletes ( gender );
+
+-- Команды
+CREATE TABLE new_teams (
+    id INTEGER PRIMARY KEY,
+
+    name TEXT NOT NULL CHECK ( length(name) > 0 ),
+
+    country_code TEXT NOT NULL,
+    sport_code TEXT NOT NULL,
+
+    FOREIGN KEY ( country_code ) REFERENCES countries ( code )
+        ON DELETE RESTRICT ON UPDATE CASCADE,
+    FOREIGN KEY ( sport_code ) REFERENCES sports ( code )
+        ON DELETE RESTRICT ON UPDATE CASCADE
+);
+INSERT INTO new_teams SELECT id, name, country_code, sport_code FROM teams;
+DROP TABLE teams;
+ALTER TABLE new_teams RENAME TO teams;
+
+CREATE INDEX idx_teams_country_code ON teams ( country_code );
+CREATE INDEX idx_teams_sport_code ON teams ( sport_code );
+
+-- Участники команд
+CREATE TABLE new_team_members (
+    team_id INTEGER,
+    athlete_id INTEGER,
+
+    PRIMARY KEY ( team_id, athlete_id ),
+    FOREIGN KEY ( team_id ) REFERENCES teams ( id ) ON DELETE CASCADE,
+    FOREIGN KEY ( athlete_id ) REFERENCES athletes ( id ) ON DELETE CASCADE
+);
+-- Раньше ключи не проверялись, так что тут могут быть ссылки в никуда
+INSERT INTO new_team_members
+    SELECT team_id, athlete_id FROM team_members
+    WHERE team_id IN ( SELECT id FROM teams )
+      AND athlete_id IN ( SELECT id FROM athletes );
+DROP TABLE team_members;
+ALTER TABLE new_team_members RENAME TO team_members;
+
+-- Соревнования
+CREATE TABLE new_competitions (
+    id INTEGER PRIMARY KEY,
+
+    time TIMESTAMP NOT NULL CHECK ( time < CURRENT_TIMESTAMP ),
+
+    sport_code TEXT NOT NULL,
+    site_id INTEGER NOT NULL,
+
+    FOREIGN KEY ( sport_code ) REFERENCES sports ( code )
+        ON DELETE RESTRICT ON UPDATE CASCADE,
+    FOREIGN KEY ( site_id ) REFERENCES sites ( id ) ON DELETE RESTRICT
+);
+INSERT INTO new_competitions SELECT id, time, sport_code, site_id FROM competitions;
+DROP TABLE competitions;
+ALTER TABLE new_competitions RENAME TO competitions;
+
+CREATE INDEX idx_competitions_sport_code ON competitions ( sport_code );
+CREATE INDEX idx_competitions_site_id ON competitions ( site_id );
+
+-- Результаты спортсменов
+CREATE TABLE new_competition_athletes (
+    competition_id INTEGER,
+    athlete_id INTEGER,
+
+    place INTEGER NOT NULL CHECK ( place > 0 ),
+
+    UNIQUE ( competition_id, place ),
+
+    PRIMARY KEY ( competition_id, athlete_id ),
+    FOREIGN KEY ( competition_id ) REFERENCES competitions ( id ) ON DELETE CASCADE,
+    FOREIGN KEY ( athlete_id ) REFERENCES athletes ( id ) ON DELETE RESTRICT
+);
+INSERT INTO new_competition_athletes
+    SELECT competition_id, athlete_id, place FROM competition_athletes
+    WHERE competition_id IN ( SELECT id FROM competitions )
+      AND athlete_id IN ( SELECT id FROM athletes );
+DROP TABLE competition_athletes;
+ALTER TABLE new_competition_athletes RENAME TO competition_athletes;
+
+-- Результаты команд
+CREATE TABLE new_competition_teams (
+    competition_id INTEGER,
+    team_id INTEGER,
+
+    place INTEGER NOT NULL CHECK ( place > 0 ),
+
+    UNIQUE ( competition_id, place ),
+
+    PRIMARY KEY ( competition_id, team_id ),
+    FOREIGN KEY ( competition_id ) REFERENCES competitions ( id ) ON DELETE CASCADE,
+    FOREIGN KEY ( team_id ) REFERENCES teams ( id ) ON DELETE RESTRICT
+);
+INSERT INTO new_competition_teams
+    SELECT competition_id, team_id, place FROM competition_teams
+    WHERE competition_id IN ( SELECT id FROM competitions )
+      AND team_id IN ( SELECT id FROM teams );
+DROP TABLE competition_teams;
+ALTER TABLE new_competition_teams RENAME TO competition_teams;
+
+-- Вьюхи и триггеры без изменений
+CREATE VIEW competition_results AS
+    SELECT
+        competition_id,
+        'athlete' AS participant_type,
+        athlete_id AS participant_id,
+        place
+    FROM competition_athletes
+    UNION ALL
+    SELECT
+        competition_id,
+        'team' AS participant_type,
+        team_id AS participant_id,
+        place
+    FROM competition_teams
+;
+
+CREATE VIEW country_medals AS
+    SELECT
+        c.name AS country,
+        COUNT(CASE WHEN cr.place = 1 THEN 1 END) AS gold,
+        COUNT(CASE WHEN cr.place = 2 THEN 1 END) AS silver,
+        COUNT(CASE WHEN cr.place = 3 THEN 1 END) AS bronze,
+        COUNT(*) AS total
+    FROM competition_results cr
+    JOIN competitions comp ON cr.competition_id = comp.id
+    LEFT JOIN athletes a ON cr.participant_type = 'athlete' AND cr.participant_id = a.id
+    LEFT JOIN teams t ON cr.participant_type = 'team' AND cr.participant_id = t.id
+    JOIN countries c ON a.country_code = c.code OR t.country_code = c.code
+    GROUP BY c.code, c.name
+    HAVING cr.place IN (1, 2, 3)
+    ORDER BY gold DESC, silver DESC, bronze DESC
+;
+
+CREATE TRIGGER ensure_individual_sport BEFORE INSERT ON competition_athletes FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT s.is_team
+            FROM competitions c
+            JOIN sports s ON c.sport_code = s.code
+            WHERE c.id = NEW.competition_id
+        ) = TRUE
+        THEN RAISE(ABORT, 'Командный спорт — нельзя добавлять одиночного атлета')
+    END;
+END;
+
+CREATE TRIGGER ensure_team_sport BEFORE INSERT ON competition_teams FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT s.is_team
+            FROM competitions c
+            JOIN sports s ON c.sport_code = s.code
+            WHERE c.id = NEW.competition_id
+        ) = FALSE
+        THEN RAISE(ABORT, 'Индивидуальный спорт — нельзя добавлять команду')
+    END;
+END;
+
+CREATE TRIGGER ensure_team_members_country BEFORE INSERT ON team_members FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT a.country_code != t.country_code
+            FROM athletes a
+            JOIN teams t ON t.id = NEW.team_id
+            WHERE a.id = NEW.athlete_id
+        ) = TRUE
+        THEN RAISE(ABORT, 'Спортсмен не соответствует команде по стране')
+    END;
+END;
@@ -0,0 +1,22 @@
+-- HAVING применялся уже после группировки, так что total считал все
+-- занятые места, а попадание страны в таблицу зависело от того, какую
+-- строку sqlite выберет для cr.place. Фильтруем призовые места до
+-- группировки.
+DROP VIEW IF EXISTS country_medals;
+
+CREATE VIEW country_medals AS
+    SELECT
+        c.name AS country,
+        COUNT(CASE WHEN cr.place = 1 THEN 1 END) AS gold,
+        COUNT(CASE WHEN cr.place = 2 THEN 1 END) AS silver,
+        COUNT(CASE WHEN cr.place = 3 THEN 1 END) AS bronze,
+        COUNT(*) AS total
+    FROM competition_results cr
+    JOIN competitions comp ON cr.competition_id = comp.id
+    LEFT JOIN athletes a ON cr.participant_type = 'athlete' AND cr.participant_id = a.id
+    LEFT JOIN teams t ON cr.participant_type = 'team' AND cr.participant_id = t.id
+    JOIN countries c ON c.code = COALESCE(a.country_code, t.country_code)
+    WHERE cr.place IN (1, 2, 3)
+    GROUP BY c.code, c.name
+    ORDER BY gold DESC, silver DESC, bronze DESC
+;
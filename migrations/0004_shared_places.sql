@@ -0,0 +1,113 @@
+-- В боксе, дзюдо и т.п. бронзу получают двое, поэтому убираем
+-- UNIQUE ( competition_id, place ) из таблиц результатов.
+-- Как и в 0002, вьюхи мешают переименованию таблиц, а триггеры
+-- удаляются вместе с таблицами, так что создаём их заново.
+
+DROP VIEW IF EXISTS country_medals;
+DROP VIEW IF EXISTS competition_results;
+
+CREATE TABLE new_competition_athletes (
+    competition_id INTEGER,
+    athlete_id INTEGER,
+
+    -- Какое место атлет занял в этом соревновании (может быть общим)
+    place INTEGER NOT NULL CHECK ( place > 0 ),
+
+    PRIMARY KEY ( competition_id, athlete_id ),
+    FOREIGN KEY ( competition_id ) REFERENCES competitions ( id ) ON DELETE CASCADE,
+    FOREIGN KEY ( athlete_id ) REFERENCES athletes ( id ) ON DELETE RESTRICT
+);
+INSERT INTO new_competition_athletes
+    SELECT competition_id, athlete_id, place FROM competition_athletes;
+DROP TABLE competition_athletes;
+ALTER TABLE new_competition_athletes RENAME TO competition_athletes;
+
+CREATE INDEX idx_competition_athletes_place ON competition_athletes ( competition_id, place );
+
+CREATE TABLE new_competition_teams (
+    competition_id INTEGER,
+    team_id INTEGER,
+
+    -- Какое место команда заняла в этом соревновании (может быть общим)
+    place INTEGER NOT NULL CHECK ( place > 0 ),
+
+    PRIMARY KEY ( competition_id, team_id ),
+    FOREIGN KEY ( competition_id ) REFERENCES competitions ( id ) ON DELETE CASCADE,
+    FOREIGN KEY ( team_id ) REFERENCES teams ( id ) ON DELETE RESTRICT
+);
+INSERT INTO new_competition_teams
+    SELECT competition_id, team_id, place FROM competition_teams;
+DROP TABLE competition_teams;
+ALTER TABLE new_competition_teams RENAME TO competition_teams;
+
+CREATE INDEX idx_competition_teams_place ON competition_teams ( competition_id, place );
+
+CREATE VIEW competition_results AS
+    SELECT
+        competition_id,
+        'athlete' AS participant_type,
+        athlete_id AS participant_id,
+        place
+    FROM competition_athletes
+    UNION ALL
+    SELECT
+        competition_id,
+        'team' AS participant_type,
+        team_id AS participant_id,
+        place
+    FROM competition_teams
+;
+
+-- Призовые места со страной участника. Командный результат хранится
+-- одной строкой в competition_teams, поэтому медаль команды
+-- считается один раз, а не по числу её участников
+CREATE VIEW podium_results AS
+    SELECT
+        cr.competition_id,
+        cr.place,
+        COALESCE(a.country_code, t.country_code) AS country_code
+    FROM competition_results cr
+    LEFT JOIN athletes a ON cr.participant_type = 'athlete' AND cr.participant_id = a.id
+    LEFT JOIN teams t ON cr.participant_type = 'team' AND cr.participant_id = t.id
+    WHERE cr.place IN (1, 2, 3)
+;
+
+-- Медальный зачёт по всем странам, включая страны без медалей
+CREATE VIEW country_medals AS
+    SELECT
+        c.name AS country,
+        COUNT(CASE WHEN pr.place = 1 THEN 1 END) AS gold,
+        COUNT(CASE WHEN pr.place = 2 THEN 1 END) AS silver,
+        COUNT(CASE WHEN pr.place = 3 THEN 1 END) AS bronze,
+        COUNT(pr.place) AS total
+    FROM countries c
+    LEFT JOIN podium_results pr ON pr.country_code = c.code
+    GROUP BY c.code, c.name
+    ORDER BY gold DESC, silver DESC, bronze DESC, country
+;
+
+CREATE TRIGGER ensure_individual_sport BEFORE INSERT ON competition_athletes FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT s.is_team
+            FROM competitions c
+            JOIN sports s ON c.sport_code = s.code
+            WHERE c.id = NEW.competition_id
+        ) = TRUE
+        THEN RAISE(ABORT, 'Командный спорт — нельзя добавлять одиночного атлета')
+    END;
+END;
+
+CREATE TRIGGER ensure_team_sport BEFORE INSERT ON competition_teams FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT s.is_team
+            FROM competitions c
+            JOIN sports s ON c.sport_code = s.code
+            WHERE c.id = NEW.competition_id
+        ) = FALSE
+        THEN RAISE(ABORT, 'Индивидуальный спорт — нельзя добавлять команду')
+    END;
+END;
@@ -0,0 +1,113 @@
+-- Статус соревнования. Запланированные и отменённые соревнования могут
+-- быть в будущем, а результаты принимаются только у завершённых.
+-- Старые записи — прошедшие соревнования, поэтому 'finished', но
+-- раньше проверка времени не работала для записей из приложения, и
+-- будущие соревнования среди них считаем запланированными.
+-- Триггеры на результаты ссылаются на competitions и помешают
+-- переименованию таблицы, поэтому пересоздаём и их.
+
+DROP TRIGGER IF EXISTS ensure_individual_sport;
+DROP TRIGGER IF EXISTS ensure_team_sport;
+
+CREATE TABLE new_competitions (
+    id INTEGER PRIMARY KEY,
+
+    time TIMESTAMP NOT NULL,
+
+    -- scheduled — запланировано, in_progress — идёт,
+    -- finished — завершено, cancelled — отменено
+    status TEXT NOT NULL DEFAULT 'finished'
+        CHECK ( status IN ( 'scheduled', 'in_progress', 'finished', 'cancelled' ) ),
+
+    sport_code TEXT NOT NULL,
+    site_id INTEGER NOT NULL,
+
+    -- Начавшиеся и завершённые соревнования не могут быть в будущем.
+    -- Приложение пишет время как unix timestamp, а populate.sql строкой,
+    -- и число всегда меньше строки, так что приводим к одному виду
+    CHECK ( status IN ( 'scheduled', 'cancelled' ) OR
+        CASE typeof(time) WHEN 'integer' THEN datetime(time, 'unixepoch') ELSE time END < CURRENT_TIMESTAMP ),
+
+    FOREIGN KEY ( sport_code ) REFERENCES sports ( code )
+        ON DELETE RESTRICT ON UPDATE CASCADE,
+    FOREIGN KEY ( site_id ) REFERENCES sites ( id ) ON DELETE RESTRICT
+);
+INSERT INTO new_competitions ( id, time, status, sport_code, site_id )
+    SELECT
+        id,
+        time,
+        CASE
+            WHEN CASE typeof(time) WHEN 'integer' THEN datetime(time, 'unixepoch') ELSE time END < CURRENT_TIMESTAMP
+            THEN 'finished'
+            ELSE 'scheduled'
+        END,
+        sport_code,
+        site_id
+    FROM competitions;
+DROP TABLE competitions;
+ALTER TABLE new_competitions RENAME TO competitions;
+
+CREATE INDEX idx_competitions_sport_code ON competitions ( sport_code );
+CREATE INDEX idx_competitions_site_id ON competitions ( site_id );
+CREATE INDEX idx_competitions_status ON competitions ( status );
+
+CREATE TRIGGER ensure_individual_sport BEFORE INSERT ON competition_athletes FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT s.is_team
+            FROM competitions c
+            JOIN sports s ON c.sport_code = s.code
+            WHERE c.id = NEW.competition_id
+        ) = TRUE
+        THEN RAISE(ABORT, 'Командный спорт — нельзя добавлять одиночного атлета')
+    END;
+END;
+
+CREATE TRIGGER ensure_team_sport BEFORE INSERT ON competition_teams FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT s.is_team
+            FROM competitions c
+            JOIN sports s ON c.sport_code = s.code
+            WHERE c.id = NEW.competition_id
+        ) = FALSE
+        THEN RAISE(ABORT, 'Индивидуальный спорт — нельзя добавлять команду')
+    END;
+END;
+
+-- триггеры которые не дают вносить результаты незавершённых соревнований
+CREATE TRIGGER ensure_finished_individual BEFORE INSERT ON competition_athletes FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT status FROM competitions WHERE id = NEW.competition_id
+        ) != 'finished'
+        THEN RAISE(ABORT, 'Соревнование ещё не завершено — результаты вносить нельзя')
+    END;
+END;
+
+CREATE TRIGGER ensure_finished_team BEFORE INSERT ON competition_teams FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT status FROM competitions WHERE id = NEW.competition_id
+        ) != 'finished'
+        THEN RAISE(ABORT, 'Соревнование ещё не завершено — результаты вносить нельзя')
+    END;
+END;
+
+-- и не дают вернуть соревнование с результатами в незавершённое
+CREATE TRIGGER ensure_finished_with_results BEFORE UPDATE OF status ON competitions FOR EACH ROW
+WHEN NEW.status != 'finished' BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1 FROM competition_athletes WHERE competition_id = NEW.id
+            UNION ALL
+            SELECT 1 FROM competition_teams WHERE competition_id = NEW.id
+        )
+        THEN RAISE(ABORT, 'У соревнования есть результаты — сначала удалите их')
+    END;
+END;
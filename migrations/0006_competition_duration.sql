@@ -0,0 +1,59 @@
+-- Продолжительность соревнования в минутах и запрет на пересечение
+-- соревнований на одном месте. Отменённые соревнования место не занимают.
+-- Старые записи получают продолжительность по умолчанию и не
+-- проверяются, пересечения среди них приложение только подсвечивает.
+
+ALTER TABLE competitions ADD COLUMN duration INTEGER NOT NULL DEFAULT 120
+    CHECK ( duration > 0 );
+
+-- Время начала и конца соревнования в unix timestamp, время в таблице
+-- бывает и числом, и строкой
+CREATE VIEW competition_intervals AS
+SELECT
+    id,
+    site_id,
+    status,
+    start,
+    start + duration * 60 AS finish
+FROM (
+    SELECT
+        id,
+        site_id,
+        status,
+        duration,
+        CASE typeof(time) WHEN 'integer' THEN time ELSE CAST(strftime('%s', time) AS INTEGER) END AS start
+    FROM competitions
+);
+
+CREATE TRIGGER ensure_site_free BEFORE INSERT ON competitions FOR EACH ROW
+WHEN NEW.status != 'cancelled' BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1
+            FROM competition_intervals ci
+            WHERE ci.site_id = NEW.site_id
+              AND ci.status != 'cancelled'
+              AND ci.start < CASE typeof(NEW.time) WHEN 'integer' THEN NEW.time ELSE CAST(strftime('%s', NEW.time) AS INTEGER) END + NEW.duration * 60
+              AND CASE typeof(NEW.time) WHEN 'integer' THEN NEW.time ELSE CAST(strftime('%s', NEW.time) AS INTEGER) END < ci.finish
+        )
+        THEN RAISE(ABORT, 'Место уже занято другим соревнованием в это время')
+    END;
+END;
+
+CREATE TRIGGER ensure_site_free_update BEFORE UPDATE OF time, duration, site_id, status ON competitions FOR EACH ROW
+WHEN NEW.status != 'cancelled' BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1
+            FROM competition_intervals ci
+            WHERE ci.site_id = NEW.site_id
+              AND ci.id != NEW.id
+              AND ci.status != 'cancelled'
+              AND ci.start < CASE typeof(NEW.time) WHEN 'integer' THEN NEW.time ELSE CAST(strftime('%s', NEW.time) AS INTEGER) END + NEW.duration * 60
+              AND CASE typeof(NEW.time) WHEN 'integer' THEN NEW.time ELSE CAST(strftime('%s', NEW.time) AS INTEGER) END < ci.finish
+        )
+        THEN RAISE(ABORT, 'Место уже занято другим соревнованием в это время')
+    END;
+END;
@@ -0,0 +1,25 @@
+-- Спортсмен участвует в соревновании либо сам, либо в составе команды.
+-- Пересечения по времени проверяет приложение при добавлении участника,
+-- здесь только представления для этого и для отчёта о конфликтах.
+
+CREATE VIEW athlete_entries AS
+SELECT athlete_id, competition_id, NULL AS team_id
+FROM competition_athletes
+UNION
+SELECT tm.athlete_id, ct.competition_id, ct.team_id
+FROM competition_teams ct
+JOIN team_members tm ON tm.team_id = ct.team_id;
+
+-- Каждая пара пересекающихся соревнований одного спортсмена один раз
+CREATE VIEW athlete_conflicts AS
+SELECT
+    e1.athlete_id,
+    e1.competition_id AS first_id,
+    e2.competition_id AS second_id
+FROM athlete_entries e1
+JOIN athlete_entries e2
+    ON e2.athlete_id = e1.athlete_id AND e2.competition_id > e1.competition_id
+JOIN competition_intervals c1 ON c1.id = e1.competition_id
+JOIN competition_intervals c2 ON c2.id = e2.competition_id
+WHERE c1.status != 'cancelled' AND c2.status != 'cancelled'
+  AND c1.start < c2.finish AND c2.start < c1.finish;
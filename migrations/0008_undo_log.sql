@@ -0,0 +1,24 @@
+-- Журнал для отмены и повтора изменений. Триггеры, которые его
+-- заполняют, создаёт приложение при открытии базы (см. undo.go): они
+-- строятся по списку столбцов таблиц и пересоздаются после миграций.
+-- Каждое изменение записывается запросом, который его отменяет.
+
+-- Шаг — одна операция пользователя (добавление, удаление вместе со
+-- всеми каскадно удалёнными записями и т.п.). Отменённые шаги остаются
+-- в журнале, пока их можно повторить
+CREATE TABLE undo_steps (
+    id INTEGER PRIMARY KEY,
+    description TEXT NOT NULL,
+    time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    undone BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- Запросы без шага — изменения, которые ещё не оформлены в шаг
+CREATE TABLE undo_log (
+    seq INTEGER PRIMARY KEY,
+    step_id INTEGER,
+    sql TEXT NOT NULL,
+
+    FOREIGN KEY ( step_id ) REFERENCES undo_steps ( id ) ON DELETE CASCADE
+);
+CREATE INDEX idx_undo_log_step_id ON undo_log ( step_id );
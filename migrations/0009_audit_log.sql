@@ -0,0 +1,18 @@
+-- Журнал аудита: кто, когда и что поменял в спортсменах, командах,
+-- соревнованиях и результатах. Как и журнал отмены, заполняется
+-- триггерами, которые приложение создаёт при открытии базы (см. audit.go).
+-- Значения строки до и после изменения хранятся объектами JSON.
+
+-- Триггер не знает, кто работает с базой, поэтому operator заполняет
+-- приложение, когда операция завершена
+CREATE TABLE audit_log (
+    id INTEGER PRIMARY KEY,
+    time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    operator TEXT,
+    table_name TEXT NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('insert', 'update', 'delete')),
+    old_values TEXT,
+    new_values TEXT
+);
+CREATE INDEX idx_audit_log_time ON audit_log ( time );
+CREATE INDEX idx_audit_log_operator ON audit_log ( operator );
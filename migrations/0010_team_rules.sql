@@ -0,0 +1,121 @@
+-- Правила составов команд. У вида спорта появляются ограничения на
+-- число игроков и пол участников (NULL — без ограничения, для пола это
+-- смешанные команды). Команды можно создавать только в командных видах.
+-- Нехватку игроков база не проверяет: состав набирается постепенно, и
+-- неполная команда только помечается в интерфейсе. Составы, собранные
+-- до появления правил, не трогаем, а триггеры на изменения срабатывают
+-- только если поменялось проверяемое значение, так что такие команды
+-- можно переименовать, не исправляя их состав.
+
+ALTER TABLE sports ADD COLUMN min_players INTEGER CHECK ( min_players > 0 );
+ALTER TABLE sports ADD COLUMN max_players INTEGER CHECK ( max_players >= coalesce(min_players, 1) );
+ALTER TABLE sports ADD COLUMN team_gender CHAR(1) CHECK ( team_gender IN ( 'F', 'M' ) );
+
+CREATE TRIGGER ensure_team_of_team_sport BEFORE INSERT ON teams FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT is_team FROM sports WHERE code = NEW.sport_code
+        ) = FALSE
+        THEN RAISE(ABORT, 'Индивидуальный вид спорта — команду создать нельзя')
+    END;
+END;
+
+-- Срабатывает и при смене кода вида спорта (ON UPDATE CASCADE), тогда
+-- правила проверяются уже по изменённой записи
+CREATE TRIGGER ensure_team_rules_sport_change BEFORE UPDATE OF sport_code ON teams FOR EACH ROW
+WHEN NEW.sport_code != OLD.sport_code BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT is_team FROM sports WHERE code = NEW.sport_code
+        ) = FALSE
+        THEN RAISE(ABORT, 'Индивидуальный вид спорта — команду создать нельзя')
+        WHEN EXISTS (
+            SELECT 1
+            FROM team_members tm
+            JOIN athletes a ON a.id = tm.athlete_id
+            JOIN sports s ON s.code = NEW.sport_code
+            WHERE tm.team_id = NEW.id AND a.gender != s.team_gender
+        )
+        THEN RAISE(ABORT, 'Спортсмен не подходит команде по полу')
+        WHEN (
+            SELECT ( SELECT COUNT(*) FROM team_members WHERE team_id = NEW.id ) > max_players
+            FROM sports
+            WHERE code = NEW.sport_code
+        ) = TRUE
+        THEN RAISE(ABORT, 'В команде больше игроков, чем разрешено')
+    END;
+END;
+
+CREATE TRIGGER ensure_sport_with_teams BEFORE UPDATE OF is_team ON sports FOR EACH ROW
+WHEN NOT NEW.is_team BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS ( SELECT 1 FROM teams WHERE sport_code = OLD.code )
+        THEN RAISE(ABORT, 'У вида спорта есть команды — сначала удалите их')
+    END;
+END;
+
+CREATE TRIGGER ensure_team_rules_change BEFORE UPDATE OF max_players, team_gender ON sports FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN NEW.team_gender IS NOT OLD.team_gender AND EXISTS (
+            SELECT 1
+            FROM teams t
+            JOIN team_members tm ON tm.team_id = t.id
+            JOIN athletes a ON a.id = tm.athlete_id
+            WHERE t.sport_code = OLD.code AND a.gender != NEW.team_gender
+        )
+        THEN RAISE(ABORT, 'В командах этого вида спорта есть спортсмены другого пола')
+        WHEN NEW.max_players IS NOT OLD.max_players AND EXISTS (
+            SELECT 1
+            FROM teams t
+            WHERE t.sport_code = OLD.code
+                AND ( SELECT COUNT(*) FROM team_members WHERE team_id = t.id ) > NEW.max_players
+        )
+        THEN RAISE(ABORT, 'В командах этого вида спорта больше игроков, чем новый максимум')
+    END;
+END;
+
+CREATE TRIGGER ensure_team_members_gender BEFORE INSERT ON team_members FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT a.gender != s.team_gender
+            FROM athletes a
+            JOIN teams t ON t.id = NEW.team_id
+            JOIN sports s ON s.code = t.sport_code
+            WHERE a.id = NEW.athlete_id
+        ) = TRUE
+        THEN RAISE(ABORT, 'Спортсмен не подходит команде по полу')
+    END;
+END;
+
+CREATE TRIGGER ensure_team_members_gender_update BEFORE UPDATE OF gender ON athletes FOR EACH ROW
+WHEN NEW.gender != OLD.gender BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1
+            FROM team_members tm
+            JOIN teams t ON t.id = tm.team_id
+            JOIN sports s ON s.code = t.sport_code
+            WHERE tm.athlete_id = NEW.id AND s.team_gender != NEW.gender
+        )
+        THEN RAISE(ABORT, 'Спортсмен не подходит команде по полу')
+    END;
+END;
+
+CREATE TRIGGER ensure_team_members_limit BEFORE INSERT ON team_members FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT ( SELECT COUNT(*) FROM team_members WHERE team_id = t.id ) >= s.max_players
+            FROM teams t
+            JOIN sports s ON s.code = t.sport_code
+            WHERE t.id = NEW.team_id
+        ) = TRUE
+        THEN RAISE(ABORT, 'Состав команды уже полный')
+    END;
+END;
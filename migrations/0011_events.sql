@@ -0,0 +1,161 @@
+-- Дисциплины внутри вида спорта: в плавании это 100 м вольным стилем
+-- у женщин и 200 м баттерфляем у мужчин, в боксе — весовые категории.
+-- Соревнование проводится по одной дисциплине. Код вида спорта в
+-- соревновании остаётся, на нём держатся остальные триггеры, а
+-- триггеры ниже следят, чтобы он совпадал с видом спорта дисциплины.
+-- Для каждого вида спорта создаётся дисциплина «Общий зачёт» без
+-- ограничений, к ней относятся все существующие соревнования.
+
+CREATE TABLE events (
+    id INTEGER PRIMARY KEY,
+
+    sport_code TEXT NOT NULL,
+    name TEXT NOT NULL CHECK ( length(name) > 0 ),
+
+    -- Пол участников, NULL — смешанная дисциплина
+    gender CHAR(1) CHECK ( gender IN ( 'F', 'M' ) ),
+    -- Возраст на день соревнования, NULL — без ограничения
+    min_age INTEGER CHECK ( min_age > 0 ),
+    max_age INTEGER CHECK ( max_age >= coalesce(min_age, 1) ),
+    -- Весовая категория. Веса спортсменов в базе нет, так что она
+    -- только описывает дисциплину и не проверяется
+    weight_class TEXT CHECK ( length(weight_class) > 0 ),
+
+    UNIQUE ( sport_code, name ),
+    -- Дисциплины удаляются вместе с видом спорта, но вид спорта с
+    -- соревнованиями удалить нельзя
+    FOREIGN KEY ( sport_code ) REFERENCES sports ( code )
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+INSERT INTO events ( sport_code, name ) SELECT code, 'Общий зачёт' FROM sports;
+
+ALTER TABLE competitions ADD COLUMN event_id INTEGER REFERENCES events ( id ) ON DELETE RESTRICT;
+UPDATE competitions SET event_id = ( SELECT id FROM events WHERE sport_code = competitions.sport_code );
+
+CREATE INDEX idx_competitions_event_id ON competitions ( event_id );
+
+CREATE TRIGGER ensure_competition_event BEFORE INSERT ON competitions FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN NEW.event_id IS NULL
+        THEN RAISE(ABORT, 'Не указана дисциплина соревнования')
+        WHEN ( SELECT sport_code FROM events WHERE id = NEW.event_id ) != NEW.sport_code
+        THEN RAISE(ABORT, 'Дисциплина относится к другому виду спорта')
+    END;
+END;
+
+-- Смена кода вида спорта доходит и до дисциплин, и до соревнований,
+-- поэтому проверяется только смена дисциплины
+CREATE TRIGGER ensure_competition_event_update BEFORE UPDATE OF event_id ON competitions FOR EACH ROW
+WHEN NEW.event_id IS NOT OLD.event_id BEGIN
+    SELECT
+    CASE
+        WHEN NEW.event_id IS NULL
+        THEN RAISE(ABORT, 'Не указана дисциплина соревнования')
+        WHEN ( SELECT sport_code FROM events WHERE id = NEW.event_id ) != NEW.sport_code
+        THEN RAISE(ABORT, 'Дисциплина относится к другому виду спорта')
+        WHEN EXISTS (
+            SELECT 1 FROM competition_athletes WHERE competition_id = NEW.id
+            UNION ALL
+            SELECT 1 FROM competition_teams WHERE competition_id = NEW.id
+        )
+        THEN RAISE(ABORT, 'У соревнования есть результаты — сначала удалите их')
+    END;
+END;
+
+-- Условия участия, по которым уже выступали, не меняются
+CREATE TRIGGER ensure_event_rules_change BEFORE UPDATE OF gender, min_age, max_age ON events FOR EACH ROW
+WHEN NEW.gender IS NOT OLD.gender OR NEW.min_age IS NOT OLD.min_age OR NEW.max_age IS NOT OLD.max_age BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1
+            FROM competitions c
+            JOIN competition_results cr ON cr.competition_id = c.id
+            WHERE c.event_id = NEW.id
+        )
+        THEN RAISE(ABORT, 'По дисциплине уже есть результаты — условия участия менять нельзя')
+    END;
+END;
+
+-- Полных лет спортсмену в день соревнования. Время и дата рождения
+-- бывают и числом, и строкой. Приложение пишет дату рождения как
+-- местную полночь, поэтому числа переводятся в местное время
+CREATE VIEW athlete_ages AS
+SELECT
+    athlete_id,
+    competition_id,
+    CAST(strftime('%Y', day) AS INTEGER) - CAST(strftime('%Y', birthday) AS INTEGER)
+        - ( strftime('%m-%d', day) < strftime('%m-%d', birthday) ) AS age
+FROM (
+    SELECT
+        a.id AS athlete_id,
+        c.id AS competition_id,
+        CASE typeof(c.time) WHEN 'integer' THEN datetime(c.time, 'unixepoch', 'localtime') ELSE c.time END AS day,
+        CASE typeof(a.birthday) WHEN 'integer' THEN datetime(a.birthday, 'unixepoch', 'localtime') ELSE a.birthday END AS birthday
+    FROM athletes a, competitions c
+);
+
+CREATE TRIGGER ensure_event_athlete BEFORE INSERT ON competition_athletes FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT a.gender != ev.gender
+            FROM athletes a
+            JOIN competitions c ON c.id = NEW.competition_id
+            JOIN events ev ON ev.id = c.event_id
+            WHERE a.id = NEW.athlete_id
+        ) = TRUE
+        THEN RAISE(ABORT, 'Спортсмен не подходит дисциплине по полу')
+        WHEN (
+            SELECT aa.age < ev.min_age OR aa.age > ev.max_age
+            FROM athlete_ages aa
+            JOIN competitions c ON c.id = aa.competition_id
+            JOIN events ev ON ev.id = c.event_id
+            WHERE aa.athlete_id = NEW.athlete_id AND aa.competition_id = NEW.competition_id
+        ) = TRUE
+        THEN RAISE(ABORT, 'Спортсмен не подходит дисциплине по возрасту')
+    END;
+END;
+
+CREATE TRIGGER ensure_event_team BEFORE INSERT ON competition_teams FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1
+            FROM team_members tm
+            JOIN athletes a ON a.id = tm.athlete_id
+            JOIN competitions c ON c.id = NEW.competition_id
+            JOIN events ev ON ev.id = c.event_id
+            WHERE tm.team_id = NEW.team_id AND a.gender != ev.gender
+        )
+        THEN RAISE(ABORT, 'В команде есть спортсмены, не подходящие дисциплине по полу')
+        WHEN EXISTS (
+            SELECT 1
+            FROM team_members tm
+            JOIN athlete_ages aa ON aa.athlete_id = tm.athlete_id AND aa.competition_id = NEW.competition_id
+            JOIN competitions c ON c.id = NEW.competition_id
+            JOIN events ev ON ev.id = c.event_id
+            WHERE tm.team_id = NEW.team_id AND ( aa.age < ev.min_age OR aa.age > ev.max_age )
+        )
+        THEN RAISE(ABORT, 'В команде есть спортсмены, не подходящие дисциплине по возрасту')
+    END;
+END;
+
+-- Призёры каждой дисциплины, чтобы медали разных дисциплин одного вида
+-- спорта можно было различить
+CREATE VIEW event_medals AS
+SELECT
+    c.event_id,
+    cr.competition_id,
+    cr.place,
+    cr.participant_type,
+    cr.participant_id,
+    COALESCE(a.name, t.name) AS participant_name,
+    COALESCE(a.country_code, t.country_code) AS country_code
+FROM competition_results cr
+JOIN competitions c ON c.id = cr.competition_id
+LEFT JOIN athletes a ON cr.participant_type = 'athlete' AND cr.participant_id = a.id
+LEFT JOIN teams t ON cr.participant_type = 'team' AND cr.participant_id = t.id
+WHERE cr.place IN (1, 2, 3);
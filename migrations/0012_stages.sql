@@ -0,0 +1,155 @@
+-- Этапы соревнований: предварительные забеги, четвертьфиналы,
+-- полуфиналы и финалы. Соревнование ссылается на следующий этап сетки
+-- той же дисциплины, туда проходят первые advance_count мест (NULL —
+-- все участники). Если на этап ведут предыдущие, в нём могут выступать
+-- только прошедшие отбор. Медали дают только финалы, все старые
+-- соревнования считаются финалами, так что зачёт не меняется.
+
+ALTER TABLE competitions ADD COLUMN stage TEXT NOT NULL DEFAULT 'final'
+    CHECK ( stage IN ( 'heat', 'quarterfinal', 'semifinal', 'final' ) );
+-- При удалении следующего этапа связь пропадает, а сам этап остаётся
+ALTER TABLE competitions ADD COLUMN next_stage_id INTEGER REFERENCES competitions ( id ) ON DELETE SET NULL;
+ALTER TABLE competitions ADD COLUMN advance_count INTEGER CHECK ( advance_count > 0 );
+
+CREATE INDEX idx_competitions_next_stage_id ON competitions ( next_stage_id );
+
+-- Порядок этапов в сетке
+CREATE VIEW stage_ranks ( stage, rank ) AS
+VALUES ( 'heat', 1 ), ( 'quarterfinal', 2 ), ( 'semifinal', 3 ), ( 'final', 4 );
+
+CREATE TRIGGER ensure_competition_stage BEFORE INSERT ON competitions FOR EACH ROW
+WHEN NEW.next_stage_id IS NOT NULL BEGIN
+    SELECT
+    CASE
+        WHEN NEW.stage = 'final'
+        THEN RAISE(ABORT, 'Из финала некуда проходить дальше')
+        WHEN ( SELECT event_id FROM competitions WHERE id = NEW.next_stage_id ) != NEW.event_id
+        THEN RAISE(ABORT, 'Следующий этап относится к другой дисциплине')
+        WHEN ( SELECT r.rank FROM competitions c JOIN stage_ranks r ON r.stage = c.stage WHERE c.id = NEW.next_stage_id )
+            <= ( SELECT rank FROM stage_ranks WHERE stage = NEW.stage )
+        THEN RAISE(ABORT, 'Следующий этап должен быть дальше по сетке')
+        WHEN EXISTS ( SELECT 1 FROM competition_results WHERE competition_id = NEW.next_stage_id )
+        THEN RAISE(ABORT, 'Следующий этап уже проведён — условия прохода менять нельзя')
+    END;
+END;
+
+-- Удаление следующего этапа обнуляет ссылку (ON DELETE SET NULL), это
+-- тоже изменение, поэтому проверки срабатывают только на новую ссылку
+CREATE TRIGGER ensure_competition_stage_update BEFORE UPDATE OF stage, next_stage_id, advance_count, event_id ON competitions FOR EACH ROW
+WHEN NEW.stage IS NOT OLD.stage OR NEW.next_stage_id IS NOT OLD.next_stage_id
+    OR NEW.advance_count IS NOT OLD.advance_count OR NEW.event_id IS NOT OLD.event_id BEGIN
+    SELECT
+    CASE
+        WHEN NEW.next_stage_id IS NOT NULL AND NEW.stage = 'final'
+        THEN RAISE(ABORT, 'Из финала некуда проходить дальше')
+        WHEN ( SELECT event_id FROM competitions WHERE id = NEW.next_stage_id ) != NEW.event_id
+        THEN RAISE(ABORT, 'Следующий этап относится к другой дисциплине')
+        WHEN ( SELECT r.rank FROM competitions c JOIN stage_ranks r ON r.stage = c.stage WHERE c.id = NEW.next_stage_id )
+            <= ( SELECT rank FROM stage_ranks WHERE stage = NEW.stage )
+        THEN RAISE(ABORT, 'Следующий этап должен быть дальше по сетке')
+        WHEN EXISTS (
+            SELECT 1
+            FROM competitions p
+            JOIN stage_ranks pr ON pr.stage = p.stage
+            JOIN stage_ranks r ON r.stage = NEW.stage
+            WHERE p.next_stage_id = NEW.id AND ( p.event_id != NEW.event_id OR pr.rank >= r.rank )
+        )
+        THEN RAISE(ABORT, 'Этап не сходится с предыдущими этапами сетки')
+        -- Этап с результатами не становится финалом задним числом и
+        -- наоборот, иначе поменялся бы медальный зачёт
+        WHEN NEW.stage != OLD.stage AND EXISTS (
+            SELECT 1 FROM competition_results WHERE competition_id = NEW.id
+        )
+        THEN RAISE(ABORT, 'У соревнования есть результаты — сначала удалите их')
+        WHEN NEW.next_stage_id IS NOT NULL
+            AND ( NEW.next_stage_id IS NOT OLD.next_stage_id OR NEW.advance_count IS NOT OLD.advance_count )
+            AND EXISTS (
+                SELECT 1 FROM competition_results WHERE competition_id IN ( OLD.next_stage_id, NEW.next_stage_id )
+            )
+        THEN RAISE(ABORT, 'Следующий этап уже проведён — условия прохода менять нельзя')
+    END;
+END;
+
+-- Участники, прошедшие на этап competition_id с предыдущего этапа
+-- from_competition_id, где заняли место place
+CREATE VIEW stage_qualifiers AS
+SELECT
+    c.next_stage_id AS competition_id,
+    cr.participant_type,
+    cr.participant_id,
+    c.id AS from_competition_id,
+    cr.place
+FROM competitions c
+JOIN competition_results cr ON cr.competition_id = c.id
+WHERE c.next_stage_id IS NOT NULL AND cr.place <= coalesce(c.advance_count, cr.place);
+
+CREATE TRIGGER ensure_stage_qualified_athlete BEFORE INSERT ON competition_athletes FOR EACH ROW
+WHEN EXISTS ( SELECT 1 FROM competitions WHERE next_stage_id = NEW.competition_id ) BEGIN
+    SELECT
+    CASE
+        WHEN NOT EXISTS (
+            SELECT 1 FROM stage_qualifiers
+            WHERE competition_id = NEW.competition_id
+              AND participant_type = 'athlete' AND participant_id = NEW.athlete_id
+        )
+        THEN RAISE(ABORT, 'Участник не прошёл отбор на этот этап')
+    END;
+END;
+
+CREATE TRIGGER ensure_stage_qualified_team BEFORE INSERT ON competition_teams FOR EACH ROW
+WHEN EXISTS ( SELECT 1 FROM competitions WHERE next_stage_id = NEW.competition_id ) BEGIN
+    SELECT
+    CASE
+        WHEN NOT EXISTS (
+            SELECT 1 FROM stage_qualifiers
+            WHERE competition_id = NEW.competition_id
+              AND participant_type = 'team' AND participant_id = NEW.team_id
+        )
+        THEN RAISE(ABORT, 'Участник не прошёл отбор на этот этап')
+    END;
+END;
+
+-- Медали дают только финалы
+DROP VIEW country_medals;
+DROP VIEW podium_results;
+DROP VIEW event_medals;
+
+CREATE VIEW podium_results AS
+    SELECT
+        cr.competition_id,
+        cr.place,
+        COALESCE(a.country_code, t.country_code) AS country_code
+    FROM competition_results cr
+    JOIN competitions c ON c.id = cr.competition_id
+    LEFT JOIN athletes a ON cr.participant_type = 'athlete' AND cr.participant_id = a.id
+    LEFT JOIN teams t ON cr.participant_type = 'team' AND cr.participant_id = t.id
+    WHERE cr.place IN (1, 2, 3) AND c.stage = 'final'
+;
+
+CREATE VIEW country_medals AS
+    SELECT
+        c.name AS country,
+        COUNT(CASE WHEN pr.place = 1 THEN 1 END) AS gold,
+        COUNT(CASE WHEN pr.place = 2 THEN 1 END) AS silver,
+        COUNT(CASE WHEN pr.place = 3 THEN 1 END) AS bronze,
+        COUNT(pr.place) AS total
+    FROM countries c
+    LEFT JOIN podium_results pr ON pr.country_code = c.code
+    GROUP BY c.code, c.name
+    ORDER BY gold DESC, silver DESC, bronze DESC, country
+;
+
+CREATE VIEW event_medals AS
+SELECT
+    c.event_id,
+    cr.competition_id,
+    cr.place,
+    cr.participant_type,
+    cr.participant_id,
+    COALESCE(a.name, t.name) AS participant_name,
+    COALESCE(a.country_code, t.country_code) AS country_code
+FROM competition_results cr
+JOIN competitions c ON c.id = cr.competition_id
+LEFT JOIN athletes a ON cr.participant_type = 'athlete' AND cr.participant_id = a.id
+LEFT JOIN teams t ON cr.participant_type = 'team' AND cr.participant_id = t.id
+WHERE cr.place IN (1, 2, 3) AND c.stage = 'final';
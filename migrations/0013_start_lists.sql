@@ -0,0 +1,281 @@
+-- Стартовые листы: участников записывают в соревнование заранее, без
+-- места, поэтому place становится необязательным. Места ставятся, когда
+-- соревнование идёт или завершено, а завершить соревнование можно
+-- только когда места есть у всех. Медали и проход на следующий этап
+-- дают только завершённые соревнования, промежуточные места идущего
+-- соревнования не считаются.
+-- Таблицы пересоздаются без переименования: на них ссылается слишком
+-- много вьюх и триггеров, и переименование споткнулось бы о каждый.
+-- Данные копируются во временную таблицу, а таблица создаётся заново
+-- под тем же именем. Триггеры на самих таблицах удаляются вместе с
+-- ними, их создаём заново в прежнем порядке.
+
+CREATE TABLE old_competition_athletes AS SELECT competition_id, athlete_id, place FROM competition_athletes;
+DROP TABLE competition_athletes;
+
+CREATE TABLE competition_athletes (
+    competition_id INTEGER,
+    athlete_id INTEGER,
+
+    -- Какое место атлет занял в этом соревновании (может быть общим),
+    -- NULL — участник только записан
+    place INTEGER CHECK ( place > 0 ),
+
+    PRIMARY KEY ( competition_id, athlete_id ),
+    FOREIGN KEY ( competition_id ) REFERENCES competitions ( id ) ON DELETE CASCADE,
+    FOREIGN KEY ( athlete_id ) REFERENCES athletes ( id ) ON DELETE RESTRICT
+);
+INSERT INTO competition_athletes SELECT competition_id, athlete_id, place FROM old_competition_athletes;
+DROP TABLE old_competition_athletes;
+
+CREATE INDEX idx_competition_athletes_place ON competition_athletes ( competition_id, place );
+
+CREATE TABLE old_competition_teams AS SELECT competition_id, team_id, place FROM competition_teams;
+DROP TABLE competition_teams;
+
+CREATE TABLE competition_teams (
+    competition_id INTEGER,
+    team_id INTEGER,
+
+    -- Какое место команда заняла в этом соревновании (может быть общим),
+    -- NULL — участник только записан
+    place INTEGER CHECK ( place > 0 ),
+
+    PRIMARY KEY ( competition_id, team_id ),
+    FOREIGN KEY ( competition_id ) REFERENCES competitions ( id ) ON DELETE CASCADE,
+    FOREIGN KEY ( team_id ) REFERENCES teams ( id ) ON DELETE RESTRICT
+);
+INSERT INTO competition_teams SELECT competition_id, team_id, place FROM old_competition_teams;
+DROP TABLE old_competition_teams;
+
+CREATE INDEX idx_competition_teams_place ON competition_teams ( competition_id, place );
+
+CREATE TRIGGER ensure_individual_sport BEFORE INSERT ON competition_athletes FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT s.is_team
+            FROM competitions c
+            JOIN sports s ON c.sport_code = s.code
+            WHERE c.id = NEW.competition_id
+        ) = TRUE
+        THEN RAISE(ABORT, 'Командный спорт — нельзя добавлять одиночного атлета')
+    END;
+END;
+
+CREATE TRIGGER ensure_team_sport BEFORE INSERT ON competition_teams FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT s.is_team
+            FROM competitions c
+            JOIN sports s ON c.sport_code = s.code
+            WHERE c.id = NEW.competition_id
+        ) = FALSE
+        THEN RAISE(ABORT, 'Индивидуальный спорт — нельзя добавлять команду')
+    END;
+END;
+
+-- Места ставятся только идущему или завершённому соревнованию, а в
+-- завершённое записывают только с местом
+CREATE TRIGGER ensure_finished_individual BEFORE INSERT ON competition_athletes FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN NEW.place IS NOT NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'scheduled'
+        THEN RAISE(ABORT, 'Соревнование ещё не началось — результаты вносить нельзя')
+        WHEN NEW.place IS NOT NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'cancelled'
+        THEN RAISE(ABORT, 'Соревнование отменено — результаты вносить нельзя')
+        WHEN NEW.place IS NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'finished'
+        THEN RAISE(ABORT, 'Соревнование завершено — укажите место участника')
+    END;
+END;
+
+CREATE TRIGGER ensure_finished_individual_update BEFORE UPDATE OF place ON competition_athletes FOR EACH ROW
+WHEN NEW.place IS NOT OLD.place BEGIN
+    SELECT
+    CASE
+        WHEN NEW.place IS NOT NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'scheduled'
+        THEN RAISE(ABORT, 'Соревнование ещё не началось — результаты вносить нельзя')
+        WHEN NEW.place IS NOT NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'cancelled'
+        THEN RAISE(ABORT, 'Соревнование отменено — результаты вносить нельзя')
+        WHEN NEW.place IS NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'finished'
+        THEN RAISE(ABORT, 'Соревнование завершено — укажите место участника')
+    END;
+END;
+
+CREATE TRIGGER ensure_finished_team BEFORE INSERT ON competition_teams FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN NEW.place IS NOT NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'scheduled'
+        THEN RAISE(ABORT, 'Соревнование ещё не началось — результаты вносить нельзя')
+        WHEN NEW.place IS NOT NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'cancelled'
+        THEN RAISE(ABORT, 'Соревнование отменено — результаты вносить нельзя')
+        WHEN NEW.place IS NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'finished'
+        THEN RAISE(ABORT, 'Соревнование завершено — укажите место участника')
+    END;
+END;
+
+CREATE TRIGGER ensure_finished_team_update BEFORE UPDATE OF place ON competition_teams FOR EACH ROW
+WHEN NEW.place IS NOT OLD.place BEGIN
+    SELECT
+    CASE
+        WHEN NEW.place IS NOT NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'scheduled'
+        THEN RAISE(ABORT, 'Соревнование ещё не началось — результаты вносить нельзя')
+        WHEN NEW.place IS NOT NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'cancelled'
+        THEN RAISE(ABORT, 'Соревнование отменено — результаты вносить нельзя')
+        WHEN NEW.place IS NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'finished'
+        THEN RAISE(ABORT, 'Соревнование завершено — укажите место участника')
+    END;
+END;
+
+-- Стартовый лист не мешает отложить или отменить соревнование, мешают
+-- только места. Завершить соревнование можно, когда места есть у всех
+DROP TRIGGER ensure_finished_with_results;
+CREATE TRIGGER ensure_finished_with_results BEFORE UPDATE OF status ON competitions FOR EACH ROW
+WHEN NEW.status != OLD.status BEGIN
+    SELECT
+    CASE
+        WHEN NEW.status IN ( 'scheduled', 'cancelled' ) AND EXISTS (
+            SELECT 1 FROM competition_athletes WHERE competition_id = NEW.id AND place IS NOT NULL
+            UNION ALL
+            SELECT 1 FROM competition_teams WHERE competition_id = NEW.id AND place IS NOT NULL
+        )
+        THEN RAISE(ABORT, 'У соревнования есть результаты — сначала удалите их')
+        WHEN NEW.status = 'finished' AND EXISTS (
+            SELECT 1 FROM competition_athletes WHERE competition_id = NEW.id AND place IS NULL
+            UNION ALL
+            SELECT 1 FROM competition_teams WHERE competition_id = NEW.id AND place IS NULL
+        )
+        THEN RAISE(ABORT, 'Не у всех участников есть место — сначала расставьте их')
+    END;
+END;
+
+CREATE TRIGGER ensure_event_athlete BEFORE INSERT ON competition_athletes FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN (
+            SELECT a.gender != ev.gender
+            FROM athletes a
+            JOIN competitions c ON c.id = NEW.competition_id
+            JOIN events ev ON ev.id = c.event_id
+            WHERE a.id = NEW.athlete_id
+        ) = TRUE
+        THEN RAISE(ABORT, 'Спортсмен не подходит дисциплине по полу')
+        WHEN (
+            SELECT aa.age < ev.min_age OR aa.age > ev.max_age
+            FROM athlete_ages aa
+            JOIN competitions c ON c.id = aa.competition_id
+            JOIN events ev ON ev.id = c.event_id
+            WHERE aa.athlete_id = NEW.athlete_id AND aa.competition_id = NEW.competition_id
+        ) = TRUE
+        THEN RAISE(ABORT, 'Спортсмен не подходит дисциплине по возрасту')
+    END;
+END;
+
+CREATE TRIGGER ensure_event_team BEFORE INSERT ON competition_teams FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1
+            FROM team_members tm
+            JOIN athletes a ON a.id = tm.athlete_id
+            JOIN competitions c ON c.id = NEW.competition_id
+            JOIN events ev ON ev.id = c.event_id
+            WHERE tm.team_id = NEW.team_id AND a.gender != ev.gender
+        )
+        THEN RAISE(ABORT, 'В команде есть спортсмены, не подходящие дисциплине по полу')
+        WHEN EXISTS (
+            SELECT 1
+            FROM team_members tm
+            JOIN athlete_ages aa ON aa.athlete_id = tm.athlete_id AND aa.competition_id = NEW.competition_id
+            JOIN competitions c ON c.id = NEW.competition_id
+            JOIN events ev ON ev.id = c.event_id
+            WHERE tm.team_id = NEW.team_id AND ( aa.age < ev.min_age OR aa.age > ev.max_age )
+        )
+        THEN RAISE(ABORT, 'В команде есть спортсмены, не подходящие дисциплине по возрасту')
+    END;
+END;
+
+CREATE TRIGGER ensure_stage_qualified_athlete BEFORE INSERT ON competition_athletes FOR EACH ROW
+WHEN EXISTS ( SELECT 1 FROM competitions WHERE next_stage_id = NEW.competition_id ) BEGIN
+    SELECT
+    CASE
+        WHEN NOT EXISTS (
+            SELECT 1 FROM stage_qualifiers
+            WHERE competition_id = NEW.competition_id
+              AND participant_type = 'athlete' AND participant_id = NEW.athlete_id
+        )
+        THEN RAISE(ABORT, 'Участник не прошёл отбор на этот этап')
+    END;
+END;
+
+CREATE TRIGGER ensure_stage_qualified_team BEFORE INSERT ON competition_teams FOR EACH ROW
+WHEN EXISTS ( SELECT 1 FROM competitions WHERE next_stage_id = NEW.competition_id ) BEGIN
+    SELECT
+    CASE
+        WHEN NOT EXISTS (
+            SELECT 1 FROM stage_qualifiers
+            WHERE competition_id = NEW.competition_id
+              AND participant_type = 'team' AND participant_id = NEW.team_id
+        )
+        THEN RAISE(ABORT, 'Участник не прошёл отбор на этот этап')
+    END;
+END;
+
+-- Медали и проход дальше дают только завершённые соревнования
+DROP VIEW country_medals;
+DROP VIEW podium_results;
+DROP VIEW event_medals;
+DROP VIEW stage_qualifiers;
+
+CREATE VIEW stage_qualifiers AS
+SELECT
+    c.next_stage_id AS competition_id,
+    cr.participant_type,
+    cr.participant_id,
+    c.id AS from_competition_id,
+    cr.place
+FROM competitions c
+JOIN competition_results cr ON cr.competition_id = c.id
+WHERE c.next_stage_id IS NOT NULL AND c.status = 'finished'
+  AND cr.place <= coalesce(c.advance_count, cr.place);
+
+CREATE VIEW podium_results AS
+    SELECT
+        cr.competition_id,
+        cr.place,
+        COALESCE(a.country_code, t.country_code) AS country_code
+    FROM competition_results cr
+    JOIN competitions c ON c.id = cr.competition_id
+    LEFT JOIN athletes a ON cr.participant_type = 'athlete' AND cr.participant_id = a.id
+    LEFT JOIN teams t ON cr.participant_type = 'team' AND cr.participant_id = t.id
+    WHERE cr.place IN (1, 2, 3) AND c.stage = 'final' AND c.status = 'finished'
+;
+
+CREATE VIEW country_medals AS
+    SELECT
+        c.name AS country,
+        COUNT(CASE WHEN pr.place = 1 THEN 1 END) AS gold,
+        COUNT(CASE WHEN pr.place = 2 THEN 1 END) AS silver,
+        COUNT(CASE WHEN pr.place = 3 THEN 1 END) AS bronze,
+        COUNT(pr.place) AS total
+    FROM countries c
+    LEFT JOIN podium_results pr ON pr.country_code = c.code
+    GROUP BY c.code, c.name
+    ORDER BY gold DESC, silver DESC, bronze DESC, country
+;
+
+CREATE VIEW event_medals AS
+SELECT
+    c.event_id,
+    cr.competition_id,
+    cr.place,
+    cr.participant_type,
+    cr.participant_id,
+    COALESCE(a.name, t.name) AS participant_name,
+    COALESCE(a.country_code, t.country_code) AS country_code
+FROM competition_results cr
+JOIN competitions c ON c.id = cr.competition_id
+LEFT JOIN athletes a ON cr.participant_type = 'athlete' AND cr.participant_id = a.id
+LEFT JOIN teams t ON cr.participant_type = 'team' AND cr.participant_id = t.id
+WHERE cr.place IN (1, 2, 3) AND c.stage = 'final' AND c.status = 'finished';
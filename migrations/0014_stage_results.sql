@@ -0,0 +1,68 @@
+-- Результаты этапа, по которым уже набран следующий этап, не меняются:
+-- иначе на следующем этапе остались бы участники, которые отбор уже не
+-- проходят. То же со статусом — проход дают только завершённые этапы.
+-- Удаление самого этапа каскадом удаляет и результаты, но к этому
+-- моменту этапа уже нет, так что триггеры его не останавливают.
+
+CREATE TRIGGER ensure_stage_results_athlete_update BEFORE UPDATE OF place ON competition_athletes FOR EACH ROW
+WHEN NEW.place IS NOT OLD.place BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1
+            FROM competitions c
+            JOIN competition_results cr ON cr.competition_id = c.next_stage_id
+            WHERE c.id = OLD.competition_id
+        )
+        THEN RAISE(ABORT, 'На следующий этап уже записаны участники — результаты этапа менять нельзя')
+    END;
+END;
+
+CREATE TRIGGER ensure_stage_results_athlete_delete BEFORE DELETE ON competition_athletes FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1
+            FROM competitions c
+            JOIN competition_results cr ON cr.competition_id = c.next_stage_id
+            WHERE c.id = OLD.competition_id
+        )
+        THEN RAISE(ABORT, 'На следующий этап уже записаны участники — результаты этапа менять нельзя')
+    END;
+END;
+
+CREATE TRIGGER ensure_stage_results_team_update BEFORE UPDATE OF place ON competition_teams FOR EACH ROW
+WHEN NEW.place IS NOT OLD.place BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1
+            FROM competitions c
+            JOIN competition_results cr ON cr.competition_id = c.next_stage_id
+            WHERE c.id = OLD.competition_id
+        )
+        THEN RAISE(ABORT, 'На следующий этап уже записаны участники — результаты этапа менять нельзя')
+    END;
+END;
+
+CREATE TRIGGER ensure_stage_results_team_delete BEFORE DELETE ON competition_teams FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1
+            FROM competitions c
+            JOIN competition_results cr ON cr.competition_id = c.next_stage_id
+            WHERE c.id = OLD.competition_id
+        )
+        THEN RAISE(ABORT, 'На следующий этап уже записаны участники — результаты этапа менять нельзя')
+    END;
+END;
+
+CREATE TRIGGER ensure_stage_results_status BEFORE UPDATE OF status ON competitions FOR EACH ROW
+WHEN OLD.status = 'finished' AND NEW.status != 'finished' AND NEW.next_stage_id IS NOT NULL BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS ( SELECT 1 FROM competition_results WHERE competition_id = NEW.next_stage_id )
+        THEN RAISE(ABORT, 'На следующий этап уже записаны участники — результаты этапа менять нельзя')
+    END;
+END;
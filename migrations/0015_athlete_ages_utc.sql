@@ -0,0 +1,21 @@
+-- Возраст в 0011 считался в местном времени машины, хотя приложение
+-- пишет и время соревнований, и даты рождения в UTC (дата рождения —
+-- полночь UTC). Из-за этого в часовых поясах к западу от Гринвича
+-- спортсмен «молодел» на день. Теперь возраст считается в UTC и от
+-- часового пояса не зависит
+DROP VIEW IF EXISTS athlete_ages;
+
+CREATE VIEW athlete_ages AS
+SELECT
+    athlete_id,
+    competition_id,
+    CAST(strftime('%Y', day) AS INTEGER) - CAST(strftime('%Y', birthday) AS INTEGER)
+        - ( strftime('%m-%d', day) < strftime('%m-%d', birthday) ) AS age
+FROM (
+    SELECT
+        a.id AS athlete_id,
+        c.id AS competition_id,
+        CASE typeof(c.time) WHEN 'integer' THEN datetime(c.time, 'unixepoch') ELSE c.time END AS day,
+        CASE typeof(a.birthday) WHEN 'integer' THEN datetime(a.birthday, 'unixepoch') ELSE a.birthday END AS birthday
+    FROM athletes a, competitions c
+);
@@ -0,0 +1,98 @@
+-- Условия дисциплины проверялись только при записи в соревнование, и
+-- их легко было обойти: сменить пол или дату рождения уже записанному
+-- спортсмену, добавить в записанную команду неподходящего спортсмена
+-- или перенести соревнование на дату, к которой участники выйдут из
+-- возрастной группы. Как и триггеры правил составов из 0010, эти
+-- триггеры запрещают изменение, после которого запись перестала бы
+-- подходить дисциплине. Возраст считается как в athlete_ages, но по
+-- новым значениям: в BEFORE-триггере представление видит старые
+
+CREATE TRIGGER ensure_event_athlete_update BEFORE UPDATE OF gender, birthday ON athletes FOR EACH ROW
+WHEN NEW.gender IS NOT OLD.gender OR NEW.birthday IS NOT OLD.birthday BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1
+            FROM athlete_entries ae
+            JOIN competitions c ON c.id = ae.competition_id
+            JOIN events ev ON ev.id = c.event_id
+            WHERE ae.athlete_id = NEW.id AND NEW.gender != ev.gender
+        )
+        THEN RAISE(ABORT, 'Спортсмен записан в соревнования, дисциплине которых он не подойдёт по полу')
+        WHEN EXISTS (
+            SELECT 1
+            FROM (
+                SELECT
+                    ev.min_age,
+                    ev.max_age,
+                    CAST(strftime('%Y', day) AS INTEGER) - CAST(strftime('%Y', birthday) AS INTEGER)
+                        - ( strftime('%m-%d', day) < strftime('%m-%d', birthday) ) AS age
+                FROM (
+                    SELECT
+                        c.event_id,
+                        CASE typeof(c.time) WHEN 'integer' THEN datetime(c.time, 'unixepoch') ELSE c.time END AS day,
+                        CASE typeof(NEW.birthday) WHEN 'integer' THEN datetime(NEW.birthday, 'unixepoch') ELSE NEW.birthday END AS birthday
+                    FROM athlete_entries ae
+                    JOIN competitions c ON c.id = ae.competition_id
+                    WHERE ae.athlete_id = NEW.id
+                ) e
+                JOIN events ev ON ev.id = e.event_id
+            )
+            WHERE age < min_age OR age > max_age
+        )
+        THEN RAISE(ABORT, 'Спортсмен записан в соревнования, дисциплине которых он не подойдёт по возрасту')
+    END;
+END;
+
+CREATE TRIGGER ensure_event_team_members BEFORE INSERT ON team_members FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1
+            FROM competition_teams ct
+            JOIN competitions c ON c.id = ct.competition_id
+            JOIN events ev ON ev.id = c.event_id
+            JOIN athletes a ON a.id = NEW.athlete_id
+            WHERE ct.team_id = NEW.team_id AND a.gender != ev.gender
+        )
+        THEN RAISE(ABORT, 'Команда записана в соревнования, дисциплине которых спортсмен не подходит по полу')
+        WHEN EXISTS (
+            SELECT 1
+            FROM competition_teams ct
+            JOIN athlete_ages aa ON aa.athlete_id = NEW.athlete_id AND aa.competition_id = ct.competition_id
+            JOIN competitions c ON c.id = ct.competition_id
+            JOIN events ev ON ev.id = c.event_id
+            WHERE ct.team_id = NEW.team_id AND ( aa.age < ev.min_age OR aa.age > ev.max_age )
+        )
+        THEN RAISE(ABORT, 'Команда записана в соревнования, дисциплине которых спортсмен не подходит по возрасту')
+    END;
+END;
+
+-- Пол от даты не зависит, так что проверяется только возраст
+CREATE TRIGGER ensure_event_time_update BEFORE UPDATE OF time ON competitions FOR EACH ROW
+WHEN NEW.time IS NOT OLD.time BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1
+            FROM (
+                SELECT
+                    ev.min_age,
+                    ev.max_age,
+                    CAST(strftime('%Y', day) AS INTEGER) - CAST(strftime('%Y', birthday) AS INTEGER)
+                        - ( strftime('%m-%d', day) < strftime('%m-%d', birthday) ) AS age
+                FROM (
+                    SELECT
+                        CASE typeof(NEW.time) WHEN 'integer' THEN datetime(NEW.time, 'unixepoch') ELSE NEW.time END AS day,
+                        CASE typeof(a.birthday) WHEN 'integer' THEN datetime(a.birthday, 'unixepoch') ELSE a.birthday END AS birthday
+                    FROM athlete_entries ae
+                    JOIN athletes a ON a.id = ae.athlete_id
+                    WHERE ae.competition_id = NEW.id
+                )
+                JOIN events ev ON ev.id = NEW.event_id
+            )
+            WHERE age < min_age OR age > max_age
+        )
+        THEN RAISE(ABORT, 'Участники соревнования не подойдут дисциплине по возрасту на новую дату')
+    END;
+END;
@@ -0,0 +1,75 @@
+-- Места принимаются только у завершённых соревнований, как и было до
+-- стартовых листов: у идущего соревнования мест ещё нет. Поэтому
+-- завершить соревнование теперь можно и без мест, а расставляют их уже
+-- после завершения. Участники без места в завершённом соревновании —
+-- те, кому место ещё не поставили; новых туда записывают только с
+-- местом. В отменённое соревнование не записывают совсем, но стартовый
+-- лист при отмене остаётся. Места, поставленные идущим соревнованиям
+-- по правилам 0013, не трогаем.
+
+DROP TRIGGER ensure_finished_individual;
+DROP TRIGGER ensure_finished_individual_update;
+DROP TRIGGER ensure_finished_team;
+DROP TRIGGER ensure_finished_team_update;
+DROP TRIGGER ensure_finished_with_results;
+
+CREATE TRIGGER ensure_finished_individual BEFORE INSERT ON competition_athletes FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'cancelled'
+        THEN RAISE(ABORT, 'Соревнование отменено — записывать участников нельзя')
+        WHEN NEW.place IS NOT NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) != 'finished'
+        THEN RAISE(ABORT, 'Соревнование не завершено — места ставятся только после завершения')
+        WHEN NEW.place IS NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'finished'
+        THEN RAISE(ABORT, 'Соревнование завершено — укажите место участника')
+    END;
+END;
+
+CREATE TRIGGER ensure_finished_individual_update BEFORE UPDATE OF place ON competition_athletes FOR EACH ROW
+WHEN NEW.place IS NOT OLD.place BEGIN
+    SELECT
+    CASE
+        WHEN NEW.place IS NOT NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) != 'finished'
+        THEN RAISE(ABORT, 'Соревнование не завершено — места ставятся только после завершения')
+        WHEN NEW.place IS NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'finished'
+        THEN RAISE(ABORT, 'Соревнование завершено — укажите место участника')
+    END;
+END;
+
+CREATE TRIGGER ensure_finished_team BEFORE INSERT ON competition_teams FOR EACH ROW BEGIN
+    SELECT
+    CASE
+        WHEN ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'cancelled'
+        THEN RAISE(ABORT, 'Соревнование отменено — записывать участников нельзя')
+        WHEN NEW.place IS NOT NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) != 'finished'
+        THEN RAISE(ABORT, 'Соревнование не завершено — места ставятся только после завершения')
+        WHEN NEW.place IS NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'finished'
+        THEN RAISE(ABORT, 'Соревнование завершено — укажите место участника')
+    END;
+END;
+
+CREATE TRIGGER ensure_finished_team_update BEFORE UPDATE OF place ON competition_teams FOR EACH ROW
+WHEN NEW.place IS NOT OLD.place BEGIN
+    SELECT
+    CASE
+        WHEN NEW.place IS NOT NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) != 'finished'
+        THEN RAISE(ABORT, 'Соревнование не завершено — места ставятся только после завершения')
+        WHEN NEW.place IS NULL AND ( SELECT status FROM competitions WHERE id = NEW.competition_id ) = 'finished'
+        THEN RAISE(ABORT, 'Соревнование завершено — укажите место участника')
+    END;
+END;
+
+-- Места бывают только у завершённых, так что из завершённых с местами
+-- не выходят. Стартовый лист смене статуса не мешает
+CREATE TRIGGER ensure_finished_with_results BEFORE UPDATE OF status ON competitions FOR EACH ROW
+WHEN NEW.status != OLD.status AND NEW.status != 'finished' BEGIN
+    SELECT
+    CASE
+        WHEN EXISTS (
+            SELECT 1 FROM competition_athletes WHERE competition_id = NEW.id AND place IS NOT NULL
+            UNION ALL
+            SELECT 1 FROM competition_teams WHERE competition_id = NEW.id AND place IS NOT NULL
+        )
+        THEN RAISE(ABORT, 'У соревнования есть результаты — сначала удалите их')
+    END;
+END;
@@ -24,6 +24,21 @@ INSERT INTO sports (code, name, is_team) VALUES
 ('HOC', 'Хоккей', TRUE),
 ('RUG', 'Регби', TRUE);
 
+-- дисциплины
+INSERT INTO events (id, sport_code, name, gender, min_age, max_age, weight_class) VALUES
+(1, 'GYM', 'Многоборье', NULL, NULL, NULL, NULL),
+(2, 'SWM', '100 м вольным стилем, женщины', 'F', NULL, NULL, NULL),
+(3, 'ATH', 'Бег 100 м', NULL, NULL, NULL, NULL),
+(4, 'TEN', 'Одиночный разряд', NULL, NULL, NULL, NULL),
+(5, 'BOX', 'Первый средний вес', NULL, NULL, NULL, 'до 75 кг'),
+(6, 'FBL', 'Турнир', NULL, NULL, NULL, NULL),
+(7, 'BKB', 'Турнир', NULL, NULL, NULL, NULL),
+(8, 'VBL', 'Турнир', NULL, NULL, NULL, NULL),
+(9, 'HOC', 'Турнир', NULL, NULL, NULL, NULL),
+(10, 'RUG', 'Турнир', NULL, NULL, NULL, NULL),
+(11, 'SWM', '100 м вольным стилем, мужчины', 'M', NULL, NULL, NULL),
+(12, 'ATH', 'Бег 100 м, юниоры', NULL, 16, 20, NULL);
+
 -- спортсмены
 INSERT INTO athletes (id, name, gender, birthday, country_code) VALUES
 (1, 'Иван Петров', 'M', '1995-03-15', 'RUS'),
@@ -80,19 +95,19 @@ INSERT INTO sites (id, name) VALUES
 (10, 'Стадион для регби');
 
 -- соревнования
-INSERT INTO competitions (id, time, sport_code, site_id) VALUES
+INSERT INTO competitions (id, time, sport_code, event_id, site_id) VALUES
 -- индивидуальные
-(1, '2024-01-15 10:00:00', 'GYM', 3),
-(2, '2024-01-15 14:30:00', 'SWM', 2),
-(3, '2024-01-16 09:00:00', 'ATH', 1),
-(4, '2024-01-16 16:00:00', 'TEN', 4),
-(5, '2024-01-19 19:00:00', 'BOX', 5),
+(1, '2024-01-15 10:00:00', 'GYM', 1, 3),
+(2, '2024-01-15 14:30:00', 'SWM', 2, 2),
+(3, '2024-01-16 09:00:00', 'ATH', 3, 1),
+(4, '2024-01-16 16:00:00', 'TEN', 4, 4),
+(5, '2024-01-19 19:00:00', 'BOX', 5, 5),
 -- командные
-(6, '2024-01-15 15:00:00', 'FBL', 6),
-(7, '2024-01-15 18:30:00', 'BKB', 7),
-(8, '2024-01-16 12:00:00', 'VBL', 8),
-(9, '2024-01-16 20:00:00', 'HOC', 9),
-(10, '2024-01-23 17:00:00', 'RUG', 10);
+(6, '2024-01-15 15:00:00', 'FBL', 6, 6),
+(7, '2024-01-15 18:30:00', 'BKB', 7, 7),
+(8, '2024-01-16 12:00:00', 'VBL', 8, 8),
+(9, '2024-01-16 20:00:00', 'HOC', 9, 9),
+(10, '2024-01-23 17:00:00', 'RUG', 10, 10);
 
 -- результаты для индивидуальных
 INSERT INTO competition_athletes (competition_id, athlete_id, place) VALUES
@@ -132,6 +147,18 @@ VALUES (1, 1, 4);  -- гимнастика индивидуальный
 INSERT INTO team_members (team_id, athlete_id)
 VALUES (1, 4);  -- американский спортсмен в российскую команду
 
+INSERT INTO teams (name, country_code, sport_code)
+VALUES ('Сборная России по гимнастике', 'RUS', 'GYM');  -- гимнастика не командный вид спорта
+
 INSERT INTO competition_athletes (competition_id, athlete_id, place)
-VALUES (1, 2, 1);  -- первое место уже занято
+VALUES (1, 2, 0);  -- места начинаются с первого
+
+INSERT INTO competition_athletes (competition_id, athlete_id)
+VALUES (1, 2);  -- у завершённого соревнования место обязательно
 
+
+INSERT INTO competitions (time, sport_code, event_id, site_id)
+VALUES ('2024-01-15 11:00:00', 'GYM', 1, 3);  -- место занято соревнованием 1
+
+INSERT INTO competition_athletes (competition_id, athlete_id, place)
+VALUES (2, 1, 4);  -- мужчина в женской дисциплине
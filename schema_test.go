@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "перезаписать эталонные файлы в testdata")
+
+// После этой строки в populate.sql идут запросы, которые база должна
+// отклонить
+const populateErrorsMarker = "-- тесты которые должны вызвать ошибки"
+
+func readPopulate(t *testing.T) (data string, errorStatements []string) {
+	t.Helper()
+
+	script, err := os.ReadFile("populate.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, errors, ok := strings.Cut(string(script), populateErrorsMarker)
+	if !ok {
+		t.Fatalf("в populate.sql нет строки %q", populateErrorsMarker)
+	}
+
+	for _, stmt := range strings.Split(errors, ";") {
+		if strings.Contains(stmt, "INSERT") {
+			errorStatements = append(errorStatements, stmt)
+		}
+	}
+	return data, errorStatements
+}
+
+// База с данными из populate.sql, без запросов с ошибками
+func openPopulatedStore(t *testing.T) *sqliteStore {
+	t.Helper()
+
+	s := openTestStore(t)
+	data, _ := readPopulate(t)
+	if _, err := s.db.Exec(data); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPopulateErrors(t *testing.T) {
+	s := openPopulatedStore(t)
+	_, statements := readPopulate(t)
+	if len(statements) == 0 {
+		t.Fatal("в populate.sql нет запросов с ошибками")
+	}
+
+	for _, stmt := range statements {
+		if _, err := s.db.Exec(stmt); err == nil {
+			t.Errorf("запрос прошёл, а не должен был:%s", stmt)
+		}
+	}
+}
+
+// База, созданная до появления миграций, не имеет версии, но данные в
+// ней есть, и перед миграцией с неё тоже снимается снимок
+func TestMigrateSnapshotsUnversioned(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db.sqlite3")
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	init, err := migrationFiles.ReadFile("migrations/0001_init.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(init)); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbMigrate(ctx, db, path); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := listSnapshots(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Reason != "before-migration-1" {
+		t.Errorf("снимки: %+v", snapshots)
+	}
+}
+
+// Удаление соревнования или спортсмена уносит и связанные записи,
+// поэтому перед ним снимается снимок
+func TestDeleteSnapshots(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db.sqlite3")
+
+	s, err := openSQLiteStore(ctx, path, true)
+	must(t, err)
+	defer s.close()
+
+	must(t, s.deleteCompetition(ctx, 1))
+	// Имена снимков различаются с точностью до миллисекунды
+	time.Sleep(2 * time.Millisecond)
+	must(t, s.deleteAthlete(ctx, 1))
+
+	snapshots, err := listSnapshots(path)
+	must(t, err)
+	if len(snapshots) != 2 || snapshots[0].Reason != "before-delete" || snapshots[1].Reason != "before-delete" {
+		t.Errorf("снимки: %+v", snapshots)
+	}
+}
+
+// Снимки при запуске не вытесняют снимки с другими причинами
+func TestPruneSnapshotsPerReason(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.sqlite3")
+	must(t, os.MkdirAll(snapshotDir(path), 0755))
+
+	start := time.Date(2025, 1, 2, 15, 0, 0, 0, time.Local)
+	create := func(at time.Time, reason string) {
+		name := snapshotPrefix(path) + at.Format(snapshotTimeLayout) + "-" + reason + ".sqlite3"
+		must(t, os.WriteFile(filepath.Join(snapshotDir(path), name), nil, 0644))
+	}
+	create(start, "before-import")
+	for i := 1; i <= maxSnapshots+5; i++ {
+		create(start.Add(time.Duration(i)*time.Minute), "startup")
+	}
+	must(t, pruneSnapshots(path))
+
+	snapshots, err := listSnapshots(path)
+	must(t, err)
+	if len(snapshots) != maxSnapshots+1 || snapshots[len(snapshots)-1].Reason != "before-import" ||
+		!snapshots[0].Time.Equal(start.Add(time.Duration(maxSnapshots+5)*time.Minute)) {
+		t.Errorf("снимки после очистки: %d, самый старый %+v", len(snapshots), snapshots[len(snapshots)-1])
+	}
+}
+
+// Каждый случай выполняется в своей транзакции, которая потом
+// откатывается, так что они друг на друга не влияют
+func TestSchemaConstraints(t *testing.T) {
+	s := openPopulatedStore(t)
+
+	tests := []struct {
+		name string
+		sql string
+		wantErr string
+	}{
+		{
+			"ensure_individual_sport",
+			"INSERT INTO competition_athletes (competition_id, athlete_id, place) VALUES (6, 1, 4);",
+			"Командный спорт — нельзя добавлять одиночного атлета",
+		},
+		{
+			"ensure_team_sport",
+			"INSERT INTO competition_teams (competition_id, team_id, place) VALUES (1, 1, 4);",
+			"Индивидуальный спорт — нельзя добавлять команду",
+		},
+		{
+			"ensure_team_members_country",
+			"INSERT INTO team_members (team_id, athlete_id) VALUES (1, 4);",
+			"Спортсмен не соответствует команде по стране",
+		},
+		{
+			"ensure_finished_individual",
+			`INSERT INTO competitions (id, time, status, sport_code, event_id, site_id) VALUES (11, '2024-02-01 10:00:00', 'in_progress', 'GYM', 1, 3);
+			 INSERT INTO competition_athletes (competition_id, athlete_id, place) VALUES (11, 1, 1);`,
+			"Соревнование не завершено — места ставятся только после завершения",
+		},
+		{
+			"ensure_finished_team",
+			`INSERT INTO competitions (id, time, status, sport_code, event_id, site_id) VALUES (11, '2024-02-01 10:00:00', 'cancelled', 'FBL', 6, 6);
+			 INSERT INTO competition_teams (competition_id, team_id) VALUES (11, 1);`,
+			"Соревнование отменено — записывать участников нельзя",
+		},
+		{
+			"ensure_finished_individual_update",
+			`INSERT INTO competitions (id, time, status, sport_code, event_id, site_id) VALUES (11, '2024-02-01 10:00:00', 'in_progress', 'GYM', 1, 3);
+			 INSERT INTO competition_athletes (competition_id, athlete_id) VALUES (11, 1);
+			 UPDATE competition_athletes SET place = 1 WHERE competition_id = 11;`,
+			"Соревнование не завершено — места ставятся только после завершения",
+		},
+		{
+			"ensure_finished_individual без места",
+			"INSERT INTO competition_athletes (competition_id, athlete_id) VALUES (1, 2);",
+			"Соревнование завершено — укажите место участника",
+		},
+		{
+			"ensure_finished_team_update",
+			"UPDATE competition_teams SET place = NULL WHERE competition_id = 6 AND team_id = 1;",
+			"Соревнование завершено — укажите место участника",
+		},
+		{
+			"ensure_finished_with_results",
+			"UPDATE competitions SET status = 'cancelled' WHERE id = 1;",
+			"У соревнования есть результаты — сначала удалите их",
+		},
+		{
+			"ensure_site_free",
+			"INSERT INTO competitions (time, sport_code, event_id, site_id) VALUES ('2024-01-15 11:00:00', 'GYM', 1, 3);",
+			"Место уже занято другим соревнованием в это время",
+		},
+		{
+			"ensure_site_free_update",
+			"UPDATE competitions SET site_id = 3, time = '2024-01-15 11:00:00' WHERE id = 2;",
+			"Место уже занято другим соревнованием в это время",
+		},
+		{
+			"ensure_competition_event",
+			"INSERT INTO competitions (time, sport_code, site_id) VALUES ('2024-03-01 10:00:00', 'GYM', 3);",
+			"Не указана дисциплина соревнования",
+		},
+		{
+			"дисциплина другого вида спорта",
+			"INSERT INTO competitions (time, sport_code, event_id, site_id) VALUES ('2024-03-01 10:00:00', 'GYM', 2, 3);",
+			"Дисциплина относится к другому виду спорта",
+		},
+		{
+			"ensure_competition_event_update",
+			"UPDATE competitions SET event_id = 11 WHERE id = 2;",
+			"У соревнования есть результаты — сначала удалите их",
+		},
+		{
+			"ensure_event_rules_change",
+			"UPDATE events SET gender = NULL WHERE id = 2;",
+			"По дисциплине уже есть результаты — условия участия менять нельзя",
+		},
+		{
+			"ensure_event_athlete",
+			"INSERT INTO competition_athletes (competition_id, athlete_id, place) VALUES (2, 1, 4);",
+			"Спортсмен не подходит дисциплине по полу",
+		},
+		{
+			"ensure_event_athlete по возрасту",
+			`INSERT INTO competitions (id, time, sport_code, event_id, site_id) VALUES (11, '2024-02-01 10:00:00', 'ATH', 12, 1);
+			 INSERT INTO competition_athletes (competition_id, athlete_id, place) VALUES (11, 3, 1);`,
+			"Спортсмен не подходит дисциплине по возрасту",
+		},
+		{
+			"ensure_event_team",
+			`INSERT INTO events (id, sport_code, name, gender) VALUES (13, 'FBL', 'Женский турнир', 'F');
+			 INSERT INTO competitions (id, time, sport_code, event_id, site_id) VALUES (11, '2024-02-01 10:00:00', 'FBL', 13, 6);
+			 INSERT INTO competition_teams (competition_id, team_id, place) VALUES (11, 1, 1);`,
+			"В команде есть спортсмены, не подходящие дисциплине по полу",
+		},
+		{
+			"ensure_competition_stage",
+			"INSERT INTO competitions (time, sport_code, event_id, site_id, next_stage_id) VALUES ('2024-03-01 10:00:00', 'GYM', 1, 3, 1);",
+			"Из финала некуда проходить дальше",
+		},
+		{
+			"ensure_competition_stage_update",
+			"UPDATE competitions SET stage = 'heat', next_stage_id = 3 WHERE id = 2;",
+			"Следующий этап относится к другой дисциплине",
+		},
+		{
+			"ensure_stage_qualified_athlete",
+			`INSERT INTO competitions (id, time, sport_code, event_id, site_id) VALUES (11, '2024-02-02 10:00:00', 'SWM', 2, 2);
+			 INSERT INTO competitions (id, time, sport_code, event_id, site_id, stage, next_stage_id, advance_count)
+			 VALUES (12, '2024-02-01 10:00:00', 'SWM', 2, 2, 'heat', 11, 1);
+			 INSERT INTO competition_athletes (competition_id, athlete_id, place) VALUES (12, 2, 1), (12, 5, 2);
+			 INSERT INTO competition_athletes (competition_id, athlete_id, place) VALUES (11, 5, 1);`,
+			"Участник не прошёл отбор на этот этап",
+		},
+		{
+			"ensure_stage_results_athlete_delete",
+			`INSERT INTO competitions (id, time, sport_code, event_id, site_id) VALUES (11, '2024-02-02 10:00:00', 'SWM', 2, 2);
+			 INSERT INTO competitions (id, time, sport_code, event_id, site_id, stage, next_stage_id, advance_count)
+			 VALUES (12, '2024-02-01 10:00:00', 'SWM', 2, 2, 'heat', 11, 1);
+			 INSERT INTO competition_athletes (competition_id, athlete_id, place) VALUES (12, 2, 1), (12, 5, 2);
+			 INSERT INTO competition_athletes (competition_id, athlete_id, place) VALUES (11, 2, 1);
+			 DELETE FROM competition_athletes WHERE competition_id = 12 AND athlete_id = 2;`,
+			"На следующий этап уже записаны участники — результаты этапа менять нельзя",
+		},
+		{
+			"пустой код страны",
+			"INSERT INTO countries (code, name) VALUES ('', 'Пусто');",
+			"CHECK constraint failed",
+		},
+		{
+			"пустое название места",
+			"INSERT INTO sites (name) VALUES ('');",
+			"CHECK constraint failed",
+		},
+		{
+			"неизвестный пол",
+			"INSERT INTO athletes (name, gender, birthday, country_code) VALUES ('Никто', 'X', '1990-01-01', 'RUS');",
+			"CHECK constraint failed",
+		},
+		{
+			"день рождения в будущем",
+			"INSERT INTO athletes (name, gender, birthday, country_code) VALUES ('Никто', 'M', '2999-01-01', 'RUS');",
+			"CHECK constraint failed",
+		},
+		{
+			"нулевое место",
+			"INSERT INTO competition_athletes (competition_id, athlete_id, place) VALUES (1, 2, 0);",
+			"CHECK constraint failed",
+		},
+		{
+			"неизвестный статус",
+			"INSERT INTO competitions (time, status, sport_code, event_id, site_id) VALUES ('2024-03-01 10:00:00', 'unknown', 'GYM', 1, 3);",
+			"CHECK constraint failed",
+		},
+		{
+			"завершено в будущем",
+			"INSERT INTO competitions (time, status, sport_code, event_id, site_id) VALUES ('2999-01-01 10:00:00', 'finished', 'GYM', 1, 3);",
+			"CHECK constraint failed",
+		},
+		{
+			"нулевая длительность",
+			"INSERT INTO competitions (time, duration, sport_code, event_id, site_id) VALUES ('2024-03-01 10:00:00', 0, 'GYM', 1, 3);",
+			"CHECK constraint failed",
+		},
+		{
+			"ensure_team_of_team_sport",
+			"INSERT INTO teams (name, country_code, sport_code) VALUES ('Сборная', 'RUS', 'GYM');",
+			"Индивидуальный вид спорта — команду создать нельзя",
+		},
+		{
+			"ensure_sport_with_teams",
+			"UPDATE sports SET is_team = FALSE WHERE code = 'FBL';",
+			"У вида спорта есть команды — сначала удалите их",
+		},
+		{
+			"максимум игроков меньше минимума",
+			"INSERT INTO sports (code, name, is_team, min_players, max_players) VALUES ('XXX', 'Другой', TRUE, 5, 3);",
+			"CHECK constraint failed",
+		},
+		{
+			"неизвестный пол команды",
+			"INSERT INTO sports (code, name, is_team, team_gender) VALUES ('XXX', 'Другой', TRUE, 'X');",
+			"CHECK constraint failed",
+		},
+		{
+			"несуществующая страна",
+			"INSERT INTO athletes (name, gender, birthday, country_code) VALUES ('Никто', 'M', '1990-01-01', 'XXX');",
+			"FOREIGN KEY constraint failed",
+		},
+		{
+			"страна с зависимыми записями",
+			"DELETE FROM countries WHERE code = 'RUS';",
+			"FOREIGN KEY constraint failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := s.db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+
+			_, err = tx.Exec(tt.sql)
+			mustFailWith(t, err, tt.wantErr)
+		})
+	}
+}
+
+// Медальный зачёт по populate.sql сверяется с testdata/*.golden.
+// После намеренных изменений эталоны обновляются через go test -update
+func TestCountryMedalsGolden(t *testing.T) {
+	s := openPopulatedStore(t)
+
+	tests := []struct {
+		golden string
+		withoutMedals bool
+	}{
+		{"country_medals.golden", false},
+		{"country_medals_all.golden", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			medals, err := s.getCountryMedals(context.Background(), tt.withoutMedals)
+			must(t, err)
+
+			var got bytes.Buffer
+			headers, _, rows := medalsTable(pointers(medals))
+			must(t, writeCSV(&got, headers, rows))
+
+			path := filepath.Join("testdata", tt.golden)
+			if *updateGolden {
+				must(t, os.WriteFile(path, got.Bytes(), 0644))
+			}
+			want, err := os.ReadFile(path)
+			must(t, err)
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("медальный зачёт не совпадает с %s:\n%s\nожидалось:\n%s", path, got.Bytes(), want)
+			}
+		})
+	}
+}
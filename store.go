@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Хранилище данных, с которым работает интерфейс. Основная реализация —
+// sqliteStore (db.go), для проверок без файла базы есть memStore
+// (memstore.go). Отмена, журнал аудита, импорт, дампы и снимки есть
+// только у sqliteStore, поэтому в интерфейс они не входят. Интерфейс
+// читает справочники через кэш cachedStore (cache.go)
+
+type CountryStore interface {
+	getCountries(ctx context.Context) ([]Country, error)
+	addCountry(ctx context.Context, code string, name string) error
+	updateCountry(ctx context.Context, oldCode string, code string, name string) error
+	deleteCountry(ctx context.Context, code string) error
+}
+
+type SportStore interface {
+	getSports(ctx context.Context) ([]Sport, error)
+	addSport(ctx context.Context, sport Sport) error
+	updateSport(ctx context.Context, oldCode string, sport Sport) error
+	deleteSport(ctx context.Context, code string) error
+}
+
+type EventStore interface {
+	getEvents(ctx context.Context) ([]Event, error)
+	addEvent(ctx context.Context, e Event) error
+	updateEvent(ctx context.Context, e Event) error
+	deleteEvent(ctx context.Context, ID int) error
+}
+
+type AthleteStore interface {
+	getAthletes(ctx context.Context) ([]Athlete, error)
+	getAthleteProfile(ctx context.Context, ID int) (AthleteProfile, error)
+	addAthlete(ctx context.Context, name string, isMale bool, birthday time.Time, countryCode string) error
+	updateAthlete(ctx context.Context, ID int, name string, isMale bool, birthday time.Time, countryCode string) error
+	deleteAthlete(ctx context.Context, ID int) error
+}
+
+type SiteStore interface {
+	getSites(ctx context.Context) ([]Site, error)
+	addSite(ctx context.Context, name string) error
+	updateSite(ctx context.Context, ID int, name string) error
+	deleteSite(ctx context.Context, ID int) error
+}
+
+type TeamStore interface {
+	getTeams(ctx context.Context, withMembers bool) ([]Team, error)
+	getTeamMembers(ctx context.Context, teamID int) ([]Athlete, error)
+	addTeam(ctx context.Context, name string, countryCode string, sportCode string) error
+	updateTeam(ctx context.Context, ID int, name string, countryCode string, sportCode string) error
+	deleteTeam(ctx context.Context, ID int) error
+	addAthleteToTeam(ctx context.Context, teamID int, athleteID int) error
+	deleteAthleteFromTeam(ctx context.Context, teamID int, athleteID int) error
+}
+
+type CompetitionStore interface {
+	getCompetitions(ctx context.Context) ([]Competition, error)
+	getCompetition(ctx context.Context, ID int) (Competition, error)
+	addCompetition(ctx context.Context, t time.Time, duration int, status CompetitionStatus, eventID int, siteID int) error
+	updateCompetition(ctx context.Context, ID int, t time.Time, duration int, status CompetitionStatus, eventID int, siteID int,
+	stage CompetitionStage, nextStageID int, advanceCount int) error
+	deleteCompetition(ctx context.Context, ID int) error
+	setCompetitionStage(ctx context.Context, ID int, stage CompetitionStage, nextStageID int, advanceCount int) error
+	getSiteConflicts(ctx context.Context) (map[int][]int, error)
+	findFreeSlot(ctx context.Context, siteID int, day time.Time, duration int) (time.Time, error)
+	getAthleteConflicts(ctx context.Context) ([]AthleteConflict, error)
+}
+
+type ResultStore interface {
+	getCompetitionResults(ctx context.Context, competitionID int) ([]Result, error)
+	getStageQualifiers(ctx context.Context, competitionID int) ([]Result, error)
+	addResult(ctx context.Context, competitionID int, isTeam bool, participantID int, place int) error
+	addStageQualifiers(ctx context.Context, competitionID int) error
+	setResultPlace(ctx context.Context, r Result, place int) error
+	deleteResult(ctx context.Context, r Result) error
+}
+
+type MedalStore interface {
+	getCountryMedals(ctx context.Context, withoutMedals bool) ([]CountryMedals, error)
+	getEventMedals(ctx context.Context) ([]EventMedal, error)
+}
+
+type Store interface {
+	CountryStore
+	SportStore
+	EventStore
+	AthleteStore
+	SiteStore
+	TeamStore
+	CompetitionStore
+	ResultStore
+	MedalStore
+
+	close() error
+}
+
+var (
+	_ Store = (*sqliteStore)(nil)
+	_ Store = (*memStore)(nil)
+	_ Store = (*cachedStore)(nil)
+)
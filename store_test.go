@@ -0,0 +1,869 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testDBCount int
+
+// База SQLite в памяти со всеми миграциями и триггерами журналов
+func openTestStore(t *testing.T) *sqliteStore {
+	t.Helper()
+	ctx := context.Background()
+
+	// Без имени и общего кэша у каждого соединения пула была бы своя
+	// пустая база
+	testDBCount++
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:test%d?mode=memory&cache=shared&_foreign_keys=on", testDBCount))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := dbMigrate(ctx, db, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := installTriggers(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	return &sqliteStore{db: db}
+}
+
+// Запускает test для каждой реализации Store
+func forEachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("sqlite", func(t *testing.T) { test(t, openTestStore(t)) })
+	t.Run("memory", func(t *testing.T) { test(t, newMemStore()) })
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustFail(t *testing.T, err error, what string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("%s: ожидалась ошибка", what)
+	}
+}
+
+func mustFailWith(t *testing.T, err error, message string) {
+	t.Helper()
+	if err == nil || !strings.Contains(err.Error(), message) {
+		t.Fatalf("ожидалась ошибка %q, получено %v", message, err)
+	}
+}
+
+var (
+	testPast = time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	testBirthday = time.Date(1995, 3, 15, 0, 0, 0, 0, time.UTC)
+)
+
+// Общие справочники: страны RUS и USA, индивидуальный GYM и командный
+// FBL с дисциплинами 1 и 2 без ограничений, места 1 и 2, спортсмены 1
+// и 2 из RUS и 3 из USA, команда 1 (RUS, FBL) из спортсменов 1 и 2
+func seed(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+
+	must(t, s.addCountry(ctx, "RUS", "Россия"))
+	must(t, s.addCountry(ctx, "USA", "США"))
+	must(t, s.addSport(ctx, Sport{Code: "GYM", Name: "Гимнастика"}))
+	must(t, s.addSport(ctx, Sport{Code: "FBL", Name: "Футбол", IsTeam: true}))
+	must(t, s.addEvent(ctx, Event{SportCode: "GYM", Name: "Многоборье"}))
+	must(t, s.addEvent(ctx, Event{SportCode: "FBL", Name: "Турнир"}))
+	must(t, s.addSite(ctx, "Гимнастический зал"))
+	must(t, s.addSite(ctx, "Футбольный стадион"))
+	must(t, s.addAthlete(ctx, "Иван Петров", true, testBirthday, "RUS"))
+	must(t, s.addAthlete(ctx, "Мария Сидорова", false, testBirthday, "RUS"))
+	must(t, s.addAthlete(ctx, "Майкл Джонсон", true, testBirthday, "USA"))
+	must(t, s.addTeam(ctx, "Сборная России по футболу", "RUS", "FBL"))
+	must(t, s.addAthleteToTeam(ctx, 1, 1))
+	must(t, s.addAthleteToTeam(ctx, 1, 2))
+}
+
+func TestCountries(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+
+		mustFail(t, s.addCountry(ctx, "RUS", "Другая"), "повтор кода")
+		mustFail(t, s.addCountry(ctx, "XXX", "Россия"), "повтор названия")
+		mustFail(t, s.addCountry(ctx, "", "Пусто"), "пустой код")
+
+		must(t, s.updateCountry(ctx, "RUS", "RU", "Россия"))
+		countries, err := s.getCountries(ctx)
+		must(t, err)
+		if len(countries) != 2 || !slices.Contains(countries, Country{"RU", "Россия"}) {
+			t.Fatalf("страны после изменения: %v", countries)
+		}
+		// Код страны в спортсменах и командах меняется вместе с ней
+		athletes, err := s.getAthletes(ctx)
+		must(t, err)
+		if athletes[0].CountryCode != "RU" {
+			t.Errorf("код страны спортсмена: %q", athletes[0].CountryCode)
+		}
+
+		var dependents *DependentsError
+		if err := s.deleteCountry(ctx, "RU"); !errors.As(err, &dependents) {
+			t.Fatalf("удаление страны со спортсменами: %v", err)
+		}
+		if len(dependents.Dependents) != 3 {
+			t.Errorf("зависимые записи: %v", dependents.Dependents)
+		}
+
+		must(t, s.addCountry(ctx, "CHN", "Китай"))
+		must(t, s.deleteCountry(ctx, "CHN"))
+		countries, err = s.getCountries(ctx)
+		must(t, err)
+		if len(countries) != 2 {
+			t.Errorf("страны после удаления: %v", countries)
+		}
+	})
+}
+
+func TestSports(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+
+		mustFail(t, s.addSport(ctx, Sport{Code: "GYM", Name: "Другой"}), "повтор кода")
+		mustFail(t, s.addSport(ctx, Sport{Code: "XXX", Name: "Футбол", IsTeam: true}), "повтор названия")
+		mustFail(t, s.addSport(ctx, Sport{Code: "XXX", Name: "Другой", MinPlayers: 5, MaxPlayers: 3}), "максимум меньше минимума")
+		mustFail(t, s.addSport(ctx, Sport{Code: "XXX", Name: "Другой", TeamGender: "X"}), "неизвестный пол")
+
+		must(t, s.updateSport(ctx, "FBL", Sport{Code: "SOC", Name: "Футбол", IsTeam: true}))
+		teams, err := s.getTeams(ctx, true)
+		must(t, err)
+		if teams[0].Sport.Code != "SOC" {
+			t.Errorf("код спорта команды: %q", teams[0].Sport.Code)
+		}
+
+		var dependents *DependentsError
+		if err := s.deleteSport(ctx, "SOC"); !errors.As(err, &dependents) {
+			t.Fatalf("удаление спорта с командой: %v", err)
+		}
+		must(t, s.deleteSport(ctx, "GYM"))
+
+		sports, err := s.getSports(ctx)
+		must(t, err)
+		if len(sports) != 1 || sports[0] != (Sport{Code: "SOC", Name: "Футбол", IsTeam: true}) {
+			t.Errorf("виды спорта: %v", sports)
+		}
+	})
+}
+
+func TestAthletes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+
+		mustFail(t, s.addAthlete(ctx, "Никто", true, testBirthday, "XXX"), "несуществующая страна")
+		mustFail(t, s.addAthlete(ctx, "", true, testBirthday, "RUS"), "пустое имя")
+
+		athletes, err := s.getAthletes(ctx)
+		must(t, err)
+		if len(athletes) != 3 {
+			t.Fatalf("спортсмены: %v", athletes)
+		}
+		a := athletes[1]
+		if a.ID != 2 || a.Name != "Мария Сидорова" || a.Gender != "F" ||
+			!a.Birthday.Equal(testBirthday) || a.CountryName != "Россия" {
+			t.Errorf("спортсмен 2: %+v", a)
+		}
+
+		// Спортсмен из команды RUS не может сменить страну
+		mustFailWith(t, s.updateAthlete(ctx, 1, "Иван Петров", true, testBirthday, "USA"),
+			"Спортсмен не соответствует команде по стране")
+		must(t, s.updateAthlete(ctx, 3, "Майкл Джонсон-мл.", true, testBirthday, "USA"))
+
+		p, err := s.getAthleteProfile(ctx, 1)
+		must(t, err)
+		if len(p.Teams) != 1 || p.Teams[0].Name != "Сборная России по футболу" {
+			t.Errorf("команды спортсмена: %v", p.Teams)
+		}
+		_, err = s.getAthleteProfile(ctx, 100)
+		mustFail(t, err, "профиль несуществующего спортсмена")
+
+		// Удаление спортсмена убирает его и из команды
+		must(t, s.deleteAthlete(ctx, 1))
+		teams, err := s.getTeams(ctx, true)
+		must(t, err)
+		if len(teams[0].Members) != 1 || teams[0].Members[0].ID != 2 {
+			t.Errorf("состав команды: %v", teams[0].Members)
+		}
+	})
+}
+
+func TestSites(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+
+		mustFail(t, s.addSite(ctx, "Футбольный стадион"), "повтор названия")
+		must(t, s.updateSite(ctx, 2, "Стадион"))
+		must(t, s.addCompetition(ctx, testPast, 120, StatusFinished, 1, 1))
+
+		var dependents *DependentsError
+		if err := s.deleteSite(ctx, 1); !errors.As(err, &dependents) {
+			t.Fatalf("удаление места с соревнованием: %v", err)
+		}
+		must(t, s.deleteSite(ctx, 2))
+
+		sites, err := s.getSites(ctx)
+		must(t, err)
+		if len(sites) != 1 || sites[0] != (Site{1, "Гимнастический зал"}) {
+			t.Errorf("места: %v", sites)
+		}
+	})
+}
+
+func TestTeams(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+
+		mustFail(t, s.addTeam(ctx, "Сборная", "RUS", "XXX"), "несуществующий спорт")
+		mustFailWith(t, s.addAthleteToTeam(ctx, 1, 3), "Спортсмен не соответствует команде по стране")
+		mustFail(t, s.addAthleteToTeam(ctx, 1, 1), "повторное добавление")
+		mustFailWith(t, s.updateTeam(ctx, 1, "Сборная", "USA", "FBL"), "Спортсмен не соответствует команде по стране")
+
+		teams, err := s.getTeams(ctx, true)
+		must(t, err)
+		if len(teams) != 1 {
+			t.Fatalf("команды: %v", teams)
+		}
+		team := teams[0]
+		if team.Country.Name != "Россия" || !team.Sport.IsTeam || len(team.Members) != 2 ||
+			team.Members[0].ID != 1 || team.Members[1].ID != 2 {
+			t.Errorf("команда: %+v", team)
+		}
+
+		// Без составов приходит только их размер
+		must(t, s.addTeam(ctx, "Сборная США по футболу", "USA", "FBL"))
+		teams, err = s.getTeams(ctx, false)
+		must(t, err)
+		if len(teams) != 2 || teams[0].Members != nil || teams[0].MemberCount != 2 || teams[1].MemberCount != 0 {
+			t.Errorf("команды без составов: %+v", teams)
+		}
+		members, err := s.getTeamMembers(ctx, 1)
+		must(t, err)
+		if len(members) != 2 || members[0].Name != "Иван Петров" || members[1].CountryName != "Россия" {
+			t.Errorf("состав команды 1: %+v", members)
+		}
+		members, err = s.getTeamMembers(ctx, 2)
+		must(t, err)
+		if len(members) != 0 {
+			t.Errorf("состав команды 2: %+v", members)
+		}
+		must(t, s.deleteTeam(ctx, 2))
+
+		must(t, s.deleteAthleteFromTeam(ctx, 1, 1))
+		must(t, s.deleteAthleteFromTeam(ctx, 1, 2))
+		must(t, s.updateTeam(ctx, 1, "Сборная США по футболу", "USA", "FBL"))
+		must(t, s.addAthleteToTeam(ctx, 1, 3))
+		must(t, s.deleteTeam(ctx, 1))
+
+		teams, err = s.getTeams(ctx, true)
+		must(t, err)
+		if len(teams) != 0 {
+			t.Errorf("команды после удаления: %v", teams)
+		}
+	})
+}
+
+func TestTeamRules(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+
+		mustFailWith(t, s.addTeam(ctx, "Сборная по гимнастике", "RUS", "GYM"), "Индивидуальный вид спорта")
+		mustFailWith(t, s.updateTeam(ctx, 1, "Сборная", "RUS", "GYM"), "Индивидуальный вид спорта")
+		mustFailWith(t, s.updateSport(ctx, "FBL", Sport{Code: "FBL", Name: "Футбол"}), "У вида спорта есть команды")
+
+		// В команде 1 мужчина и женщина, поэтому сделать её мужской или
+		// уменьшить максимум ниже двух нельзя
+		fbl := Sport{Code: "FBL", Name: "Футбол", IsTeam: true, MaxPlayers: 1}
+		mustFailWith(t, s.updateSport(ctx, "FBL", fbl), "больше игроков, чем новый максимум")
+		fbl.MaxPlayers, fbl.TeamGender = 0, "M"
+		mustFailWith(t, s.updateSport(ctx, "FBL", fbl), "спортсмены другого пола")
+
+		must(t, s.addSport(ctx, Sport{Code: "HOC", Name: "Хоккей", IsTeam: true, MinPlayers: 2, MaxPlayers: 2, TeamGender: "M"}))
+		must(t, s.addAthlete(ctx, "Пётр Иванов", true, testBirthday, "RUS"))
+		must(t, s.addTeam(ctx, "Сборная России по хоккею", "RUS", "HOC"))
+		mustFailWith(t, s.addAthleteToTeam(ctx, 2, 2), "Спортсмен не подходит команде по полу")
+		must(t, s.addAthleteToTeam(ctx, 2, 1))
+		mustFailWith(t, s.updateAthlete(ctx, 1, "Иван Петров", false, testBirthday, "RUS"), "Спортсмен не подходит команде по полу")
+		must(t, s.addAthleteToTeam(ctx, 2, 4))
+		must(t, s.addAthlete(ctx, "Олег Смирнов", true, testBirthday, "RUS"))
+		mustFailWith(t, s.addAthleteToTeam(ctx, 2, 5), "Состав команды уже полный")
+		// Команду 1 с женщиной в составе нельзя перевести в мужской хоккей
+		mustFailWith(t, s.updateTeam(ctx, 1, "Сборная", "RUS", "HOC"), "Спортсмен не подходит команде по полу")
+
+		teams, err := s.getTeams(ctx, false)
+		must(t, err)
+		if len(teams) != 2 || teams[0].rosterProblem() != "" || teams[1].rosterProblem() != "" || teams[1].Sport.MaxPlayers != 2 || teams[1].Sport.TeamGender != "M" {
+			t.Errorf("команды: %+v", teams)
+		}
+
+		// Изменение, не затрагивающее правила, проходит и при
+		// неподходящем составе
+		must(t, s.deleteAthleteFromTeam(ctx, 2, 4))
+		if teams, err = s.getTeams(ctx, false); err != nil || teams[1].rosterProblem() != "не хватает игроков: 1 из 2" {
+			t.Errorf("неполная команда: %+v, %v", teams, err)
+		}
+		must(t, s.updateSport(ctx, "HOC", Sport{Code: "HOC", Name: "Хоккей на льду", IsTeam: true, MinPlayers: 2, MaxPlayers: 2, TeamGender: "M"}))
+	})
+}
+
+func TestCompetitions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+
+		must(t, s.addCompetition(ctx, testPast, 120, StatusFinished, 1, 1))
+		// Место занято с 10:00 до 12:00
+		mustFailWith(t, s.addCompetition(ctx, testPast.Add(time.Hour), 60, StatusFinished, 1, 1),
+			"Место уже занято другим соревнованием в это время")
+		must(t, s.addCompetition(ctx, testPast.Add(time.Hour), 60, StatusCancelled, 1, 1))
+		must(t, s.addCompetition(ctx, testPast.Add(2*time.Hour), 60, StatusFinished, 1, 1))
+		must(t, s.addCompetition(ctx, testPast.Add(-2*time.Hour), 60, StatusFinished, 2, 2))
+
+		mustFail(t, s.addCompetition(ctx, time.Now().AddDate(1, 0, 0), 60, StatusFinished, 1, 2), "завершено в будущем")
+		mustFail(t, s.addCompetition(ctx, testPast, 0, StatusFinished, 1, 2), "нулевая длительность")
+		mustFail(t, s.addCompetition(ctx, testPast, 60, StatusFinished, 100, 2), "несуществующая дисциплина")
+
+		competitions, err := s.getCompetitions(ctx)
+		must(t, err)
+		var ids []int
+		for _, c := range competitions {
+			ids = append(ids, c.ID)
+		}
+		if !slices.Equal(ids, []int{4, 1, 2, 3}) {
+			t.Errorf("порядок соревнований: %v", ids)
+		}
+
+		c, err := s.getCompetition(ctx, 1)
+		must(t, err)
+		if !c.Time.Equal(testPast) || c.Duration != 120 || c.Sport.Code != "GYM" || c.Site.ID != 1 {
+			t.Errorf("соревнование 1: %+v", c)
+		}
+		_, err = s.getCompetition(ctx, 100)
+		mustFail(t, err, "несуществующее соревнование")
+
+		// Отменённое соревнование нельзя вернуть поверх первого
+		mustFailWith(t, s.updateCompetition(ctx, 2, testPast.Add(time.Hour), 60, StatusFinished, 1, 1, StageFinal, 0, 0),
+			"Место уже занято другим соревнованием в это время")
+		// А само себе соревнование не мешает
+		must(t, s.updateCompetition(ctx, 1, testPast, 90, StatusFinished, 1, 1, StageFinal, 0, 0))
+
+		conflicts, err := s.getSiteConflicts(ctx)
+		must(t, err)
+		if len(conflicts) != 0 {
+			t.Errorf("пересечения на местах: %v", conflicts)
+		}
+
+		// Свободно с 8:00 до 10:00, потом с 12:00 (конец третьего в 13:00)
+		day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+		slot, err := s.findFreeSlot(ctx, 1, day, 120)
+		must(t, err)
+		if !slot.Equal(day.Add(8 * time.Hour)) {
+			t.Errorf("свободное окно на 2 часа: %v", slot)
+		}
+		slot, err = s.findFreeSlot(ctx, 1, day, 180)
+		must(t, err)
+		if !slot.Equal(day.Add(13 * time.Hour)) {
+			t.Errorf("свободное окно на 3 часа: %v", slot)
+		}
+		_, err = s.findFreeSlot(ctx, 1, day, 15*60)
+		mustFail(t, err, "окно длиннее рабочего дня")
+
+		must(t, s.deleteCompetition(ctx, 2))
+		competitions, err = s.getCompetitions(ctx)
+		must(t, err)
+		if len(competitions) != 3 {
+			t.Errorf("соревнования после удаления: %v", competitions)
+		}
+	})
+}
+
+func TestResults(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+
+		must(t, s.addCompetition(ctx, testPast, 120, StatusFinished, 1, 1))
+		must(t, s.addCompetition(ctx, testPast, 120, StatusFinished, 2, 2))
+		must(t, s.addCompetition(ctx, testPast.AddDate(0, 0, 1), 120, StatusScheduled, 1, 1))
+
+		mustFailWith(t, s.addResult(ctx, 1, true, 1, 1), "Индивидуальный спорт — нельзя добавлять команду")
+		mustFailWith(t, s.addResult(ctx, 2, false, 3, 1), "Командный спорт — нельзя добавлять одиночного атлета")
+		mustFailWith(t, s.addResult(ctx, 3, false, 3, 1), "Соревнование не завершено — места ставятся только после завершения")
+		mustFailWith(t, s.addResult(ctx, 1, false, 3, 0), "Соревнование завершено — укажите место участника")
+		mustFail(t, s.addResult(ctx, 1, false, 3, -1), "отрицательное место")
+		mustFail(t, s.addResult(ctx, 1, false, 100, 1), "несуществующий спортсмен")
+
+		must(t, s.addResult(ctx, 1, false, 3, 2))
+		must(t, s.addResult(ctx, 1, false, 1, 1))
+		mustFail(t, s.addResult(ctx, 1, false, 1, 3), "повторный результат")
+
+		// Спортсмен 1 уже выступает в гимнастике в это же время
+		var conflicts *AthleteConflictsError
+		if err := s.addResult(ctx, 2, true, 1, 1); !errors.As(err, &conflicts) {
+			t.Fatalf("результат команды с занятым спортсменом: %v", err)
+		}
+		if len(conflicts.Conflicts) != 1 || conflicts.Conflicts[0].First.ID != 2 ||
+			conflicts.Conflicts[0].Second.ID != 1 || conflicts.Conflicts[0].AthleteID != 1 {
+			t.Errorf("пересечения: %+v", conflicts.Conflicts)
+		}
+
+		results, err := s.getCompetitionResults(ctx, 1)
+		must(t, err)
+		if len(results) != 2 || results[0].ParticipantID != 1 || results[1].ParticipantID != 3 ||
+			results[1].CountryName != "США" {
+			t.Fatalf("результаты: %+v", results)
+		}
+
+		// Со статусом результатов соревнование уже не может быть незавершённым
+		mustFailWith(t, s.updateCompetition(ctx, 1, testPast, 120, StatusCancelled, 1, 1, StageFinal, 0, 0),
+			"У соревнования есть результаты — сначала удалите их")
+
+		var dependents *DependentsError
+		if err := s.deleteAthlete(ctx, 3); !errors.As(err, &dependents) {
+			t.Fatalf("удаление спортсмена с результатом: %v", err)
+		}
+
+		must(t, s.setResultPlace(ctx, results[1], 3))
+		mustFailWith(t, s.setResultPlace(ctx, results[1], 0), "Соревнование завершено — укажите место участника")
+		must(t, s.deleteResult(ctx, results[0]))
+		results, err = s.getCompetitionResults(ctx, 1)
+		must(t, err)
+		if len(results) != 1 || results[0].Place != 3 {
+			t.Errorf("результаты после изменений: %+v", results)
+		}
+
+		// Стартовый лист: до завершения участники записываются без места,
+		// и пересечения по времени проверяются уже при записи
+		must(t, s.addResult(ctx, 3, false, 3, 0))
+		must(t, s.addCompetition(ctx, testPast.AddDate(0, 0, 1).Add(time.Hour), 60, StatusScheduled, 1, 2))
+		if err := s.addResult(ctx, 4, false, 3, 0); !errors.As(err, &conflicts) {
+			t.Fatalf("запись спортсмена в пересекающееся соревнование: %v", err)
+		}
+		startList, err := s.getCompetitionResults(ctx, 3)
+		must(t, err)
+		if len(startList) != 1 || startList[0].Place != 0 {
+			t.Fatalf("стартовый лист: %+v", startList)
+		}
+		mustFailWith(t, s.setResultPlace(ctx, startList[0], 1), "Соревнование не завершено — места ставятся только после завершения")
+		must(t, s.updateCompetition(ctx, 3, testPast.AddDate(0, 0, 1), 120, StatusInProgress, 1, 1, StageFinal, 0, 0))
+		mustFailWith(t, s.setResultPlace(ctx, startList[0], 1), "Соревнование не завершено — места ставятся только после завершения")
+		// Места расставляют после завершения, стартовый лист ему не мешает
+		must(t, s.updateCompetition(ctx, 3, testPast.AddDate(0, 0, 1), 120, StatusFinished, 1, 1, StageFinal, 0, 0))
+		mustFailWith(t, s.addResult(ctx, 3, false, 2, 0), "Соревнование завершено — укажите место участника")
+		must(t, s.setResultPlace(ctx, startList[0], 1))
+		mustFailWith(t, s.setResultPlace(ctx, startList[0], 0), "Соревнование завершено — укажите место участника")
+		mustFailWith(t, s.updateCompetition(ctx, 3, testPast.AddDate(0, 0, 1), 120, StatusInProgress, 1, 1, StageFinal, 0, 0),
+			"У соревнования есть результаты — сначала удалите их")
+
+		// В отменённое соревнование не записывают, но стартовый лист при
+		// отмене остаётся
+		must(t, s.addResult(ctx, 4, false, 2, 0))
+		must(t, s.updateCompetition(ctx, 4, testPast.AddDate(0, 0, 1).Add(time.Hour), 60, StatusCancelled, 1, 2, StageFinal, 0, 0))
+		mustFailWith(t, s.addResult(ctx, 4, false, 1, 0), "Соревнование отменено — записывать участников нельзя")
+		if startList, err = s.getCompetitionResults(ctx, 4); err != nil || len(startList) != 1 {
+			t.Errorf("стартовый лист отменённого соревнования: %+v, %v", startList, err)
+		}
+
+		// Удаление соревнования удаляет и результаты
+		must(t, s.deleteCompetition(ctx, 1))
+		must(t, s.deleteCompetition(ctx, 3))
+		must(t, s.deleteAthlete(ctx, 3))
+	})
+}
+
+func TestAthleteConflicts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+
+		must(t, s.addCompetition(ctx, testPast.AddDate(0, 0, 1), 120, StatusFinished, 1, 1))
+		must(t, s.addCompetition(ctx, testPast.Add(time.Hour), 120, StatusFinished, 2, 2))
+		must(t, s.addResult(ctx, 1, false, 2, 1))
+		must(t, s.addResult(ctx, 1, false, 3, 2))
+		must(t, s.addResult(ctx, 2, true, 1, 1))
+
+		conflicts, err := s.getAthleteConflicts(ctx)
+		must(t, err)
+		if len(conflicts) != 0 {
+			t.Fatalf("пересечения в разные дни: %+v", conflicts)
+		}
+
+		// Перенос уже проведённого соревнования пересечения не проверяет,
+		// так они и попадают в данные
+		must(t, s.updateCompetition(ctx, 1, testPast, 120, StatusFinished, 1, 1, StageFinal, 0, 0))
+		conflicts, err = s.getAthleteConflicts(ctx)
+		must(t, err)
+		if len(conflicts) != 1 || conflicts[0].AthleteID != 2 ||
+			conflicts[0].First.ID != 1 || conflicts[0].Second.ID != 2 {
+			t.Errorf("пересечения: %+v", conflicts)
+		}
+	})
+}
+
+func TestCountryMedals(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+		must(t, s.addCountry(ctx, "CHN", "Китай"))
+
+		must(t, s.addCompetition(ctx, testPast, 60, StatusFinished, 1, 1))
+		must(t, s.addCompetition(ctx, testPast.AddDate(0, 0, 1), 60, StatusFinished, 1, 1))
+		must(t, s.addCompetition(ctx, testPast.AddDate(0, 0, 2), 60, StatusFinished, 2, 2))
+		must(t, s.addResult(ctx, 1, false, 3, 1))
+		must(t, s.addResult(ctx, 1, false, 1, 2))
+		must(t, s.addResult(ctx, 2, false, 2, 1))
+		must(t, s.addResult(ctx, 2, false, 3, 4))
+		must(t, s.addResult(ctx, 3, true, 1, 3))
+
+		medals, err := s.getCountryMedals(ctx, false)
+		must(t, err)
+		want := []CountryMedals{
+			{"Россия", 1, 1, 1, 3},
+			{"США", 1, 0, 0, 1},
+		}
+		if !slices.Equal(medals, want) {
+			t.Errorf("медали: %v, ожидалось %v", medals, want)
+		}
+
+		medals, err = s.getCountryMedals(ctx, true)
+		must(t, err)
+		if len(medals) != 3 || medals[2] != (CountryMedals{"Китай", 0, 0, 0, 0}) {
+			t.Errorf("медали со странами без медалей: %v", medals)
+		}
+	})
+}
+
+func TestEvents(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+
+		mustFail(t, s.addEvent(ctx, Event{SportCode: "GYM", Name: "Многоборье"}), "повтор названия")
+		mustFail(t, s.addEvent(ctx, Event{SportCode: "XXX", Name: "Другая"}), "несуществующий спорт")
+		mustFail(t, s.addEvent(ctx, Event{SportCode: "GYM", Name: "Другая", MinAge: 30, MaxAge: 20}), "максимум меньше минимума")
+		mustFail(t, s.addEvent(ctx, Event{SportCode: "GYM", Name: "Другая", Gender: "X"}), "неизвестный пол")
+
+		// Спортсменам 28 лет на момент testPast
+		must(t, s.addEvent(ctx, Event{SportCode: "GYM", Name: "Вольные упражнения, женщины", Gender: "F"}))
+		must(t, s.addEvent(ctx, Event{SportCode: "GYM", Name: "Юниоры", MaxAge: 18}))
+		must(t, s.addEvent(ctx, Event{SportCode: "FBL", Name: "Мужской турнир", Gender: "M"}))
+		must(t, s.addCompetition(ctx, testPast, 60, StatusFinished, 3, 1))
+		must(t, s.addCompetition(ctx, testPast.AddDate(0, 0, 1), 60, StatusFinished, 4, 1))
+		must(t, s.addCompetition(ctx, testPast, 60, StatusFinished, 5, 2))
+
+		mustFailWith(t, s.addResult(ctx, 1, false, 1, 1), "Спортсмен не подходит дисциплине по полу")
+		mustFailWith(t, s.addResult(ctx, 2, false, 2, 1), "Спортсмен не подходит дисциплине по возрасту")
+		mustFailWith(t, s.addResult(ctx, 3, true, 1, 1), "В команде есть спортсмены, не подходящие дисциплине по полу")
+		must(t, s.addResult(ctx, 1, false, 2, 1))
+
+		// Название меняется и после результатов, условия участия — нет
+		must(t, s.updateEvent(ctx, Event{ID: 3, SportCode: "GYM", Name: "Вольные упражнения", Gender: "F"}))
+		mustFailWith(t, s.updateEvent(ctx, Event{ID: 3, SportCode: "GYM", Name: "Вольные упражнения"}),
+			"По дисциплине уже есть результаты — условия участия менять нельзя")
+		mustFailWith(t, s.updateCompetition(ctx, 1, testPast, 60, StatusFinished, 1, 1, StageFinal, 0, 0),
+			"У соревнования есть результаты — сначала удалите их")
+		must(t, s.updateEvent(ctx, Event{ID: 4, SportCode: "GYM", Name: "Юниоры", MaxAge: 30}))
+		must(t, s.addResult(ctx, 2, false, 1, 1))
+
+		c, err := s.getCompetition(ctx, 1)
+		must(t, err)
+		if c.Event.ID != 3 || c.Sport.Code != "GYM" || c.title() != "Гимнастика: Вольные упражнения" {
+			t.Errorf("соревнование 1: %+v", c)
+		}
+
+		var dependents *DependentsError
+		if err := s.deleteEvent(ctx, 3); !errors.As(err, &dependents) {
+			t.Fatalf("удаление дисциплины с соревнованием: %v", err)
+		}
+		must(t, s.deleteCompetition(ctx, 3))
+		must(t, s.deleteEvent(ctx, 5))
+
+		events, err := s.getEvents(ctx)
+		must(t, err)
+		var names []string
+		for _, e := range events {
+			names = append(names, e.Name)
+		}
+		if !slices.Equal(names, []string{"Турнир", "Вольные упражнения", "Многоборье", "Юниоры"}) {
+			t.Errorf("дисциплины: %v", names)
+		}
+
+		medals, err := s.getEventMedals(ctx)
+		must(t, err)
+		if len(medals) != 2 || medals[0].Event.Name != "Вольные упражнения" || medals[0].SportName != "Гимнастика" ||
+			medals[0].ParticipantName != "Мария Сидорова" || medals[1].Event.Name != "Юниоры" || medals[1].CountryName != "Россия" {
+			t.Errorf("медали по дисциплинам: %+v", medals)
+		}
+	})
+}
+
+// Записанный участник не может перестать подходить дисциплине ни из-за
+// правки спортсмена, ни из-за нового члена команды, ни из-за переноса
+// соревнования
+func TestEventEligibilityChanges(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+
+		// Спортсменам 28 лет на момент testPast
+		must(t, s.addEvent(ctx, Event{SportCode: "GYM", Name: "Вольные упражнения, женщины", Gender: "F"}))
+		must(t, s.addEvent(ctx, Event{SportCode: "GYM", Name: "25-30 лет", MinAge: 25, MaxAge: 30}))
+		must(t, s.addEvent(ctx, Event{SportCode: "FBL", Name: "Мужской турнир", Gender: "M"}))
+		must(t, s.addCompetition(ctx, testPast, 60, StatusFinished, 3, 1))
+		must(t, s.addCompetition(ctx, testPast.AddDate(0, 0, 1), 60, StatusFinished, 4, 1))
+		must(t, s.addCompetition(ctx, testPast.AddDate(0, 0, 2), 60, StatusFinished, 5, 2))
+		must(t, s.addTeam(ctx, "Вторая сборная России", "RUS", "FBL"))
+		must(t, s.addAthleteToTeam(ctx, 2, 1))
+		must(t, s.addResult(ctx, 1, false, 2, 1))
+		must(t, s.addResult(ctx, 2, false, 3, 1))
+		must(t, s.addResult(ctx, 3, true, 2, 1))
+
+		mustFailWith(t, s.updateAthlete(ctx, 2, "Мария Сидорова", true, testBirthday, "RUS"),
+			"Спортсмен записан в соревнования, дисциплине которых он не подойдёт по полу")
+		mustFailWith(t, s.updateAthlete(ctx, 3, "Майкл Джонсон", true, testBirthday.AddDate(-20, 0, 0), "USA"),
+			"Спортсмен записан в соревнования, дисциплине которых он не подойдёт по возрасту")
+		mustFailWith(t, s.updateAthlete(ctx, 1, "Иван Петров", false, testBirthday, "RUS"),
+			"Спортсмен записан в соревнования, дисциплине которых он не подойдёт по полу")
+		must(t, s.updateAthlete(ctx, 3, "Майкл Джонсон-младший", true, testBirthday.AddDate(0, -1, 0), "USA"))
+
+		mustFailWith(t, s.addAthleteToTeam(ctx, 2, 2),
+			"Команда записана в соревнования, дисциплине которых спортсмен не подходит по полу")
+
+		mustFailWith(t, s.updateCompetition(ctx, 2, testPast.AddDate(-5, 0, 1), 60, StatusFinished, 4, 1, StageFinal, 0, 0),
+			"Участники соревнования не подойдут дисциплине по возрасту на новую дату")
+		must(t, s.updateCompetition(ctx, 2, testPast.AddDate(0, 0, 3), 60, StatusFinished, 4, 1, StageFinal, 0, 0))
+
+		athletes, err := s.getAthletes(ctx)
+		must(t, err)
+		if i := slices.IndexFunc(athletes, func(a Athlete) bool { return a.ID == 2 }); athletes[i].Gender != "F" {
+			t.Errorf("спортсмен 2 после отклонённого изменения: %+v", athletes[i])
+		}
+	})
+}
+
+// Возраст считается в UTC и не зависит от часового пояса машины: за
+// два часа до полуночи UTC перед днём рождения спортсмену ещё 17, хотя
+// в Нью-Йорке день рождения уже наступил бы по местному времени
+func TestEventAgeTimeZone(t *testing.T) {
+	t.Setenv("TZ", "EST5")
+	local := time.Local
+	time.Local = time.FixedZone("EST", -5*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+
+		must(t, s.addAthlete(ctx, "Анна Юниорова", false, time.Date(2006, 3, 15, 0, 0, 0, 0, time.UTC), "RUS"))
+		must(t, s.addEvent(ctx, Event{SportCode: "GYM", Name: "Юниоры", MaxAge: 17}))
+		must(t, s.addCompetition(ctx, time.Date(2024, 3, 14, 22, 0, 0, 0, time.UTC), 60, StatusFinished, 3, 1))
+		must(t, s.addResult(ctx, 1, false, 4, 1))
+
+		athletes, err := s.getAthletes(ctx)
+		must(t, err)
+		i := slices.IndexFunc(athletes, func(a Athlete) bool { return a.ID == 4 })
+		if age := ageAt(athletes[i].Birthday, time.Date(2024, 3, 14, 22, 0, 0, 0, time.UTC)); age != 17 {
+			t.Errorf("возраст: %d, ожидалось 17", age)
+		}
+	})
+}
+
+func TestStages(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		seed(t, s)
+
+		// Два забега гимнастики 1 и 2, финал 3 и футбол 4
+		must(t, s.addCompetition(ctx, testPast, 60, StatusFinished, 1, 1))
+		must(t, s.addCompetition(ctx, testPast.Add(time.Hour), 60, StatusFinished, 1, 2))
+		must(t, s.addCompetition(ctx, testPast.AddDate(0, 0, 1), 60, StatusInProgress, 1, 1))
+		must(t, s.addCompetition(ctx, testPast.AddDate(0, 0, 2), 60, StatusFinished, 2, 2))
+
+		mustFailWith(t, s.setCompetitionStage(ctx, 3, StageFinal, 1, 0), "Из финала некуда проходить дальше")
+		mustFailWith(t, s.setCompetitionStage(ctx, 1, StageHeat, 4, 1), "Следующий этап относится к другой дисциплине")
+		must(t, s.setCompetitionStage(ctx, 1, StageHeat, 3, 1))
+		must(t, s.setCompetitionStage(ctx, 2, StageHeat, 3, 1))
+		mustFailWith(t, s.setCompetitionStage(ctx, 2, StageHeat, 1, 0), "Следующий этап должен быть дальше по сетке")
+		mustFailWith(t, s.setCompetitionStage(ctx, 3, StageHeat, 0, 0), "Этап не сходится с предыдущими этапами сетки")
+
+		must(t, s.addResult(ctx, 1, false, 1, 1))
+		must(t, s.addResult(ctx, 1, false, 3, 2))
+		must(t, s.addResult(ctx, 2, false, 2, 1))
+
+		qualifiers, err := s.getStageQualifiers(ctx, 3)
+		must(t, err)
+		if len(qualifiers) != 2 || qualifiers[0].ParticipantID != 1 || qualifiers[0].CompetitionID != 1 ||
+			qualifiers[1].ParticipantID != 2 || qualifiers[1].CompetitionID != 2 {
+			t.Fatalf("прошедшие отбор: %+v", qualifiers)
+		}
+
+		mustFailWith(t, s.addResult(ctx, 3, false, 3, 0), "Участник не прошёл отбор на этот этап")
+		// Прошедшие отбор записываются в финал без места, повторно — никто
+		must(t, s.addStageQualifiers(ctx, 3))
+		must(t, s.addStageQualifiers(ctx, 3))
+		final, err := s.getCompetitionResults(ctx, 3)
+		must(t, err)
+		if len(final) != 2 || final[0].Place != 0 || final[1].Place != 0 {
+			t.Fatalf("финал после записи прошедших отбор: %+v", final)
+		}
+		must(t, s.updateCompetition(ctx, 3, testPast.AddDate(0, 0, 1), 60, StatusFinished, 1, 1, StageFinal, 0, 0))
+		for _, r := range final {
+			must(t, s.setResultPlace(ctx, r, 3-r.ParticipantID))
+		}
+
+		mustFailWith(t, s.setCompetitionStage(ctx, 1, StageHeat, 3, 2), "Следующий этап уже проведён — условия прохода менять нельзя")
+		// Соревнование и его этап меняются вместе или не меняются вовсе
+		mustFailWith(t, s.updateCompetition(ctx, 1, testPast, 90, StatusFinished, 1, 1, StageHeat, 3, 2),
+			"Следующий этап уже проведён — условия прохода менять нельзя")
+		if heat, err := s.getCompetition(ctx, 1); err != nil || heat.Duration != 60 || heat.AdvanceCount != 1 {
+			t.Errorf("забег 1 после отклонённого изменения: %+v, %v", heat, err)
+		}
+		mustFailWith(t, s.setCompetitionStage(ctx, 3, StageSemifinal, 0, 0), "У соревнования есть результаты — сначала удалите их")
+
+		// Результаты забега, по которым уже набран финал, не меняются
+		heatResults, err := s.getCompetitionResults(ctx, 1)
+		must(t, err)
+		mustFailWith(t, s.setResultPlace(ctx, heatResults[0], 2), "На следующий этап уже записаны участники — результаты этапа менять нельзя")
+		mustFailWith(t, s.deleteResult(ctx, heatResults[0]), "На следующий этап уже записаны участники — результаты этапа менять нельзя")
+		// Места у забега есть, так что раньше срабатывает общее правило
+		mustFailWith(t, s.updateCompetition(ctx, 1, testPast, 60, StatusInProgress, 1, 1, StageHeat, 3, 1),
+			"У соревнования есть результаты — сначала удалите их")
+		must(t, s.deleteResult(ctx, Result{CompetitionID: 1, ParticipantID: 100}))
+
+		// Победы в забегах медалей не дают
+		medals, err := s.getCountryMedals(ctx, false)
+		must(t, err)
+		if want := []CountryMedals{{"Россия", 1, 1, 0, 2}}; !slices.Equal(medals, want) {
+			t.Errorf("медали: %v, ожидалось %v", medals, want)
+		}
+		eventMedals, err := s.getEventMedals(ctx)
+		must(t, err)
+		if len(eventMedals) != 2 {
+			t.Errorf("медали по дисциплинам: %+v", eventMedals)
+		}
+		profile, err := s.getAthleteProfile(ctx, 1)
+		must(t, err)
+		if profile.Gold != 0 || profile.Silver != 1 || len(profile.Competitions) != 2 {
+			t.Errorf("медали спортсмена 1: %+v", profile)
+		}
+
+		c, err := s.getCompetition(ctx, 1)
+		must(t, err)
+		if c.Stage != StageHeat || c.NextStageID != 3 || c.AdvanceCount != 1 ||
+			c.title() != "Гимнастика: Многоборье, предварительный этап" {
+			t.Errorf("забег 1: %+v", c)
+		}
+
+		// Удаление финала оставляет забеги без следующего этапа
+		must(t, s.deleteCompetition(ctx, 3))
+		if c, err = s.getCompetition(ctx, 1); err != nil || c.NextStageID != 0 {
+			t.Errorf("забег 1 после удаления финала: %+v, %v", c, err)
+		}
+	})
+}
+
+// Участники без места в завершённом соревновании и стартовый лист
+// отменённого загружаются из дампа, хотя записать их туда напрямую
+// уже нельзя
+func TestRestoreDumpStartLists(t *testing.T) {
+	ctx := context.Background()
+	s := openTestStore(t)
+	seed(t, s)
+
+	must(t, s.addCompetition(ctx, testPast, 60, StatusInProgress, 1, 1))
+	must(t, s.addResult(ctx, 1, false, 1, 0))
+	must(t, s.addResult(ctx, 1, false, 3, 0))
+	must(t, s.updateCompetition(ctx, 1, testPast, 60, StatusFinished, 1, 1, StageFinal, 0, 0))
+	results, err := s.getCompetitionResults(ctx, 1)
+	must(t, err)
+	must(t, s.setResultPlace(ctx, results[slices.IndexFunc(results, func(r Result) bool { return r.ParticipantID == 3 })], 1))
+	must(t, s.addCompetition(ctx, testPast.AddDate(0, 0, 2), 60, StatusScheduled, 1, 1))
+	must(t, s.addResult(ctx, 2, false, 2, 0))
+	must(t, s.updateCompetition(ctx, 2, testPast.AddDate(0, 0, 2), 60, StatusCancelled, 1, 1, StageFinal, 0, 0))
+
+	d, err := s.dumpDatabase(ctx)
+	must(t, err)
+	restored := openTestStore(t)
+	_, err = restored.restoreDump(ctx, d, ConflictFail)
+	must(t, err)
+
+	for _, want := range []struct {
+		id int
+		status CompetitionStatus
+		places []int
+	}{
+		{1, StatusFinished, []int{1, 0}},
+		{2, StatusCancelled, []int{0}},
+	} {
+		c, err := restored.getCompetition(ctx, want.id)
+		must(t, err)
+		results, err := restored.getCompetitionResults(ctx, want.id)
+		must(t, err)
+		var places []int
+		for _, r := range results {
+			places = append(places, r.Place)
+		}
+		if c.Status != want.status || !slices.Equal(places, want.places) {
+			t.Errorf("соревнование %d после загрузки: %s, места %v", want.id, c.Status, places)
+		}
+	}
+}
+
+// Дамп новой версии отклоняется по версии, а не из-за незнакомых полей
+func TestReadDumpVersion(t *testing.T) {
+	_, err := readDump(strings.NewReader(fmt.Sprintf(`{"version": %d, "stages": []}`, dumpVersion+1)))
+	if err == nil || !strings.Contains(err.Error(), "не поддерживается") {
+		t.Errorf("дамп новой версии: %v", err)
+	}
+
+	d, err := readDump(strings.NewReader(`{"version": 1, "countries": [{"code": "RUS", "name": "Россия"}]}`))
+	if err != nil || len(d.Countries) != 1 {
+		t.Errorf("дамп версии 1: %+v, %v", d, err)
+	}
+}
+
+// Числа в JSON — по типу столбца, а не по виду ячейки: код "007"
+// остаётся строкой, пустое место становится null
+func TestWriteJSONTypes(t *testing.T) {
+	var got strings.Builder
+	must(t, writeJSON(&got, []string{"Код", "Место", "Командный"}, []columnType{columnText, columnInt, columnBool},
+		[][]string{{"007", "", "true"}, {"12", "3", "false"}}))
+	want := "[\n  {\"Код\": \"007\", \"Место\": null, \"Командный\": true},\n" +
+		"  {\"Код\": \"12\", \"Место\": 3, \"Командный\": false}\n]\n"
+	if got.String() != want {
+		t.Errorf("JSON:\n%s\nожидалось:\n%s", got.String(), want)
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := s.getCountries(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("чтение с отменённым контекстом: %v", err)
+		}
+		if err := s.addCountry(ctx, "RUS", "Россия"); !errors.Is(err, context.Canceled) {
+			t.Errorf("запись с отменённым контекстом: %v", err)
+		}
+	})
+}
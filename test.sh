@@ -2,9 +2,13 @@
 
 set -x
 
-rm -f db.sqlite3
+# старую базу не удаляем, а откладываем к снимкам приложения
+if [ -f db.sqlite3 ]; then
+    mkdir -p backups
+    mv db.sqlite3 "backups/db-$(date +%Y%m%d-%H%M%S.000)-test.sqlite3"
+fi
 
-#sqlite3 db.sqlite3 <schema.sql
+#cat migrations/*.sql | sqlite3 db.sqlite3
 sqlite3 db.sqlite3 <populate.sql
 #sqlite3 db.sqlite3 <test_queries.sql
 
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 	"time"
-    "encoding/csv"
     "os"
 
 	"github.com/AllenDang/cimgui-go/imgui"
@@ -17,18 +19,35 @@ type Tab int
 const (
 	TabCountries Tab = iota
 	TabSports
+	TabEvents
 	TabAthletes
 	TabSites
 	TabTeams
 	TabCompetitions
 	TabMedals
+	TabImport
+	TabHistory
 )
 
-var tabs []Tab = []Tab{TabCountries, TabSports, TabAthletes, TabSites, TabTeams, TabCompetitions, TabMedals}
+var tabs []Tab = []Tab{TabCountries, TabSports, TabEvents, TabAthletes, TabSites, TabTeams, TabCompetitions, TabMedals, TabImport, TabHistory}
+
+type UIState struct {
+	worker *dbWorker
+	// Пустой, если данные хранятся не в файле базы
+	dbPath string
 
-var uiState struct {
 	oldTab Tab
 
+	// Справочники для выпадающих списков на всех вкладках, загружаются
+	// в фоне. refsDirty — какие из них устарели
+	refCountries []Country
+	refSports []Sport
+	refEvents []Event
+	refSites []Site
+	refAthletes []Athlete
+	refTeams []Team
+	refsDirty DataKind
+
 	countriesList []Country
 	countryCodeInput string
 	countryNameInput string
@@ -38,11 +57,14 @@ var uiState struct {
 	countriesListProcessed []*Country
 	countryCodeFilter string
 	countryNameFilter string
+	countryEditCode string
+	countryEdit Country
 
 	sportsList []Sport
 	sportCodeInput string
 	sportNameInput string
 	sportIsTeamInput bool
+	sportRulesInput Sport
 	sportsDirty bool
 	sportsSortSwitch int32
 	sportsSortFunc func(a *Sport, b *Sport) bool
@@ -50,6 +72,18 @@ var uiState struct {
 	sportCodeFilter string
 	sportNameFilter string
 	sportTeamFilter int32
+	sportEditCode string
+	sportEdit Sport
+
+	eventsDirty bool
+	eventsList []Event
+	eventsListProcessed []*Event
+	eventSportInput Sport
+	eventInput Event
+	eventSportFilter Sport
+	eventNameFilter string
+	eventEditID int
+	eventEdit Event
 
 	athletesDirty bool
 	athletesSortSwitch int32
@@ -62,41 +96,124 @@ var uiState struct {
 	athleteCountryInput Country
 	athleteNameFilter string
 	athleteGenderFilter int32
+	athleteEditID int
+	athleteEditName string
+	athleteEditIsMale bool
+	athleteEditBirthday string
+	athleteEditCountry Country
+	athleteProfile AthleteProfile
+	athleteProfileOpen bool
+
+	athleteConflicts []AthleteConflict
+	athleteConflictsOpen bool
+
+	undoHistory []UndoStep
+	undoHistoryOpen bool
 
 	sitesDirty bool
 	sitesList []Site
 	sitesListProcessed []*Site
 	siteNameInput string
 	siteNameFilter string
+	siteEditID int
+	siteEditName string
 
 	teamsDirty bool
 	teamsList []Team
 	teamsListProcessed []*Team
+	// Составы раскрытых в таблице команд, загружаются при раскрытии
+	teamMembers map[int][]Athlete
 	teamNameInput string
 	teamNameFilter string
 	teamCountryInput Country
 	teamSportInput Sport
 	teamMemberSelection map[int]int
+	teamEditID int
+	teamEditName string
+	teamEditCountry Country
+	teamEditSport Sport
 
 	competitionsDirty bool
 	competitionsList []Competition
 	competitionsListProcessed []*Competition
 	competitionDateInput string
 	competitionTimeInput string
-	competitionSportInput Sport
+	competitionDurationInput int32
+	competitionEventInput Event
 	competitionSiteInput Site
+	competitionStatusInput CompetitionStatus
 	competitionFilterSport Sport
 	competitionFilterSite  Site
+	competitionFilterStatus CompetitionStatus
+	competitionEditID int
+	competitionEditDate string
+	competitionEditTime string
+	competitionEditDuration int32
+	competitionEditEvent Event
+	competitionEditSite Site
+	competitionEditStatus CompetitionStatus
+	competitionEditStage CompetitionStage
+	competitionEditNextStage int
+	competitionEditAdvance int32
+	competitionConflicts map[int][]int
+	competitionResults map[int][]Result
+	// Прошедшие отбор на этапы, на которые ведут предыдущие этапы
+	stageQualifiers map[int][]Result
+	resultParticipantSelection map[int]int
+	resultPlaceInput map[int]int32
+	resultPlaceEdit map[resultKey]int32
 
     medalsList []CountryMedals
     medalsListProcessed []*CountryMedals
     medalsDirty bool
     medalsSortSwitch int32
     medalsSortFunc func(a, b *CountryMedals) bool
-    medalsReportPath string
+    medalsWithoutMedals bool
+    medalsByEvent bool
+    eventMedalsList []EventMedal
+    eventMedalsListProcessed []*EventMedal
+
+	importEntity string
+	importPath string
+	importHeaders []string
+	importRecords [][]string
+	importMapping []int
+	importResults []ImportRowResult
+	importFailed int
+	importDone bool
+
+	auditList []AuditEntry
+	auditListProcessed []*AuditEntry
+	auditDirty bool
+	auditFilterTable string
+	auditFilterAction string
+	auditFilterOperator string
+	auditFilterSearch string
+	auditFilterFrom string
+	auditFilterTo string
+	auditFilterError string
+
+	exportPaths map[Tab]string
+	exportFormat string
 
 	hasError bool
 	error string
+
+	recentFiles []string
+	dbDialogRequested bool
+	dbDialogPath string
+	dumpDialogRequested bool
+	dumpDialogPath string
+	dumpDialogPolicy ConflictPolicy
+}
+
+var uiState UIState
+
+// Идентифицирует участника соревнования независимо от занятого места
+type resultKey struct {
+	competitionID int
+	isTeam bool
+	participantID int
 }
 
 var tableFlags imgui.TableFlags =
@@ -108,15 +225,28 @@ func (tab Tab) name() string {
 	switch tab {
 	case TabCountries: return "Страны"
 	case TabSports: return "Виды спорта"
+	case TabEvents: return "Дисциплины"
 	case TabAthletes: return "Спортсмены"
 	case TabSites: return "Места проведения"
 	case TabTeams: return "Команды"
 	case TabCompetitions: return "Соревнования"
 	case TabMedals: return "Медали"
+	case TabImport: return "Импорт"
+	case TabHistory: return "История"
 	default: return "INVALID TAB"
 	}
 }
 
+// Название с индикатором, пока идут запросы со вкладки. После ### —
+// постоянный идентификатор, чтобы imgui не принял её за новую вкладку
+func (tab Tab) label() string {
+	label := tab.name()
+	if uiState.worker.busy(tab) {
+		label += " " + spinner()
+	}
+	return label + "###" + tab.name()
+}
+
 func (tab Tab) show() {
 	switched := false
 	if uiState.oldTab != tab {
@@ -127,20 +257,109 @@ func (tab Tab) show() {
 	switch tab {
 	case TabCountries: showCountries(switched)
 	case TabSports: showSports(switched)
+	case TabEvents: showEvents(switched)
 	case TabAthletes: showAthletes(switched)
 	case TabSites: showSites(switched)
 	case TabTeams: showTeams(switched)
 	case TabCompetitions: showCompetitions(switched)
 	case TabMedals: showMedals(switched)
+	case TabImport: showImport(switched)
+	case TabHistory: showHistory(switched)
 	default: showError(fmt.Errorf("INVALID TAB"))
 	}
 }
 
+// Выгрузка списка вкладки в том виде, в котором он сейчас показан.
+// table вызывается только при нажатии на кнопку
+func showExport(tab Tab, table func() ([]string, []columnType, [][]string)) {
+	if path, format, ok := showExportButton(tab); ok {
+		headers, types, rows := table()
+		exportTable(path, format, headers, types, rows)
+	}
+}
+
+func exportTable(path string, format string, headers []string, types []columnType, rows [][]string) {
+	if err := exportToFile(path, format, headers, types, rows); err != nil {
+		showError(fmt.Errorf("не удалось экспортировать: %w", err))
+	}
+}
+
+// Путь, формат и кнопка выгрузки. ok — кнопку нажали и путь указан
+func showExportButton(tab Tab) (path string, format string, ok bool) {
+	imgui.TextUnformatted("Экспорт:")
+	imgui.SameLine()
+
+	path = uiState.exportPaths[tab]
+	imgui.SetNextItemWidth(imgui.ContentRegionAvail().X / 4)
+	imgui.InputTextWithHint(fmt.Sprintf("##exportPath_%d", tab), "Путь", &path, 0, nil)
+	uiState.exportPaths[tab] = path
+	imgui.SameLine()
+
+	imgui.SetNextItemWidth(imgui.ContentRegionAvail().X / 8)
+	if imgui.BeginCombo(fmt.Sprintf("##exportFormat_%d", tab), uiState.exportFormat) {
+		for _, f := range exportFormats {
+			if imgui.SelectableBool(f) {
+				uiState.exportFormat = f
+			}
+		}
+		imgui.EndCombo()
+	}
+	imgui.SameLine()
+
+	if imgui.Button(fmt.Sprintf("Экспортировать##export_%d", tab)) {
+		if path == "" {
+			showError(fmt.Errorf("Укажите путь к файлу"))
+			return "", "", false
+		}
+		// Расширение в пути важнее выбранного формата
+		format = exportFormatFromPath(path)
+		if format == "" {
+			format = uiState.exportFormat
+			path += "." + format
+			uiState.exportPaths[tab] = path
+		}
+		return path, format, true
+	}
+	return "", "", false
+}
+
 func showError(err error) {
 	uiState.hasError = true
 	uiState.error = err.Error()
 }
 
+// Запрос к базе через воркер. Ошибку показывает сам, done вызывается
+// только при успехе
+func load[T any](tab Tab, query func(s *cachedStore, ctx context.Context) (T, error), done func(T)) {
+	request(uiState.worker, tab, query, func(result T, err error) {
+		if err != nil {
+			showError(err)
+		} else {
+			done(result)
+		}
+	})
+}
+
+// Изменение данных через воркер. Перечитывать списки после него не нужно,
+// это сделает dataChanged. done (если есть) вызывается при успехе.
+// Значения из uiState нужно скопировать до вызова: write выполняется в
+// другой горутине
+func change(tab Tab, write func(s *cachedStore, ctx context.Context) error, done func()) {
+	load(tab, func(s *cachedStore, ctx context.Context) (struct{}, error) {
+		return struct{}{}, write(s, ctx)
+	}, func(struct{}) {
+		if done != nil {
+			done()
+		}
+	})
+}
+
+// Крутящийся символ для индикаторов загрузки
+func spinner() string {
+	frames := []string{"|", "/", "-", "\\"}
+	return frames[time.Now().UnixMilli()/150%int64(len(frames))]
+}
+
 func showTable[T any](id string, headers []string, items []T, callback func(item T)) {
 	cols := len(headers)
 	if imgui.BeginTableV(id, int32(cols), tableFlags, imgui.Vec2{}, 0) {
@@ -160,6 +379,40 @@ func showTable[T any](id string, headers []string, items []T, callback func(item
 	}
 }
 
+type rowAction int
+
+const (
+	rowNone rowAction = iota
+	rowDelete
+	rowEdit
+	rowSave
+	rowCancel
+)
+
+// Кнопки в первой колонке строки: удалить/изменить, а в режиме
+// редактирования сохранить/отменить
+func rowActions(editing bool) rowAction {
+	action := rowNone
+	if editing {
+		if imgui.Button("OK") {
+			action = rowSave
+		}
+		imgui.SameLine()
+		if imgui.Button("Отмена") {
+			action = rowCancel
+		}
+	} else {
+		if imgui.Button("x") {
+			action = rowDelete
+		}
+		imgui.SameLine()
+		if imgui.Button("Изм.") {
+			action = rowEdit
+		}
+	}
+	return action
+}
+
 func processCompetitions() {
 	uiState.competitionsListProcessed = make([]*Competition, 0, len(uiState.competitionsList))
 	for i := range uiState.competitionsList {
@@ -172,31 +425,260 @@ func processCompetitions() {
 			uiState.competitionFilterSite.ID != c.Site.ID {
 			continue
 		}
+		if uiState.competitionFilterStatus != "" &&
+			uiState.competitionFilterStatus != c.Status {
+			continue
+		}
 		uiState.competitionsListProcessed = append(uiState.competitionsListProcessed, c)
 	}
 }
 
+func reloadCompetitionResults(competitionID int) {
+	// Пустой список, пока идёт загрузка, чтобы не запрашивать её повторно
+	uiState.competitionResults[competitionID] = nil
+	load(TabCompetitions, func(s *cachedStore, ctx context.Context) ([]Result, error) {
+		return s.getStageQualifiers(ctx, competitionID)
+	}, func(qualifiers []Result) {
+		uiState.stageQualifiers[competitionID] = qualifiers
+	})
+	load(TabCompetitions, func(s *cachedStore, ctx context.Context) ([]Result, error) {
+		return s.getCompetitionResults(ctx, competitionID)
+	}, func(results []Result) {
+		uiState.competitionResults[competitionID] = results
+		for k := range uiState.resultPlaceEdit {
+			if k.competitionID == competitionID {
+				delete(uiState.resultPlaceEdit, k)
+			}
+		}
+	})
+}
+
+// Есть ли этапы, из которых проходят в c
+func hasPreviousStages(c *Competition) bool {
+	return slices.ContainsFunc(uiState.competitionsList, func(other Competition) bool {
+		return other.NextStageID == c.ID
+	})
+}
+
+func pickParticipant(c *Competition) {
+	selectedID := uiState.resultParticipantSelection[c.ID]
+	comboLabel := fmt.Sprintf("##pickParticipantCombo%d", c.ID)
+
+	// На этап после отбора попадают только прошедшие его
+	checkQualified := hasPreviousStages(c)
+	qualified := func(isTeam bool, id int) bool {
+		return !checkQualified || slices.ContainsFunc(uiState.stageQualifiers[c.ID], func(r Result) bool {
+			return r.IsTeam == isTeam && r.ParticipantID == id
+		})
+	}
+
+	if c.Sport.IsTeam {
+		teams := uiState.refTeams
+		selectedName := "Выберите команду"
+		for _, t := range teams {
+			if t.ID == selectedID {
+				selectedName = t.Name
+				break
+			}
+		}
+		if imgui.BeginCombo(comboLabel, selectedName) {
+			for _, t := range teams {
+				if t.Sport.Code != c.Sport.Code || !qualified(true, t.ID) {
+					continue
+				}
+				if imgui.SelectableBool(fmt.Sprintf("%s (%s)##%d", t.Name, t.Country.Name, t.ID)) {
+					uiState.resultParticipantSelection[c.ID] = t.ID
+				}
+			}
+			imgui.EndCombo()
+		}
+	} else {
+		athletes := uiState.refAthletes
+		selectedName := "Выберите спортсмена"
+		for _, a := range athletes {
+			if a.ID == selectedID {
+				selectedName = a.Name
+				break
+			}
+		}
+		if imgui.BeginCombo(comboLabel, selectedName) {
+			for _, a := range athletes {
+				if byGender, byAge := c.Event.fits(a, c.Time); !byGender || !byAge || !qualified(false, a.ID) {
+					continue
+				}
+				if imgui.SelectableBool(fmt.Sprintf("%s (%s)##%d", a.Name, a.CountryName, a.ID)) {
+					uiState.resultParticipantSelection[c.ID] = a.ID
+				}
+			}
+			imgui.EndCombo()
+		}
+	}
+}
+
+func showCompetitionResults(c *Competition) {
+	results, ok := uiState.competitionResults[c.ID]
+	if !ok {
+		reloadCompetitionResults(c.ID)
+	}
+
+	// Места ставятся только завершённому соревнованию, до этого
+	// участники только записываются в стартовый лист
+	placesAllowed := c.Status == StatusFinished
+	placed := 0
+
+	for _, r := range results {
+		key := resultKey{r.CompetitionID, r.IsTeam, r.ParticipantID}
+		imgui.PushIDStr(fmt.Sprintf("result_%t_%d", r.IsTeam, r.ParticipantID))
+
+		if imgui.Button("X") {
+			change(TabCompetitions, func(s *cachedStore, ctx context.Context) error {
+				return s.deleteResult(ctx, r)
+			}, nil)
+		}
+		imgui.SameLine()
+
+		if r.Place != 0 {
+			placed++
+		}
+		if placesAllowed {
+			place, ok := uiState.resultPlaceEdit[key]
+			if !ok {
+				place = int32(r.Place)
+			}
+			imgui.SetNextItemWidth(imgui.ContentRegionAvail().X / 4)
+			if imgui.InputInt("##place", &place) {
+				uiState.resultPlaceEdit[key] = place
+			}
+			imgui.SameLine()
+			if imgui.Button("Изменить") && int(place) != r.Place {
+				change(TabCompetitions, func(s *cachedStore, ctx context.Context) error {
+					return s.setResultPlace(ctx, r, int(place))
+				}, nil)
+			}
+			imgui.SameLine()
+		}
+		imgui.TextUnformatted(fmt.Sprintf("%s (%s)", r.ParticipantName, r.CountryName))
+		if r.Place == 0 && placesAllowed {
+			imgui.SameLine()
+			imgui.TextDisabled("без места")
+		}
+
+		imgui.PopID()
+	}
+
+	if hasPreviousStages(c) {
+		var names []string
+		notEntered := 0
+		for _, q := range uiState.stageQualifiers[c.ID] {
+			names = append(names, q.ParticipantName)
+			if !slices.ContainsFunc(results, func(r Result) bool { return r.IsTeam == q.IsTeam && r.ParticipantID == q.ParticipantID }) {
+				notEntered++
+			}
+		}
+		if len(names) == 0 {
+			imgui.TextDisabled("Отбор на этап ещё никто не прошёл")
+		} else {
+			imgui.TextUnformatted("Прошли отбор: " + strings.Join(names, ", "))
+		}
+		// Завершённому этапу нужны места, так что записывать без места
+		// можно только до конца соревнования
+		if notEntered > 0 && (c.Status == StatusScheduled || c.Status == StatusInProgress) {
+			competitionID := c.ID
+			if imgui.Button(fmt.Sprintf("Записать прошедших отбор (%d)", notEntered)) {
+				change(TabCompetitions, func(s *cachedStore, ctx context.Context) error {
+					return s.addStageQualifiers(ctx, competitionID)
+				}, nil)
+			}
+		}
+	}
+
+	if c.Status == StatusCancelled {
+		imgui.TextDisabled("Соревнование отменено")
+		return
+	}
+	if !placesAllowed {
+		imgui.TextDisabled("До завершения соревнования участники записываются без места")
+	}
+
+	place, ok := uiState.resultPlaceInput[c.ID]
+	if !ok {
+		place = int32(placed + 1)
+	}
+	if !placesAllowed {
+		place = 0
+	}
+
+	if imgui.Button("Добавить") {
+		sel := uiState.resultParticipantSelection[c.ID]
+		if sel == 0 {
+			if c.Sport.IsTeam {
+				showError(fmt.Errorf("Не выбрана команда"))
+			} else {
+				showError(fmt.Errorf("Не выбран спортсмен"))
+			}
+		} else {
+			competitionID, isTeam := c.ID, c.Sport.IsTeam
+			change(TabCompetitions, func(s *cachedStore, ctx context.Context) error {
+				return s.addResult(ctx, competitionID, isTeam, sel, int(place))
+			}, func() {
+				delete(uiState.resultParticipantSelection, competitionID)
+				delete(uiState.resultPlaceInput, competitionID)
+			})
+		}
+	}
+	imgui.SameLine()
+	if placesAllowed {
+		imgui.SetNextItemWidth(imgui.ContentRegionAvail().X / 4)
+		if imgui.InputInt("##newPlace", &place) {
+			uiState.resultPlaceInput[c.ID] = place
+		}
+		imgui.SameLine()
+	}
+	pickParticipant(c)
+}
+
 func showCompetitions(switched bool) {
 	if switched {
-		uiState.competitionsList, _ = getCompetitions()
 		uiState.competitionsDirty = true
+		uiState.competitionResults = make(map[int][]Result)
+		uiState.stageQualifiers = make(map[int][]Result)
 	}
 
 	avail := imgui.ContentRegionAvail()
 
-	imgui.SetNextItemWidth(avail.X / 5)
+	imgui.SetNextItemWidth(avail.X / 7)
 	imgui.InputTextWithHint("##compDate", "Дата (YYYY-MM-DD)", &uiState.competitionDateInput, 0, nil)
 	imgui.SameLine()
-	imgui.SetNextItemWidth(avail.X / 5)
+	imgui.SetNextItemWidth(avail.X / 7)
 	imgui.InputTextWithHint("##compTime", "Время (HH:MM)", &uiState.competitionTimeInput, 0, nil)
 	imgui.SameLine()
-	imgui.SetNextItemWidth(avail.X / 5)
-	pickSport(&uiState.competitionSportInput, "##pickSportCombo")
+	imgui.SetNextItemWidth(avail.X / 7)
+	imgui.InputInt("мин##compDuration", &uiState.competitionDurationInput)
+	imgui.SameLine()
+	imgui.SetNextItemWidth(avail.X / 7)
+	pickEvent(&uiState.competitionEventInput, "##pickEventCombo")
 	imgui.SameLine()
-	imgui.SetNextItemWidth(avail.X / 5)
+	imgui.SetNextItemWidth(avail.X / 7)
 	pickSite(&uiState.competitionSiteInput, "##pickSiteCombo")
 	imgui.SameLine()
-	imgui.SetNextItemWidth(avail.X / 5)
+	imgui.SetNextItemWidth(avail.X / 7)
+	pickStatus(&uiState.competitionStatusInput, "##pickStatusCombo", false)
+	imgui.SameLine()
+	if imgui.Button("Свободное время") {
+		if uiState.competitionDateInput == "" || uiState.competitionSiteInput.ID == 0 {
+			showError(fmt.Errorf("Выберите дату и место"))
+		} else if day, err := time.Parse(time.DateOnly, uiState.competitionDateInput); err != nil {
+			showError(err)
+		} else {
+			siteID, duration := uiState.competitionSiteInput.ID, int(uiState.competitionDurationInput)
+			load(TabCompetitions, func(s *cachedStore, ctx context.Context) (time.Time, error) {
+				return s.findFreeSlot(ctx, siteID, day, duration)
+			}, func(slot time.Time) {
+				uiState.competitionTimeInput = slot.Format("15:04")
+			})
+		}
+	}
+	imgui.SameLine()
 	if imgui.Button("Добавить") {
 
 		if uiState.competitionDateInput == "" || uiState.competitionTimeInput == "" {
@@ -208,17 +690,20 @@ func showCompetitions(switched bool) {
 			if err != nil {
 				showError(err)
 			} else {
-				err = addCompetition(t, uiState.competitionSportInput.Code, uiState.competitionSiteInput.ID)
-				if err != nil {
-					showError(err)
-				} else {
-					uiState.competitionsList, _ = getCompetitions()
-					uiState.competitionsDirty = true
-				}
+				duration, status := int(uiState.competitionDurationInput), uiState.competitionStatusInput
+				eventID, siteID := uiState.competitionEventInput.ID, uiState.competitionSiteInput.ID
+				change(TabCompetitions, func(s *cachedStore, ctx context.Context) error {
+					return s.addCompetition(ctx, t, duration, status, eventID, siteID)
+				}, nil)
 			}
 		}
 	}
 
+	imgui.SameLine()
+	if imgui.Button("Конфликты спортсменов") {
+		openAthleteConflicts()
+	}
+
 	imgui.Separator()
 
 	imgui.Text("Фильтр")
@@ -247,33 +732,156 @@ func showCompetitions(switched bool) {
 		uiState.competitionsDirty = true
 	}
 
+	imgui.Text("Статус")
+	imgui.SetNextItemWidth(avail.X / 3)
+	imgui.SameLine()
+	if pickStatus(&uiState.competitionFilterStatus, "##pickStatusComboFilter", true) {
+		uiState.competitionsDirty = true
+	}
+
 	if uiState.competitionsDirty {
 		uiState.competitionsDirty = false
-		processCompetitions()
+		load(TabCompetitions, (*cachedStore).getCompetitions, func(competitions []Competition) {
+			uiState.competitionsList = competitions
+			processCompetitions()
+		})
+		load(TabCompetitions, (*cachedStore).getSiteConflicts, func(conflicts map[int][]int) {
+			uiState.competitionConflicts = conflicts
+		})
 	}
 
-	showTable("##competitionsTable",
-		[]string{"", "Дата и время", "Вид спорта", "Место"},
+	showExport(TabCompetitions, func() ([]string, []columnType, [][]string) {
+		return competitionsTable(uiState.competitionsListProcessed)
+	})
+
+showTable("##competitionsTable",
+		[]string{"", "Дата и время", "Длительность", "Дисциплина", "Место", "Статус", "Этап", "Результаты"},
 		uiState.competitionsListProcessed,
 		func(c *Competition) {
 
 			imgui.TableNextRow()
 
+			editing := uiState.competitionEditID == c.ID
+
 			imgui.TableNextColumn()
-			if imgui.Button("x") {
-				deleteCompetition(c.ID)
-				uiState.competitionsList, _ = getCompetitions()
-				uiState.competitionsDirty = true
+			switch rowActions(editing) {
+			case rowDelete:
+				id := c.ID
+				change(TabCompetitions, func(s *cachedStore, ctx context.Context) error {
+					return s.deleteCompetition(ctx, id)
+				}, nil)
+			case rowEdit:
+				uiState.competitionEditID = c.ID
+				uiState.competitionEditDate = c.Time.Format(time.DateOnly)
+				uiState.competitionEditTime = c.Time.Format("15:04")
+				uiState.competitionEditDuration = int32(c.Duration)
+				uiState.competitionEditEvent = c.Event
+				uiState.competitionEditSite = c.Site
+				uiState.competitionEditStatus = c.Status
+				uiState.competitionEditStage = c.Stage
+				uiState.competitionEditNextStage = c.NextStageID
+				uiState.competitionEditAdvance = int32(c.AdvanceCount)
+			case rowSave:
+				t, err := time.Parse("2006-01-02 15:04",
+					uiState.competitionEditDate+" "+uiState.competitionEditTime)
+				if err != nil {
+					showError(err)
+				} else {
+					id, duration, status := c.ID, int(uiState.competitionEditDuration), uiState.competitionEditStatus
+					eventID, siteID := uiState.competitionEditEvent.ID, uiState.competitionEditSite.ID
+					stage, next := uiState.competitionEditStage, uiState.competitionEditNextStage
+					advance := max(int(uiState.competitionEditAdvance), 0)
+					change(TabCompetitions, func(s *cachedStore, ctx context.Context) error {
+						return s.updateCompetition(ctx, id, t, duration, status, eventID, siteID, stage, next, advance)
+					}, func() {
+						uiState.competitionEditID = 0
+					})
+				}
+			case rowCancel:
+				uiState.competitionEditID = 0
 			}
 
-			imgui.TableNextColumn()
-			imgui.TextUnformatted(c.Time.Format("2006-01-02 15:04"))
+			if editing {
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(imgui.ContentRegionAvail().X / 2)
+				imgui.InputTextWithHint("##editDate", "YYYY-MM-DD", &uiState.competitionEditDate, 0, nil)
+				imgui.SameLine()
+				imgui.SetNextItemWidth(-1)
+				imgui.InputTextWithHint("##editTime", "HH:MM", &uiState.competitionEditTime, 0, nil)
 
-			imgui.TableNextColumn()
-			imgui.TextUnformatted(c.Sport.Name)
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				imgui.InputInt("##editDuration", &uiState.competitionEditDuration)
+
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				pickEvent(&uiState.competitionEditEvent, "##editEvent")
+
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				pickSite(&uiState.competitionEditSite, "##editSite")
+
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				pickStatus(&uiState.competitionEditStatus, "##editStatus", false)
+
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				if pickStage(&uiState.competitionEditStage, "##editStage") && uiState.competitionEditStage == StageFinal {
+					uiState.competitionEditNextStage, uiState.competitionEditAdvance = 0, 0
+				}
+				if uiState.competitionEditStage != StageFinal {
+					imgui.SetNextItemWidth(-1)
+					pickNextStage(c, &uiState.competitionEditNextStage, "##editNextStage")
+					imgui.SetNextItemWidth(imgui.ContentRegionAvail().X / 2)
+					imgui.InputInt("проходят##editAdvance", &uiState.competitionEditAdvance)
+				}
+			} else {
+				imgui.TableNextColumn()
+				if conflicts := uiState.competitionConflicts[c.ID]; len(conflicts) > 0 {
+					imgui.TextColored(imgui.Vec4{X: 1, Y: 0.3, Z: 0.3, W: 1}, c.Time.Format("2006-01-02 15:04"))
+					var others []string
+					for _, other := range uiState.competitionsList {
+						if slices.Contains(conflicts, other.ID) {
+							others = append(others, other.Time.Format("15:04")+" "+other.title())
+						}
+					}
+					imgui.SetItemTooltip("Место в это время занято: " + strings.Join(others, ", "))
+				} else {
+					imgui.TextUnformatted(c.Time.Format("2006-01-02 15:04"))
+				}
+
+				imgui.TableNextColumn()
+				imgui.Text(fmt.Sprintf("%d мин (до %s)", c.Duration, c.End().Format("15:04")))
+
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(c.title())
+				if conditions := c.Event.conditions(); conditions != "" {
+					imgui.SetItemTooltip(conditions)
+				}
+
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(c.Site.Name)
+
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(c.Status.name())
+
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(c.Stage.name())
+				if c.NextStageID != 0 {
+					advance := "все"
+					if c.AdvanceCount > 0 {
+						advance = fmt.Sprintf("первые %d", c.AdvanceCount)
+					}
+					imgui.SetItemTooltip(fmt.Sprintf("В соревнование №%d проходят %s", c.NextStageID, advance))
+				}
+			}
 
 			imgui.TableNextColumn()
-			imgui.TextUnformatted(c.Site.Name)
+			if imgui.TreeNodeStr(fmt.Sprintf("Результаты##results_%d", c.ID)) {
+				showCompetitionResults(c)
+				imgui.TreePop()
+			}
 		})
 }
 
@@ -291,7 +899,6 @@ func processSites() {
 
 func showSites(switched bool) {
 	if switched {
-		uiState.sitesList, _ = getSites()
 		uiState.sitesDirty = true
 	}
 
@@ -301,13 +908,10 @@ func showSites(switched bool) {
 	imgui.SameLine()
 	imgui.SetNextItemWidth(avail.X / 1)
 	if imgui.Button("Добавить") {
-		err := addSite(uiState.siteNameInput)
-		if err != nil {
-			showError(err)
-		} else {
-			uiState.sitesList, _ = getSites()
-			uiState.sitesDirty = true
-		}
+		name := uiState.siteNameInput
+		change(TabSites, func(s *cachedStore, ctx context.Context) error {
+			return s.addSite(ctx, name)
+		}, nil)
 	}
 
 	imgui.Separator()
@@ -321,20 +925,48 @@ func showSites(switched bool) {
 
 	if uiState.sitesDirty {
 		uiState.sitesDirty = false
-		processSites()
+		load(TabSites, (*cachedStore).getSites, func(sites []Site) {
+			uiState.sitesList = sites
+			processSites()
+		})
 	}
 
-	showTable("##sitesTable", []string{"", "Название"},
+	showExport(TabSites, func() ([]string, []columnType, [][]string) {
+		return sitesTable(uiState.sitesListProcessed)
+	})
+
+showTable("##sitesTable", []string{"", "Название"},
 		uiState.sitesListProcessed, func(s *Site) {
+			editing := uiState.siteEditID == s.ID
+
 			imgui.TableNextRow()
 			imgui.TableNextColumn()
-			if imgui.Button("x") {
-				deleteSite(s.ID)
-				uiState.sitesList, _ = getSites()
-				uiState.sitesDirty = true
+			switch rowActions(editing) {
+			case rowDelete:
+				id := s.ID
+				change(TabSites, func(s *cachedStore, ctx context.Context) error {
+					return s.deleteSite(ctx, id)
+				}, nil)
+			case rowEdit:
+				uiState.siteEditID = s.ID
+				uiState.siteEditName = s.Name
+			case rowSave:
+				id, name := s.ID, uiState.siteEditName
+				change(TabSites, func(s *cachedStore, ctx context.Context) error {
+					return s.updateSite(ctx, id, name)
+				}, func() {
+					uiState.siteEditID = 0
+				})
+			case rowCancel:
+				uiState.siteEditID = 0
 			}
 			imgui.TableNextColumn()
-			imgui.TextUnformatted(s.Name)
+			if editing {
+				imgui.SetNextItemWidth(-1)
+				imgui.InputTextWithHint("##editName", "Название места", &uiState.siteEditName, 0, nil)
+			} else {
+				imgui.TextUnformatted(s.Name)
+			}
 		})
 }
 
@@ -364,8 +996,7 @@ func processAthletes() {
 func pickCountry(country *Country) {
 	if imgui.BeginCombo("##pickCountryCombo", country.Name) {
 		defer imgui.EndCombo()
-		countries, _ := getCountries()
-		for _, c := range countries {
+		for _, c := range uiState.refCountries {
 			if imgui.SelectableBool(c.Name) {
 				*country = c
 				return
@@ -377,8 +1008,7 @@ func pickCountry(country *Country) {
 func pickSite(site *Site, id string) bool {
 	if imgui.BeginCombo(id, site.Name) {
 		defer imgui.EndCombo()
-		sites, _ := getSites()
-		for _, s := range sites {
+		for _, s := range uiState.refSites {
 			if (imgui.SelectableBool(s.Name)) {
 				*site = s
 				return true
@@ -388,11 +1018,71 @@ func pickSite(site *Site, id string) bool {
 	return false
 }
 
+// withAll добавляет пункт "Все" (пустой статус) для фильтра
+func pickStatus(status *CompetitionStatus, id string, withAll bool) bool {
+	preview := status.name()
+	if *status == "" {
+		preview = "Все"
+	}
+
+	if imgui.BeginCombo(id, preview) {
+		defer imgui.EndCombo()
+		if withAll && imgui.SelectableBool("Все") {
+			*status = ""
+			return true
+		}
+		for _, s := range competitionStatuses {
+			if imgui.SelectableBool(s.name()) {
+				*status = s
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func pickStage(stage *CompetitionStage, id string) bool {
+	if imgui.BeginCombo(id, stage.name()) {
+		defer imgui.EndCombo()
+		for _, s := range competitionStages {
+			if imgui.SelectableBool(s.name()) {
+				*stage = s
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Следующий этап для c — соревнования той же дисциплины дальше по сетке,
+// чем выбранный при редактировании этап
+func pickNextStage(c *Competition, next *int, id string) {
+	preview := "Следующий этап не выбран"
+	if *next != 0 {
+		preview = fmt.Sprintf("№%d", *next)
+	}
+	if imgui.BeginCombo(id, preview) {
+		defer imgui.EndCombo()
+		if imgui.SelectableBool("Нет") {
+			*next = 0
+		}
+		for _, other := range uiState.competitionsList {
+			if other.Event.ID != uiState.competitionEditEvent.ID ||
+				other.Stage.rank() <= uiState.competitionEditStage.rank() || other.ID == c.ID {
+				continue
+			}
+			label := fmt.Sprintf("№%d %s, %s", other.ID, other.Stage.name(), other.Time.Format("2006-01-02 15:04"))
+			if imgui.SelectableBool(label) {
+				*next = other.ID
+			}
+		}
+	}
+}
+
 func pickSport(sport *Sport, id string) bool {
 	if imgui.BeginCombo(id, sport.Name) {
 		defer imgui.EndCombo()
-		sports, _ := getSports()
-		for _, s := range sports {
+		for _, s := range uiState.refSports {
 			if (imgui.SelectableBool(s.Name)) {
 				*sport = s
 				return true
@@ -402,9 +1092,48 @@ func pickSport(sport *Sport, id string) bool {
 	return false
 }
 
+// Дисциплины всех видов спорта, подписанные как «Вид спорта: дисциплина»
+func pickEvent(event *Event, id string) bool {
+	if imgui.BeginCombo(id, eventTitle(*event)) {
+		defer imgui.EndCombo()
+		for _, e := range uiState.refEvents {
+			if imgui.SelectableBool(fmt.Sprintf("%s##%d", eventTitle(e), e.ID)) {
+				*event = e
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func eventTitle(e Event) string {
+	if e.ID == 0 {
+		return ""
+	}
+	for _, s := range uiState.refSports {
+		if s.Code == e.SportCode {
+			return s.Name + ": " + e.Name
+		}
+	}
+	return e.Name
+}
+
+// Как pickSport, но только командные виды спорта
+func pickTeamSport(sport *Sport, id string) bool {
+	if imgui.BeginCombo(id, sport.Name) {
+		defer imgui.EndCombo()
+		for _, s := range uiState.refSports {
+			if s.IsTeam && imgui.SelectableBool(s.Name) {
+				*sport = s
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func showAthletes(switched bool) {
 	if switched {
-		uiState.athletesList, _ = getAthletes()
 		uiState.athletesDirty = true
 	}
 
@@ -429,13 +1158,10 @@ func showAthletes(switched bool) {
 		if t, err := time.Parse(time.DateOnly, uiState.athleteBirthdayInput); err != nil {
 			showError(err)
 		} else {
-			err := addAthlete(uiState.athleteNameInput, uiState.athleteIsMaleInput, t, uiState.athleteCountryInput.Code)
-			if err != nil {
-				showError(err)
-			} else {
-				uiState.athletesList, _ = getAthletes()
-				uiState.athletesDirty = true
-			}
+			name, isMale, countryCode := uiState.athleteNameInput, uiState.athleteIsMaleInput, uiState.athleteCountryInput.Code
+			change(TabAthletes, func(s *cachedStore, ctx context.Context) error {
+				return s.addAthlete(ctx, name, isMale, t, countryCode)
+			}, nil)
 		}
 	}
 
@@ -487,10 +1213,17 @@ func showAthletes(switched bool) {
 
 	if uiState.athletesDirty {
 		uiState.athletesDirty = false
-		processAthletes()
+		load(TabAthletes, (*cachedStore).getAthletes, func(athletes []Athlete) {
+			uiState.athletesList = athletes
+			processAthletes()
+		})
 	}
 
-	showTable("##athletesTable", []string{"", "Имя", "Пол", "День рождения", "Страна"},
+	showExport(TabAthletes, func() ([]string, []columnType, [][]string) {
+		return athletesTable(uiState.athletesListProcessed)
+	})
+
+showTable("##athletesTable", []string{"", "Имя", "Пол", "День рождения", "Страна"},
 		uiState.athletesListProcessed, func(a *Athlete) {
 			var gender string
 			if a.Gender == "M" {
@@ -499,26 +1232,175 @@ func showAthletes(switched bool) {
 				gender = "Ж"
 			}
 
+			editing := uiState.athleteEditID == a.ID
+
 			imgui.TableNextRow()
 			imgui.TableNextColumn()
-			if imgui.Button("x") {
-				deleteAthlete(a.ID)
-				uiState.athletesList, _ = getAthletes()
-				uiState.athletesDirty = true
+			switch rowActions(editing) {
+			case rowDelete:
+				id := a.ID
+				change(TabAthletes, func(s *cachedStore, ctx context.Context) error {
+					return s.deleteAthlete(ctx, id)
+				}, nil)
+			case rowEdit:
+				uiState.athleteEditID = a.ID
+				uiState.athleteEditName = a.Name
+				uiState.athleteEditIsMale = a.Gender == "M"
+				uiState.athleteEditBirthday = a.Birthday.Format(time.DateOnly)
+				uiState.athleteEditCountry = Country{Code: a.CountryCode, Name: a.CountryName}
+			case rowSave:
+				if t, err := time.Parse(time.DateOnly, uiState.athleteEditBirthday); err != nil {
+					showError(err)
+				} else {
+					id, name, isMale, countryCode := a.ID, uiState.athleteEditName, uiState.athleteEditIsMale, uiState.athleteEditCountry.Code
+					change(TabAthletes, func(s *cachedStore, ctx context.Context) error {
+						return s.updateAthlete(ctx, id, name, isMale, t, countryCode)
+					}, func() {
+						uiState.athleteEditID = 0
+					})
+				}
+			case rowCancel:
+				uiState.athleteEditID = 0
+			}
+			imgui.SameLine()
+			if imgui.Button("Профиль") {
+				id := a.ID
+				load(TabAthletes, func(s *cachedStore, ctx context.Context) (AthleteProfile, error) {
+					return s.getAthleteProfile(ctx, id)
+				}, func(p AthleteProfile) {
+					uiState.athleteProfile = p
+					uiState.athleteProfileOpen = true
+				})
+			}
+
+			if editing {
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				imgui.InputTextWithHint("##editName", "Имя", &uiState.athleteEditName, 0, nil)
+				imgui.TableNextColumn()
+				if imgui.RadioButtonBool("М", uiState.athleteEditIsMale) {
+					uiState.athleteEditIsMale = true
+				}
+				if imgui.SameLine(); imgui.RadioButtonBool("Ж", !uiState.athleteEditIsMale) {
+					uiState.athleteEditIsMale = false
+				}
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				imgui.InputTextWithHint("##editBirthday", "День рождения", &uiState.athleteEditBirthday, 0, nil)
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				pickCountry(&uiState.athleteEditCountry)
+			} else {
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(a.Name)
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(gender)
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(a.Birthday.Format(time.DateOnly))
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(a.CountryName)
 			}
-			imgui.TableNextColumn()
-			imgui.TextUnformatted(a.Name)
-			imgui.TableNextColumn()
-			imgui.TextUnformatted(gender)
-			imgui.TableNextColumn()
-			imgui.TextUnformatted(a.Birthday.Format(time.DateOnly))
-			imgui.TableNextColumn()
-			imgui.TextUnformatted(a.CountryName)
 		})
 }
 
-func processSports() {
-	uiState.sportsListProcessed = make([]*Sport, 0, len(uiState.sportsList))
+func showAthleteProfile() {
+	if !uiState.athleteProfileOpen {
+		return
+	}
+
+	p := &uiState.athleteProfile
+	a := &p.Athlete
+
+	imgui.SetNextWindowSizeV(imgui.Vec2{X: 700, Y: 500}, imgui.CondFirstUseEver)
+	if imgui.BeginV(fmt.Sprintf("%s###athleteProfile", a.Name), &uiState.athleteProfileOpen, 0) {
+		var gender string
+		if a.Gender == "M" {
+			gender = "М"
+		} else {
+			gender = "Ж"
+		}
+
+		imgui.TextUnformatted(fmt.Sprintf("%s, %s, %s, %s", a.Name, gender,
+			a.Birthday.Format(time.DateOnly), a.CountryName))
+		imgui.TextUnformatted(fmt.Sprintf("Медали: золото %d, серебро %d, бронза %d, всего %d",
+			p.Gold, p.Silver, p.Bronze, p.Gold+p.Silver+p.Bronze))
+
+		imgui.Separator()
+		imgui.TextUnformatted("Команды")
+		if len(p.Teams) == 0 {
+			imgui.TextDisabled("Не состоит в командах")
+		}
+		for _, t := range p.Teams {
+			imgui.BulletText(fmt.Sprintf("%s (%s)", t.Name, t.Sport.Name))
+		}
+
+		imgui.Separator()
+		imgui.TextUnformatted("Соревнования")
+		showTable("##athleteCompetitionsTable",
+			[]string{"Дата и время", "Дисциплина", "Место проведения", "Команда", "Место"},
+			p.Competitions, func(ac AthleteCompetition) {
+				imgui.TableNextRow()
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(ac.Competition.Time.Format("2006-01-02 15:04"))
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(ac.Competition.title())
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(ac.Competition.Site.Name)
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(ac.TeamName)
+				imgui.TableNextColumn()
+				if ac.Place == 0 {
+					imgui.TextDisabled("—")
+				} else {
+					imgui.TextUnformatted(fmt.Sprintf("%d", ac.Place))
+				}
+			})
+	}
+	imgui.End()
+}
+
+func openAthleteConflicts() {
+	load(TabCompetitions, (*cachedStore).getAthleteConflicts, func(conflicts []AthleteConflict) {
+		uiState.athleteConflicts = conflicts
+		uiState.athleteConflictsOpen = true
+	})
+}
+
+func showAthleteConflicts() {
+	if !uiState.athleteConflictsOpen {
+		return
+	}
+
+	formatCompetition := func(c Competition) string {
+		return fmt.Sprintf("%s, %s, %s-%s", c.title(), c.Site.Name,
+			c.Time.Format("2006-01-02 15:04"), c.End().Format("15:04"))
+	}
+
+	imgui.SetNextWindowSizeV(imgui.Vec2{X: 800, Y: 400}, imgui.CondFirstUseEver)
+	if imgui.BeginV("Конфликты расписания спортсменов###athleteConflicts", &uiState.athleteConflictsOpen, 0) {
+		if imgui.Button("Обновить") {
+			openAthleteConflicts()
+		}
+		if len(uiState.athleteConflicts) == 0 {
+			imgui.TextDisabled("Конфликтов нет")
+		}
+		showTable("##athleteConflictsTable",
+			[]string{"Спортсмен", "Соревнование", "Пересекается с"},
+			uiState.athleteConflicts, func(c AthleteConflict) {
+				imgui.TableNextRow()
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(c.AthleteName)
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(formatCompetition(c.First))
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(formatCompetition(c.Second))
+			})
+	}
+	imgui.End()
+}
+
+func processSports() {
+	uiState.sportsListProcessed = make([]*Sport, 0, len(uiState.sportsList))
 
 	for i := range uiState.sportsList {
 		s := &uiState.sportsList[i]
@@ -546,7 +1428,6 @@ func processSports() {
 
 func showSports(switched bool) {
 	if switched {
-		uiState.sportsList, _ = getSports()
 		uiState.sportsDirty = true
 	}
 
@@ -562,13 +1443,19 @@ func showSports(switched bool) {
 	imgui.SameLine()
 	imgui.SetNextItemWidth(avail.X / 1)
 	if imgui.Button("Добавить") {
-		err := addSport(uiState.sportCodeInput, uiState.sportNameInput, uiState.sportIsTeamInput)
-		if err != nil {
-			showError(err)
-		} else {
-			uiState.sportsList, _ = getSports()
-			uiState.sportsDirty = true
+		sport := Sport{Code: uiState.sportCodeInput, Name: uiState.sportNameInput, IsTeam: uiState.sportIsTeamInput}
+		if sport.IsTeam {
+			rules := uiState.sportRulesInput
+			sport.MinPlayers, sport.MaxPlayers, sport.TeamGender = rules.MinPlayers, rules.MaxPlayers, rules.TeamGender
 		}
+		change(TabSports, func(s *cachedStore, ctx context.Context) error {
+			return s.addSport(ctx, sport)
+		}, nil)
+	}
+	if uiState.sportIsTeamInput {
+		imgui.TextUnformatted("Состав команды")
+		imgui.SameLine()
+		editTeamRules(&uiState.sportRulesInput, avail.X/6)
 	}
 
 	imgui.Separator()
@@ -626,31 +1513,265 @@ func showSports(switched bool) {
 
 	if uiState.sportsDirty {
 		uiState.sportsDirty = false
-		processSports()
+		load(TabSports, (*cachedStore).getSports, func(sports []Sport) {
+			uiState.sportsList = sports
+			processSports()
+		})
 	}
 
-	showTable("##sportsTable", []string{"", "Код", "Название", "Тип"},
+	showExport(TabSports, func() ([]string, []columnType, [][]string) {
+		return sportsTable(uiState.sportsListProcessed)
+	})
+
+showTable("##sportsTable", []string{"", "Код", "Название", "Тип", "Состав команды"},
 		uiState.sportsListProcessed, func(s *Sport) {
+			editing := uiState.sportEditCode == s.Code
+
 			imgui.TableNextRow()
 			imgui.TableNextColumn()
-			if imgui.Button("x") {
-				deleteSport(s.Code)
-				uiState.sportsList, _ = getSports()
-				uiState.sportsDirty = true
+			switch rowActions(editing) {
+			case rowDelete:
+				code := s.Code
+				change(TabSports, func(s *cachedStore, ctx context.Context) error {
+					return s.deleteSport(ctx, code)
+				}, nil)
+			case rowEdit:
+				uiState.sportEditCode = s.Code
+				uiState.sportEdit = *s
+			case rowSave:
+				oldCode, e := s.Code, uiState.sportEdit
+				change(TabSports, func(s *cachedStore, ctx context.Context) error {
+					return s.updateSport(ctx, oldCode, e)
+				}, func() {
+					uiState.sportEditCode = ""
+				})
+			case rowCancel:
+				uiState.sportEditCode = ""
 			}
+
+			if editing {
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				imgui.InputTextWithHint("##editCode", "Код", &uiState.sportEdit.Code, 0, nil)
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				imgui.InputTextWithHint("##editName", "Название", &uiState.sportEdit.Name, 0, nil)
+				imgui.TableNextColumn()
+				imgui.Checkbox("Командный", &uiState.sportEdit.IsTeam)
+				imgui.TableNextColumn()
+				if uiState.sportEdit.IsTeam {
+					editTeamRules(&uiState.sportEdit, imgui.ContentRegionAvail().X/3)
+				}
+			} else {
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(s.Code)
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(s.Name)
+				imgui.TableNextColumn()
+				if s.IsTeam {
+					imgui.TextUnformatted("Командный")
+				} else {
+					imgui.TextUnformatted("Одиночный")
+				}
+				imgui.TableNextColumn()
+				if s.IsTeam {
+					imgui.TextUnformatted(teamRulesText(s))
+				}
+			}
+		})
+}
+
+func teamGenderName(gender string) string {
+	switch gender {
+	case "M": return "мужские"
+	case "F": return "женские"
+	default: return "смешанные"
+	}
+}
+
+// Ограничения на состав команд: число игроков (0 — без ограничения) и пол
+func editTeamRules(sport *Sport, width float32) {
+	minPlayers, maxPlayers := int32(sport.MinPlayers), int32(sport.MaxPlayers)
+	imgui.SetNextItemWidth(width)
+	if imgui.InputInt("от##minPlayers", &minPlayers) {
+		sport.MinPlayers = max(int(minPlayers), 0)
+	}
+	imgui.SameLine()
+	imgui.SetNextItemWidth(width)
+	if imgui.InputInt("до##maxPlayers", &maxPlayers) {
+		sport.MaxPlayers = max(int(maxPlayers), 0)
+	}
+	imgui.SameLine()
+	imgui.SetNextItemWidth(width)
+	if imgui.BeginCombo("##teamGender", teamGenderName(sport.TeamGender)) {
+		for _, gender := range []string{"", "M", "F"} {
+			if imgui.SelectableBool(teamGenderName(gender)) {
+				sport.TeamGender = gender
+			}
+		}
+		imgui.EndCombo()
+	}
+}
+
+func teamRulesText(s *Sport) string {
+	var players string
+	switch {
+	case s.MinPlayers > 0 && s.MaxPlayers > 0: players = fmt.Sprintf("%d-%d игроков", s.MinPlayers, s.MaxPlayers)
+	case s.MinPlayers > 0: players = fmt.Sprintf("от %d игроков", s.MinPlayers)
+	case s.MaxPlayers > 0: players = fmt.Sprintf("до %d игроков", s.MaxPlayers)
+	default: players = "любое число игроков"
+	}
+	return players + ", " + teamGenderName(s.TeamGender)
+}
+
+func processEvents() {
+	uiState.eventsListProcessed = make([]*Event, 0, len(uiState.eventsList))
+
+	for i := range uiState.eventsList {
+		e := &uiState.eventsList[i]
+		if uiState.eventSportFilter.Code != "" && uiState.eventSportFilter.Code != e.SportCode {
+			continue
+		}
+		if len(uiState.eventNameFilter) > 0 && !strings.Contains(e.Name, uiState.eventNameFilter) {
+			continue
+		}
+		uiState.eventsListProcessed = append(uiState.eventsListProcessed, e)
+	}
+}
+
+func showEvents(switched bool) {
+	if switched {
+		uiState.eventsDirty = true
+	}
+
+	avail := imgui.ContentRegionAvail()
+	imgui.SetNextItemWidth(avail.X / 6)
+	pickSport(&uiState.eventSportInput, "##pickSportCombo")
+	imgui.SameLine()
+	imgui.SetNextItemWidth(avail.X / 4)
+	imgui.InputTextWithHint("##eventNameInput", "Название дисциплины", &uiState.eventInput.Name, 0, nil)
+	imgui.SameLine()
+	editEventRules(&uiState.eventInput, avail.X/10)
+	imgui.SameLine()
+	if imgui.Button("Добавить") {
+		event := uiState.eventInput
+		event.SportCode = uiState.eventSportInput.Code
+		change(TabEvents, func(s *cachedStore, ctx context.Context) error {
+			return s.addEvent(ctx, event)
+		}, nil)
+	}
+
+	imgui.Separator()
+	imgui.TextUnformatted("Фильтр")
+
+	imgui.SameLine()
+	imgui.SetNextItemWidth(avail.X / 4)
+	if pickSport(&uiState.eventSportFilter, "##pickSportComboFilter") {
+		uiState.eventsDirty = true
+	}
+	imgui.SameLine()
+	if imgui.Button("x##clearSportFilter") {
+		uiState.eventSportFilter = Sport{}
+		uiState.eventsDirty = true
+	}
+	imgui.SameLine()
+	imgui.SetNextItemWidth(avail.X / 4)
+	if imgui.InputTextWithHint("##eventNameFilter", "Название", &uiState.eventNameFilter, 0, nil) {
+		uiState.eventsDirty = true
+	}
+
+	if uiState.eventsDirty {
+		uiState.eventsDirty = false
+		load(TabEvents, (*cachedStore).getEvents, func(events []Event) {
+			uiState.eventsList = events
+			processEvents()
+		})
+	}
+
+	showExport(TabEvents, func() ([]string, []columnType, [][]string) {
+		return eventsTable(uiState.eventsListProcessed)
+	})
+
+showTable("##eventsTable", []string{"", "Вид спорта", "Название", "Условия участия"},
+		uiState.eventsListProcessed, func(e *Event) {
+			editing := uiState.eventEditID == e.ID
+
+			imgui.TableNextRow()
 			imgui.TableNextColumn()
-			imgui.TextUnformatted(s.Code)
-			imgui.TableNextColumn()
-			imgui.TextUnformatted(s.Name)
+			switch rowActions(editing) {
+			case rowDelete:
+				id := e.ID
+				change(TabEvents, func(s *cachedStore, ctx context.Context) error {
+					return s.deleteEvent(ctx, id)
+				}, nil)
+			case rowEdit:
+				uiState.eventEditID = e.ID
+				uiState.eventEdit = *e
+			case rowSave:
+				edited := uiState.eventEdit
+				change(TabEvents, func(s *cachedStore, ctx context.Context) error {
+					return s.updateEvent(ctx, edited)
+				}, func() {
+					uiState.eventEditID = 0
+				})
+			case rowCancel:
+				uiState.eventEditID = 0
+			}
+
+			// Вид спорта у дисциплины не меняется
 			imgui.TableNextColumn()
-			if s.IsTeam {
-				imgui.TextUnformatted("Командный")
+			imgui.TextUnformatted(e.SportCode)
+			if editing {
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				imgui.InputTextWithHint("##editName", "Название", &uiState.eventEdit.Name, 0, nil)
+				imgui.TableNextColumn()
+				editEventRules(&uiState.eventEdit, imgui.ContentRegionAvail().X/4)
 			} else {
-				imgui.TextUnformatted("Одиночный")
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(e.Name)
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(e.conditions())
 			}
 		})
 }
 
+func eventGenderName(gender string) string {
+	switch gender {
+	case "M": return "мужчины"
+	case "F": return "женщины"
+	default: return "все"
+	}
+}
+
+// Условия участия в дисциплине: пол, возраст (0 — без ограничения) и
+// весовая категория
+func editEventRules(event *Event, width float32) {
+	imgui.SetNextItemWidth(width)
+	if imgui.BeginCombo("##eventGender", eventGenderName(event.Gender)) {
+		for _, gender := range []string{"", "M", "F"} {
+			if imgui.SelectableBool(eventGenderName(gender)) {
+				event.Gender = gender
+			}
+		}
+		imgui.EndCombo()
+	}
+	minAge, maxAge := int32(event.MinAge), int32(event.MaxAge)
+	imgui.SameLine()
+	imgui.SetNextItemWidth(width)
+	if imgui.InputInt("лет от##minAge", &minAge) {
+		event.MinAge = max(int(minAge), 0)
+	}
+	imgui.SameLine()
+	imgui.SetNextItemWidth(width)
+	if imgui.InputInt("до##maxAge", &maxAge) {
+		event.MaxAge = max(int(maxAge), 0)
+	}
+	imgui.SameLine()
+	imgui.SetNextItemWidth(width)
+	imgui.InputTextWithHint("##weightClass", "Весовая категория", &event.WeightClass, 0, nil)
+}
+
 func processCountries() {
 	uiState.countriesListProcessed = make([]*Country, 0, len(uiState.countriesList))
 
@@ -674,7 +1795,6 @@ func processCountries() {
 
 func showCountries(switched bool) {
 	if switched {
-		uiState.countriesList, _ = getCountries()
 		uiState.countriesDirty = true
 	}
 
@@ -687,12 +1807,10 @@ func showCountries(switched bool) {
 	imgui.SameLine()
 	imgui.SetNextItemWidth(avail.X / 1)
 	if imgui.Button("Добавить") {
-		if err := addCountry(uiState.countryCodeInput, uiState.countryNameInput); err != nil {
-			showError(err)
-		} else {
-			uiState.countriesList, _ = getCountries()
-			uiState.countriesDirty = true
-		}
+		code, name := uiState.countryCodeInput, uiState.countryNameInput
+		change(TabCountries, func(s *cachedStore, ctx context.Context) error {
+			return s.addCountry(ctx, code, name)
+		}, nil)
 	}
 
 	imgui.Separator()
@@ -726,22 +1844,55 @@ func showCountries(switched bool) {
 
 	if uiState.countriesDirty {
 		uiState.countriesDirty = false
-		processCountries()
+		load(TabCountries, (*cachedStore).getCountries, func(countries []Country) {
+			uiState.countriesList = countries
+			processCountries()
+		})
 	}
 
-	showTable("##countriesTable", []string{"", "Код", "Название"},
+	showExport(TabCountries, func() ([]string, []columnType, [][]string) {
+		return countriesTable(uiState.countriesListProcessed)
+	})
+
+showTable("##countriesTable", []string{"", "Код", "Название"},
 		uiState.countriesListProcessed, func(c *Country) {
+			editing := uiState.countryEditCode == c.Code
+
 			imgui.TableNextRow()
 			imgui.TableNextColumn()
-			if imgui.Button("x") {
-				deleteCountry(c.Code)
-				uiState.countriesList, _ = getCountries()
-				uiState.countriesDirty = true
+			switch rowActions(editing) {
+			case rowDelete:
+				code := c.Code
+				change(TabCountries, func(s *cachedStore, ctx context.Context) error {
+					return s.deleteCountry(ctx, code)
+				}, nil)
+			case rowEdit:
+				uiState.countryEditCode = c.Code
+				uiState.countryEdit = *c
+			case rowSave:
+				oldCode, e := c.Code, uiState.countryEdit
+				change(TabCountries, func(s *cachedStore, ctx context.Context) error {
+					return s.updateCountry(ctx, oldCode, e.Code, e.Name)
+				}, func() {
+					uiState.countryEditCode = ""
+				})
+			case rowCancel:
+				uiState.countryEditCode = ""
+			}
+
+			if editing {
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				imgui.InputTextWithHint("##editCode", "Код", &uiState.countryEdit.Code, 0, nil)
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				imgui.InputTextWithHint("##editName", "Название", &uiState.countryEdit.Name, 0, nil)
+			} else {
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(c.Code)
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(c.Name)
 			}
-			imgui.TableNextColumn()
-			imgui.TextUnformatted(c.Code)
-			imgui.TableNextColumn()
-			imgui.TextUnformatted(c.Name)
 		})
 }
 
@@ -760,12 +1911,17 @@ func processMedals() {
 
 func showMedals(switched bool) {
     if switched {
-        medals, err := getCountryMedals()
-        if err != nil {
-            showError(err)
-        } else {
-            uiState.medalsList = medals
-        }
+        uiState.medalsDirty = true
+    }
+    if imgui.Checkbox("По дисциплинам", &uiState.medalsByEvent) {
+        uiState.medalsDirty = true
+    }
+    if uiState.medalsByEvent {
+        showEventMedals()
+        return
+    }
+    imgui.SameLine()
+    if imgui.Checkbox("Показывать страны без медалей", &uiState.medalsWithoutMedals) {
         uiState.medalsDirty = true
     }
 
@@ -826,29 +1982,21 @@ func showMedals(switched bool) {
         uiState.medalsDirty = true
     }
 
-   imgui.TextUnformatted("Экспорт отчёта:")
-   imgui.SameLine()
-
-   imgui.SetNextItemWidth(imgui.ContentRegionAvail().X / 4)
-   imgui.InputTextWithHint("##medalsReportPath", "Путь", &uiState.medalsReportPath, 0, nil)
-   imgui.SameLine()
-
-   if imgui.Button("Сформировать отчёт") {
-       if len(uiState.medalsListProcessed) == 0 {
-           showError(fmt.Errorf("нет данных для экспорта"))
-       } else {
-           err := exportMedalsToCSV(uiState.medalsReportPath, uiState.medalsListProcessed)
-           if err != nil {
-               showError(fmt.Errorf("не удалось сформировать отчёт: %w", err))
-           }
-       }
-   }
-
    if uiState.medalsDirty {
        uiState.medalsDirty = false
-       processMedals()
+       withoutMedals := uiState.medalsWithoutMedals
+       load(TabMedals, func(s *cachedStore, ctx context.Context) ([]CountryMedals, error) {
+           return s.getCountryMedals(ctx, withoutMedals)
+       }, func(medals []CountryMedals) {
+           uiState.medalsList = medals
+           processMedals()
+       })
    }
 
+   showExport(TabMedals, func() ([]string, []columnType, [][]string) {
+       return medalsTable(uiState.medalsListProcessed)
+   })
+
    showTable("##medalsTable", []string{"Страна", "Золото", "Серебро", "Бронза", "Всего"},
        uiState.medalsListProcessed, func(m *CountryMedals) {
            imgui.TableNextRow()
@@ -865,56 +2013,76 @@ func showMedals(switched bool) {
        })
 }
 
-func exportMedalsToCSV(filePath string, medals []*CountryMedals) error {
-    file, err := os.Create(filePath)
-    if err != nil {
-        return fmt.Errorf("не удалось создать файл: %w", err)
-    }
-    defer file.Close()
-
-    writer := csv.NewWriter(file)
-    defer writer.Flush()
-
-    header := []string{"Страна", "Золото", "Серебро", "Бронза", "Всего"}
-    if err := writer.Write(header); err != nil {
-        return fmt.Errorf("не удалось записать заголовок: %w", err)
-    }
-
-    for _, medal := range medals {
-        record := []string{
-            medal.Country,
-            fmt.Sprintf("%d", medal.Gold),
-            fmt.Sprintf("%d", medal.Silver),
-            fmt.Sprintf("%d", medal.Bronze),
-            fmt.Sprintf("%d", medal.Total),
-        }
-        if err := writer.Write(record); err != nil {
-            return fmt.Errorf("не удалось записать строку для страны %s: %w", medal.Country, err)
-        }
-    }
-
-    if err := writer.Error(); err != nil {
-        return fmt.Errorf("ошибка при записи в CSV: %w", err)
+// Призёры каждой дисциплины, в порядке вида спорта, дисциплины и места
+func showEventMedals() {
+    if uiState.medalsDirty {
+        uiState.medalsDirty = false
+        load(TabMedals, (*cachedStore).getEventMedals, func(medals []EventMedal) {
+            uiState.eventMedalsList = medals
+            uiState.eventMedalsListProcessed = make([]*EventMedal, len(medals))
+            for i := range uiState.eventMedalsList {
+                uiState.eventMedalsListProcessed[i] = &uiState.eventMedalsList[i]
+            }
+        })
     }
 
-    return nil
+    showExport(TabMedals, func() ([]string, []columnType, [][]string) {
+        return eventMedalsTable(uiState.eventMedalsListProcessed)
+    })
+
+    showTable("##eventMedalsTable", []string{"Вид спорта", "Дисциплина", "Место", "Участник", "Страна"},
+        uiState.eventMedalsListProcessed, func(m *EventMedal) {
+            imgui.TableNextRow()
+            imgui.TableNextColumn()
+            imgui.TextUnformatted(m.SportName)
+            imgui.TableNextColumn()
+            imgui.TextUnformatted(m.Event.Name)
+            if conditions := m.Event.conditions(); conditions != "" {
+                imgui.SetItemTooltip(conditions)
+            }
+            imgui.TableNextColumn()
+            imgui.TextUnformatted(fmt.Sprintf("%d", m.Place))
+            imgui.TableNextColumn()
+            imgui.TextUnformatted(m.ParticipantName)
+            imgui.TableNextColumn()
+            imgui.TextUnformatted(m.CountryName)
+        })
 }
 
-func processTeams() {
-	uiState.teamsListProcessed = make([]*Team, 0, len(uiState.teamsList))
+func filterTeams(teams []Team) []*Team {
+	filtered := make([]*Team, 0, len(teams))
 
-	for i := range uiState.teamsList {
-		t := &uiState.teamsList[i]
+	for i := range teams {
+		t := &teams[i]
 		if len(uiState.teamNameFilter) > 0 && !strings.Contains(t.Name, uiState.teamNameFilter) {
 			continue
 		}
-		uiState.teamsListProcessed = append(uiState.teamsListProcessed, t)
+		filtered = append(filtered, t)
 	}
+	return filtered
+}
+
+func processTeams() {
+	uiState.teamsListProcessed = filterTeams(uiState.teamsList)
+}
+
+// Состав команды из кэша, при первом раскрытии загружается из базы.
+// Пока идёт загрузка, состав пустой
+func teamMembers(teamID int) []Athlete {
+	members, ok := uiState.teamMembers[teamID]
+	if !ok {
+		uiState.teamMembers[teamID] = nil
+		load(TabTeams, func(s *cachedStore, ctx context.Context) ([]Athlete, error) {
+			return s.getTeamMembers(ctx, teamID)
+		}, func(members []Athlete) {
+			uiState.teamMembers[teamID] = members
+		})
+	}
+	return members
 }
 
 func showTeams(switched bool) {
 	if switched {
-		uiState.teamsList, _ = getTeams()
 		uiState.teamsDirty = true
 		if uiState.teamMemberSelection == nil {
 			uiState.teamMemberSelection = make(map[int]int)
@@ -929,17 +2097,14 @@ func showTeams(switched bool) {
 	pickCountry(&uiState.teamCountryInput)
 	imgui.SameLine()
 	imgui.SetNextItemWidth(avail.X / 4)
-	pickSport(&uiState.teamSportInput, "##pickSportCombo")
+	pickTeamSport(&uiState.teamSportInput, "##pickSportCombo")
 	imgui.SameLine()
 	imgui.SetNextItemWidth(avail.X / 1)
 	if imgui.Button("Добавить") {
-		err := addTeam(uiState.teamNameInput, uiState.teamCountryInput.Code, uiState.teamSportInput.Code)
-		if err != nil {
-			showError(err)
-		} else {
-			uiState.teamsList, _ = getTeams()
-			uiState.teamsDirty = true
-		}
+		name, countryCode, sportCode := uiState.teamNameInput, uiState.teamCountryInput.Code, uiState.teamSportInput.Code
+		change(TabTeams, func(s *cachedStore, ctx context.Context) error {
+			return s.addTeam(ctx, name, countryCode, sportCode)
+		}, nil)
 	}
 
 	imgui.Separator()
@@ -952,40 +2117,90 @@ func showTeams(switched bool) {
 
 	if uiState.teamsDirty {
 		uiState.teamsDirty = false
-		processTeams()
+		load(TabTeams, func(s *cachedStore, ctx context.Context) ([]Team, error) {
+			return s.getTeams(ctx, false)
+		}, func(teams []Team) {
+			uiState.teamsList = teams
+			processTeams()
+		})
 	}
 
-	allAthletes, _ := getAthletes()
+	// В списке составов нет, а в выгрузке они нужны
+	if path, format, ok := showExportButton(TabTeams); ok {
+		load(TabTeams, func(s *cachedStore, ctx context.Context) ([]Team, error) {
+			return s.getTeams(ctx, true)
+		}, func(teams []Team) {
+			headers, types, rows := teamsTable(filterTeams(teams))
+			exportTable(path, format, headers, types, rows)
+		})
+	}
+
+	allAthletes := uiState.refAthletes
 
-	showTable("##teamsTable", []string{"", "Название", "Страна", "Вид спорта", "Участники"},
+	showTable("##teamsTable", []string{"", "Название", "Страна", "Вид спорта", "Состав", "Участники"},
 		uiState.teamsListProcessed, func(t *Team) {
+			editing := uiState.teamEditID == t.ID
+
 			imgui.TableNextRow()
 			imgui.TableNextColumn()
-			if imgui.Button("x") {
-				deleteTeam(t.ID)
-				uiState.teamsList, _ = getTeams()
-				uiState.teamsDirty = true
+			switch rowActions(editing) {
+			case rowDelete:
+				id := t.ID
+				change(TabTeams, func(s *cachedStore, ctx context.Context) error {
+					return s.deleteTeam(ctx, id)
+				}, nil)
+			case rowEdit:
+				uiState.teamEditID = t.ID
+				uiState.teamEditName = t.Name
+				uiState.teamEditCountry = t.Country
+				uiState.teamEditSport = t.Sport
+			case rowSave:
+				id, name := t.ID, uiState.teamEditName
+				countryCode, sportCode := uiState.teamEditCountry.Code, uiState.teamEditSport.Code
+				change(TabTeams, func(s *cachedStore, ctx context.Context) error {
+					return s.updateTeam(ctx, id, name, countryCode, sportCode)
+				}, func() {
+					uiState.teamEditID = 0
+				})
+			case rowCancel:
+				uiState.teamEditID = 0
+			}
+
+			if editing {
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				imgui.InputTextWithHint("##editName", "Название команды", &uiState.teamEditName, 0, nil)
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				pickCountry(&uiState.teamEditCountry)
+				imgui.TableNextColumn()
+				imgui.SetNextItemWidth(-1)
+				pickTeamSport(&uiState.teamEditSport, "##editSport")
+			} else {
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(t.Name)
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(t.Country.Name)
+				imgui.TableNextColumn()
+				imgui.TextUnformatted(t.Sport.Name)
 			}
 			imgui.TableNextColumn()
-			imgui.TextUnformatted(t.Name)
-			imgui.TableNextColumn()
-			imgui.TextUnformatted(t.Country.Name)
-			imgui.TableNextColumn()
-			imgui.TextUnformatted(t.Sport.Name)
+			if problem := t.rosterProblem(); problem != "" {
+				imgui.TextColored(imgui.Vec4{X: 1, Y: 0.3, Z: 0.3, W: 1}, problem)
+			} else {
+				imgui.TextColored(imgui.Vec4{X: 0.3, Y: 1, Z: 0.3, W: 1}, "в порядке")
+			}
 			imgui.TableNextColumn()
 
 			label := fmt.Sprintf("members_%d", t.ID)
-			countLabel := fmt.Sprintf("Участники (%d)", len(t.Members))
+			countLabel := fmt.Sprintf("Участники (%d)", t.MemberCount)
 			if imgui.TreeNodeStr(fmt.Sprintf("%s##%s", countLabel, label)) {
-				for i := range t.Members {
-					m := t.Members[i]
+				for _, m := range teamMembers(t.ID) {
 					if imgui.Button(fmt.Sprintf("X##%d_%d", t.ID, m.ID)) {
-						if err := deleteAthleteFromTeam(t.ID, m.ID); err != nil {
-							showError(err)
-						} else {
-							uiState.teamsList, _ = getTeams()
-							uiState.teamsDirty = true
-						}
+						teamID, athleteID := t.ID, m.ID
+						change(TabTeams, func(s *cachedStore, ctx context.Context) error {
+							return s.deleteAthleteFromTeam(ctx, teamID, athleteID)
+						}, nil)
 					}
 					imgui.SameLine()
 					imgui.TextUnformatted(m.Name)
@@ -995,12 +2210,10 @@ func showTeams(switched bool) {
 					if sel == 0 {
 						showError(fmt.Errorf("Не выбран спортсмен"))
 					} else {
-						if err := addAthleteToTeam(t.ID, sel); err != nil {
-							showError(err)
-						} else {
-							uiState.teamsList, _ = getTeams()
-							uiState.teamsDirty = true
-						}
+						teamID := t.ID
+						change(TabTeams, func(s *cachedStore, ctx context.Context) error {
+							return s.addAthleteToTeam(ctx, teamID, sel)
+						}, nil)
 					}
 				}
 				imgui.SameLine()
@@ -1015,6 +2228,10 @@ func showTeams(switched bool) {
 				}
 				if imgui.BeginCombo(comboLabel, selectedName) {
 					for _, a := range allAthletes {
+						// Спортсменов другого пола база в команду не пустит
+						if t.Sport.TeamGender != "" && a.Gender != t.Sport.TeamGender {
+							continue
+						}
 						if imgui.SelectableBool(a.Name) {
 							uiState.teamMemberSelection[t.ID] = a.ID
 						}
@@ -1026,14 +2243,632 @@ func showTeams(switched bool) {
 		})
 }
 
+// Открывает другую базу и сбрасывает всё, что интерфейс успел из неё
+// загрузить. Старая база закроется, когда воркер выполнит уже
+// отправленные в неё запросы
+func switchDatabase(path string, create bool) {
+	load(noTab, func(_ *cachedStore, ctx context.Context) (*sqliteStore, error) {
+		return openSQLiteStore(ctx, path, create)
+	}, func(store *sqliteStore) {
+		uiState.worker.stop()
+		addRecentFile(path)
+		uiState = UIState{}
+		initUI(store)
+	})
+}
+
+var errNoDatabaseFile = fmt.Errorf("Недоступно: данные хранятся не в файле базы")
+
+// Отмена, журнал аудита, импорт, дампы и снимки есть только у базы
+// в файле, с другим хранилищем (memStore) они недоступны. Вызывается
+// в горутине воркера
+func sqliteStoreOrError(s *cachedStore) (*sqliteStore, error) {
+	if store, ok := s.Store.(*sqliteStore); ok {
+		return store, nil
+	}
+	return nil, errNoDatabaseFile
+}
+
+func resetImportResults() {
+	uiState.importResults = nil
+	uiState.importFailed = 0
+	uiState.importDone = false
+}
+
+func countImportErrors(results []ImportRowResult) int {
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+func runImport(dryRun bool) {
+	// Сопоставление столбцов меняется в интерфейсе, пока идёт импорт
+	entity, records, mapping := uiState.importEntity, uiState.importRecords, slices.Clone(uiState.importMapping)
+	load(TabImport, func(s *cachedStore, ctx context.Context) ([]ImportRowResult, error) {
+		store, err := sqliteStoreOrError(s)
+		if err != nil {
+			return nil, err
+		}
+		results, err := store.importCSV(ctx, entity, records, mapping, dryRun)
+		if err == nil && !dryRun && countImportErrors(results) == 0 {
+			s.notify(DataAll)
+		}
+		return results, err
+	}, func(results []ImportRowResult) {
+		uiState.importResults = results
+		uiState.importFailed = countImportErrors(results)
+		uiState.importDone = !dryRun && uiState.importFailed == 0
+	})
+}
+
+func showImport(switched bool) {
+	imgui.Text("Сущность")
+	imgui.SameLine()
+	imgui.SetNextItemWidth(imgui.ContentRegionAvail().X / 4)
+	if imgui.BeginCombo("##importEntity", importEntityName(uiState.importEntity)) {
+		for _, e := range importEntities {
+			if imgui.SelectableBool(importEntityName(e)) {
+				uiState.importEntity = e
+				uiState.importMapping = guessImportMapping(e, uiState.importHeaders)
+				resetImportResults()
+			}
+		}
+		imgui.EndCombo()
+	}
+
+	imgui.SameLine()
+	imgui.SetNextItemWidth(imgui.ContentRegionAvail().X / 2)
+	imgui.InputTextWithHint("##importPath", "Путь к файлу CSV", &uiState.importPath, 0, nil)
+	imgui.SameLine()
+	if imgui.Button("Загрузить") {
+		headers, records, err := readCSV(uiState.importPath)
+		if err != nil {
+			showError(err)
+		} else {
+			uiState.importHeaders = headers
+			uiState.importRecords = records
+			uiState.importMapping = guessImportMapping(uiState.importEntity, headers)
+			resetImportResults()
+		}
+	}
+
+	if uiState.importHeaders == nil {
+		imgui.TextDisabled("Загрузите файл CSV, первая строка — заголовки столбцов")
+		return
+	}
+
+	imgui.Separator()
+	imgui.Text("Столбцы")
+	for i, f := range importFields[uiState.importEntity] {
+		title := f.Title
+		if f.Required {
+			title += " *"
+		}
+		imgui.TextUnformatted(title)
+		if help := importFieldHelp(f); help != "" {
+			imgui.SetItemTooltip(help)
+		}
+		imgui.SameLine()
+
+		preview := "—"
+		if uiState.importMapping[i] >= 0 {
+			preview = uiState.importHeaders[uiState.importMapping[i]]
+		}
+		imgui.SetNextItemWidth(imgui.ContentRegionAvail().X / 3)
+		if imgui.BeginCombo(fmt.Sprintf("##importColumn_%s", f.Name), preview) {
+			if imgui.SelectableBool("—") {
+				uiState.importMapping[i] = -1
+				resetImportResults()
+			}
+			for j, h := range uiState.importHeaders {
+				if imgui.SelectableBool(fmt.Sprintf("%s##%d", h, j)) {
+					uiState.importMapping[i] = j
+					resetImportResults()
+				}
+			}
+			imgui.EndCombo()
+		}
+	}
+
+	imgui.Separator()
+	if imgui.Button("Проверить") {
+		runImport(true)
+	}
+	imgui.SameLine()
+	if imgui.Button("Импортировать") {
+		runImport(false)
+	}
+	imgui.SameLine()
+	switch {
+	case uiState.importResults == nil:
+		imgui.TextDisabled(fmt.Sprintf("Строк в файле: %d", len(uiState.importRecords)))
+	case uiState.importFailed > 0:
+		imgui.TextColored(imgui.Vec4{X: 1, Y: 0.3, Z: 0.3, W: 1},
+			fmt.Sprintf("Строк с ошибками: %d из %d, ничего не импортировано",
+				uiState.importFailed, len(uiState.importResults)))
+	case uiState.importDone:
+		imgui.TextUnformatted(fmt.Sprintf("Импортировано строк: %d", len(uiState.importResults)))
+	default:
+		imgui.TextUnformatted(fmt.Sprintf("Ошибок нет, можно импортировать %d строк", len(uiState.importResults)))
+	}
+
+	// До проверки показываем сами строки, после — и результат по каждой
+	headers := append([]string{"Строка", "Проверка"}, uiState.importHeaders...)
+	lines := make([]int, len(uiState.importRecords))
+	for i := range lines {
+		lines[i] = i
+	}
+	showTable("##importTable", headers, lines, func(i int) {
+		imgui.TableNextRow()
+		imgui.TableNextColumn()
+		imgui.TextUnformatted(fmt.Sprintf("%d", i+2))
+
+		imgui.TableNextColumn()
+		if i < len(uiState.importResults) {
+			if err := uiState.importResults[i].Err; err != nil {
+				imgui.TextColored(imgui.Vec4{X: 1, Y: 0.3, Z: 0.3, W: 1}, err.Error())
+			} else {
+				imgui.TextUnformatted("OK")
+			}
+		}
+
+		for j, v := range uiState.importRecords[i] {
+			if j == len(uiState.importHeaders) {
+				break
+			}
+			imgui.TableNextColumn()
+			imgui.TextUnformatted(v)
+		}
+	})
+}
+
+func processHistory() {
+	var filter AuditFilter
+	filter.Table = uiState.auditFilterTable
+	filter.Action = uiState.auditFilterAction
+	filter.Operator = uiState.auditFilterOperator
+	filter.Search = uiState.auditFilterSearch
+
+	var err error
+	if filter.From, err = parseAuditDate(uiState.auditFilterFrom); err != nil {
+		uiState.auditFilterError = err.Error()
+		return
+	}
+	if filter.To, err = parseAuditDate(uiState.auditFilterTo); err != nil {
+		uiState.auditFilterError = err.Error()
+		return
+	}
+	uiState.auditFilterError = ""
+
+	request(uiState.worker, TabHistory, func(s *cachedStore, ctx context.Context) ([]AuditEntry, error) {
+		store, err := sqliteStoreOrError(s)
+		if err != nil {
+			return nil, err
+		}
+		return store.getAuditLog(ctx, filter)
+	}, func(entries []AuditEntry, err error) {
+		if errors.Is(err, errNoDatabaseFile) {
+			uiState.auditFilterError = err.Error()
+			return
+		} else if err != nil {
+			showError(err)
+			return
+		}
+		uiState.auditList = entries
+		uiState.auditListProcessed = pointers(entries)
+	})
+}
+
+func showHistory(switched bool) {
+	if switched {
+		uiState.auditDirty = true
+	}
+
+	avail := imgui.ContentRegionAvail()
+	imgui.TextUnformatted("Оператор")
+	imgui.SameLine()
+	imgui.SetNextItemWidth(avail.X / 4)
+	imgui.InputTextWithHint("##operator", "Имя", &operator, 0, nil)
+	imgui.SameLine()
+	imgui.TextDisabled("этим именем подписываются ваши изменения")
+
+	imgui.Separator()
+	imgui.TextUnformatted("Фильтр")
+
+	imgui.SameLine()
+	imgui.SetNextItemWidth(avail.X / 7)
+	tablePreview := auditTableName(uiState.auditFilterTable)
+	if uiState.auditFilterTable == "" {
+		tablePreview = "Все таблицы"
+	}
+	if imgui.BeginCombo("##auditFilterTable", tablePreview) {
+		if imgui.SelectableBool("Все таблицы") {
+			uiState.auditFilterTable = ""
+			uiState.auditDirty = true
+		}
+		for _, t := range auditTables {
+			if imgui.SelectableBool(auditTableName(t)) {
+				uiState.auditFilterTable = t
+				uiState.auditDirty = true
+			}
+		}
+		imgui.EndCombo()
+	}
+
+	imgui.SameLine()
+	imgui.SetNextItemWidth(avail.X / 7)
+	actionPreview := auditActionName(uiState.auditFilterAction)
+	if uiState.auditFilterAction == "" {
+		actionPreview = "Все действия"
+	}
+	if imgui.BeginCombo("##auditFilterAction", actionPreview) {
+		if imgui.SelectableBool("Все действия") {
+			uiState.auditFilterAction = ""
+			uiState.auditDirty = true
+		}
+		for _, a := range auditActions {
+			if imgui.SelectableBool(auditActionName(a)) {
+				uiState.auditFilterAction = a
+				uiState.auditDirty = true
+			}
+		}
+		imgui.EndCombo()
+	}
+
+	imgui.SameLine()
+	imgui.SetNextItemWidth(avail.X / 7)
+	if imgui.InputTextWithHint("##auditFilterOperator", "Оператор", &uiState.auditFilterOperator, 0, nil) {
+		uiState.auditDirty = true
+	}
+	imgui.SameLine()
+	imgui.SetNextItemWidth(avail.X / 7)
+	if imgui.InputTextWithHint("##auditFilterSearch", "Значение", &uiState.auditFilterSearch, 0, nil) {
+		uiState.auditDirty = true
+	}
+	imgui.SameLine()
+	imgui.SetNextItemWidth(avail.X / 9)
+	if imgui.InputTextWithHint("##auditFilterFrom", "С ГГГГ-ММ-ДД", &uiState.auditFilterFrom, 0, nil) {
+		uiState.auditDirty = true
+	}
+	imgui.SameLine()
+	imgui.SetNextItemWidth(avail.X / 9)
+	if imgui.InputTextWithHint("##auditFilterTo", "По ГГГГ-ММ-ДД", &uiState.auditFilterTo, 0, nil) {
+		uiState.auditDirty = true
+	}
+	imgui.SameLine()
+	if imgui.Button("Обновить") {
+		uiState.auditDirty = true
+	}
+
+	if uiState.auditDirty {
+		uiState.auditDirty = false
+		processHistory()
+	}
+
+	// Недописанная дата — не повод для всплывающей ошибки на каждый символ
+	if uiState.auditFilterError != "" {
+		imgui.TextColored(imgui.Vec4{X: 1, Y: 0.3, Z: 0.3, W: 1}, uiState.auditFilterError)
+	}
+	if len(uiState.auditList) == maxAuditEntries {
+		imgui.TextDisabled(fmt.Sprintf("Показаны последние %d записей, уточните фильтр", maxAuditEntries))
+	}
+
+	showExport(TabHistory, func() ([]string, []columnType, [][]string) {
+		return auditTable(uiState.auditListProcessed)
+	})
+
+	showTable("##auditTable", []string{"Время", "Оператор", "Таблица", "Действие", "Было", "Стало"},
+		uiState.auditListProcessed, func(e *AuditEntry) {
+			oldValues, newValues := e.Changes()
+
+			imgui.TableNextRow()
+			imgui.TableNextColumn()
+			imgui.TextUnformatted(e.Time.Local().Format(time.DateTime))
+			imgui.TableNextColumn()
+			if e.Operator == "" {
+				imgui.TextDisabled("неизвестно")
+			} else {
+				imgui.TextUnformatted(e.Operator)
+			}
+			imgui.TableNextColumn()
+			imgui.TextUnformatted(auditTableName(e.Table))
+			imgui.TableNextColumn()
+			imgui.TextUnformatted(auditActionName(e.Action))
+			imgui.TableNextColumn()
+			imgui.TextUnformatted(oldValues)
+			imgui.TableNextColumn()
+			imgui.TextUnformatted(newValues)
+		})
+}
+
+func showMenuBar() {
+	if !imgui.BeginMenuBar() {
+		return
+	}
+
+	if imgui.BeginMenu("Файл") {
+		if imgui.MenuItemBool("Открыть или создать...") {
+			uiState.dbDialogRequested = true
+		}
+		if imgui.MenuItemBool("Дамп JSON...") {
+			uiState.dumpDialogRequested = true
+		}
+		imgui.Separator()
+		if imgui.MenuItemBool("Сделать снимок") {
+			load(noTab, func(s *cachedStore, ctx context.Context) (string, error) {
+				store, err := sqliteStoreOrError(s)
+				if err != nil {
+					return "", err
+				}
+				return store.snapshot(ctx, "manual")
+			}, func(string) {})
+		}
+		if imgui.BeginMenu("Восстановить из снимка") {
+			var snapshots []Snapshot
+			err := errNoDatabaseFile
+			if uiState.dbPath != "" {
+				snapshots, err = listSnapshots(uiState.dbPath)
+			}
+			if err != nil {
+				imgui.TextDisabled(err.Error())
+			} else if len(snapshots) == 0 {
+				imgui.TextDisabled("Снимков нет")
+			}
+			for _, sn := range snapshots {
+				if imgui.MenuItemBool(fmt.Sprintf("%s (%s)", sn.Time.Format(time.DateTime), sn.Reason)) {
+					path := sn.Path
+					change(noTab, func(s *cachedStore, ctx context.Context) error {
+						store, err := sqliteStoreOrError(s)
+						if err != nil {
+							return err
+						}
+						err = store.restoreBackup(ctx, path)
+						s.notify(DataAll)
+						return err
+					}, nil)
+				}
+			}
+			imgui.EndMenu()
+		}
+		if len(uiState.recentFiles) > 0 {
+			imgui.Separator()
+			imgui.TextDisabled("Недавние")
+			for _, path := range uiState.recentFiles {
+				if imgui.MenuItemBool(path) {
+					switchDatabase(path, false)
+				}
+			}
+		}
+		imgui.EndMenu()
+	}
+	if imgui.BeginMenu("Правка") {
+		if imgui.MenuItemBool("Отменить (Ctrl+Z)") {
+			undoRedo(false)
+		}
+		if imgui.MenuItemBool("Повторить (Ctrl+Y)") {
+			undoRedo(true)
+		}
+		if imgui.MenuItemBool("История изменений") {
+			uiState.undoHistoryOpen = true
+			reloadUndoHistory()
+		}
+		imgui.EndMenu()
+	}
+	if uiState.dbPath != "" {
+		imgui.TextDisabled(uiState.dbPath)
+	}
+	if uiState.worker.anyBusy() {
+		imgui.TextDisabled(spinner() + " Загрузка...")
+	}
+
+	imgui.EndMenuBar()
+}
+
+func showDatabaseDialog() {
+	if uiState.dbDialogRequested {
+		imgui.OpenPopupStr("База данных")
+		uiState.dbDialogRequested = false
+	}
+	if imgui.BeginPopupModalV("База данных", nil, imgui.WindowFlagsNoResize) {
+		imgui.InputTextWithHint("##dbDialogPath", "Путь к файлу", &uiState.dbDialogPath, 0, nil)
+		if imgui.Button("Открыть") {
+			imgui.CloseCurrentPopup()
+			switchDatabase(uiState.dbDialogPath, false)
+		}
+		imgui.SameLine()
+		if imgui.Button("Создать") {
+			if _, err := os.Stat(uiState.dbDialogPath); err == nil {
+				showError(fmt.Errorf("Файл %s уже существует", uiState.dbDialogPath))
+			} else {
+				imgui.CloseCurrentPopup()
+				switchDatabase(uiState.dbDialogPath, true)
+			}
+		}
+		imgui.SameLine()
+		if imgui.Button("Отмена") {
+			imgui.CloseCurrentPopup()
+		}
+		imgui.EndPopup()
+	}
+}
+
+func undoRedo(redoing bool) {
+	change(noTab, func(s *cachedStore, ctx context.Context) error {
+		store, err := sqliteStoreOrError(s)
+		if err != nil {
+			return err
+		}
+
+		if redoing {
+			_, err = store.redo(ctx)
+		} else {
+			_, err = store.undo(ctx)
+		}
+		s.notify(DataAll)
+		return err
+	}, nil)
+}
+
+func reloadUndoHistory() {
+	request(uiState.worker, noTab, func(s *cachedStore, ctx context.Context) ([]UndoStep, error) {
+		store, err := sqliteStoreOrError(s)
+		if err != nil {
+			return nil, err
+		}
+		return store.getUndoHistory(ctx)
+	}, func(history []UndoStep, err error) {
+		uiState.undoHistory = history
+	})
+}
+
+// Шаги истории, отменённые показаны серым. Отменять и повторять можно
+// только по порядку, поэтому выбрать шаг в списке нельзя
+func showUndoHistory() {
+	if !uiState.undoHistoryOpen {
+		return
+	}
+
+	imgui.SetNextWindowSizeV(imgui.Vec2{X: 600, Y: 400}, imgui.CondFirstUseEver)
+	if imgui.BeginV("История изменений###undoHistory", &uiState.undoHistoryOpen, 0) {
+		if imgui.Button("Отменить") {
+			undoRedo(false)
+		}
+		imgui.SameLine()
+		if imgui.Button("Повторить") {
+			undoRedo(true)
+		}
+		if len(uiState.undoHistory) == 0 {
+			imgui.TextDisabled("Изменений нет")
+		}
+		showTable("##undoHistoryTable", []string{"Время", "Изменение"},
+			uiState.undoHistory, func(s UndoStep) {
+				imgui.TableNextRow()
+				imgui.TableNextColumn()
+				t := s.Time.Local().Format(time.DateTime)
+				if s.Undone {
+					imgui.TextDisabled(t)
+					imgui.TableNextColumn()
+					imgui.TextDisabled(s.Description + " (отменено)")
+				} else {
+					imgui.TextUnformatted(t)
+					imgui.TableNextColumn()
+					imgui.TextUnformatted(s.Description)
+				}
+			})
+	}
+	imgui.End()
+}
+
+func showDumpDialog() {
+	if uiState.dumpDialogRequested {
+		imgui.OpenPopupStr("Дамп JSON")
+		uiState.dumpDialogRequested = false
+		if uiState.dumpDialogPolicy == "" {
+			uiState.dumpDialogPolicy = ConflictFail
+		}
+	}
+	if imgui.BeginPopupModalV("Дамп JSON", nil, imgui.WindowFlagsNoResize) {
+		imgui.InputTextWithHint("##dumpDialogPath", "Путь к файлу", &uiState.dumpDialogPath, 0, nil)
+
+		imgui.TextUnformatted("Если запись уже есть:")
+		for _, p := range conflictPolicies {
+			imgui.SameLine()
+			if imgui.RadioButtonBool(p.name(), uiState.dumpDialogPolicy == p) {
+				uiState.dumpDialogPolicy = p
+			}
+		}
+
+		path, policy := uiState.dumpDialogPath, uiState.dumpDialogPolicy
+		if imgui.Button("Сохранить") {
+			change(noTab, func(s *cachedStore, ctx context.Context) error {
+				store, err := sqliteStoreOrError(s)
+				if err != nil {
+					return err
+				}
+				return store.dumpToFile(ctx, path)
+			}, nil)
+			imgui.CloseCurrentPopup()
+		}
+		imgui.SameLine()
+		if imgui.Button("Загрузить") {
+			change(noTab, func(s *cachedStore, ctx context.Context) error {
+				store, err := sqliteStoreOrError(s)
+				if err != nil {
+					return err
+				}
+				if _, err := store.restoreFromFile(ctx, path, policy); err != nil {
+					return err
+				}
+				s.notify(DataAll)
+				return nil
+			}, nil)
+			imgui.CloseCurrentPopup()
+		}
+		imgui.SameLine()
+		if imgui.Button("Отмена") {
+			imgui.CloseCurrentPopup()
+		}
+		imgui.EndPopup()
+	}
+}
+
+// Перечитывает устаревшие справочники для выпадающих списков
+func reloadRefs() {
+	kinds := uiState.refsDirty
+	uiState.refsDirty = 0
+
+	if kinds&DataCountries != 0 {
+		load(noTab, (*cachedStore).getCountries, func(countries []Country) {
+			uiState.refCountries = countries
+		})
+	}
+	if kinds&DataSports != 0 {
+		load(noTab, (*cachedStore).getSports, func(sports []Sport) {
+			uiState.refSports = sports
+		})
+	}
+	if kinds&DataEvents != 0 {
+		load(noTab, (*cachedStore).getEvents, func(events []Event) {
+			uiState.refEvents = events
+		})
+	}
+	if kinds&DataSites != 0 {
+		load(noTab, (*cachedStore).getSites, func(sites []Site) {
+			uiState.refSites = sites
+		})
+	}
+	if kinds&DataAthletes != 0 {
+		load(noTab, (*cachedStore).getAthletes, func(athletes []Athlete) {
+			uiState.refAthletes = athletes
+		})
+	}
+	if kinds&DataTeams != 0 {
+		load(noTab, func(s *cachedStore, ctx context.Context) ([]Team, error) {
+			return s.getTeams(ctx, false)
+		}, func(teams []Team) {
+			uiState.refTeams = teams
+		})
+	}
+}
+
 func runUI() {
+	uiState.worker.processReplies()
+	reloadRefs()
+
 	imgui.SetNextWindowPos(imgui.Vec2{X: 0, Y: 0})
 	imgui.SetNextWindowSize(imgui.CurrentIO().DisplaySize())
-	imgui.BeginV("##mainWin", nil, imgui.WindowFlagsNoMove|imgui.WindowFlagsNoDecoration)
+	imgui.BeginV("##mainWin", nil, imgui.WindowFlagsNoMove|imgui.WindowFlagsNoDecoration|
+		imgui.WindowFlagsMenuBar|imgui.WindowFlagsNoBringToFrontOnFocus)
+
+	showMenuBar()
 
 	if imgui.BeginTabBar("##tabBar") {
 		for _, tab := range tabs {
-			if imgui.BeginTabItem(tab.name()) {
+			if imgui.BeginTabItem(tab.label()) {
 				tab.show()
 				imgui.EndTabItem()
 			}
@@ -1041,6 +2876,9 @@ func runUI() {
 		imgui.EndTabBar()
 	}
 
+	showDatabaseDialog()
+	showDumpDialog()
+
 	if uiState.hasError {
 		imgui.OpenPopupStr("Ошибка")
 		uiState.hasError = false
@@ -1054,10 +2892,99 @@ func runUI() {
 	}
 
 	imgui.End()
+
+	showAthleteProfile()
+	showAthleteConflicts()
+	showUndoHistory()
+
+	// В полях ввода Ctrl+Z отменяет ввод текста, а не изменения в базе
+	io := imgui.CurrentIO()
+	if io.KeyCtrl() && !io.WantTextInput() {
+		if imgui.IsKeyPressedBool(imgui.KeyZ) {
+			undoRedo(false)
+		} else if imgui.IsKeyPressedBool(imgui.KeyY) {
+			undoRedo(true)
+		}
+	}
 }
 
-func initUI() {
+// Помечает устаревшими списки, в которых показаны изменившиеся данные.
+// Вкладки перечитывают их, когда видят свой флаг *Dirty
+func dataChanged(kinds DataKind) {
+	uiState.refsDirty |= kinds
+	if kinds&DataCountries != 0 {
+		uiState.countriesDirty = true
+	}
+	if kinds&DataSports != 0 {
+		uiState.sportsDirty = true
+	}
+	if kinds&DataEvents != 0 {
+		uiState.eventsDirty = true
+	}
+	if kinds&DataAthletes != 0 {
+		uiState.athletesDirty = true
+	}
+	if kinds&DataSites != 0 {
+		uiState.sitesDirty = true
+	}
+	if kinds&(DataTeams|DataCountries|DataSports) != 0 {
+		uiState.teamsDirty = true
+		uiState.refsDirty |= DataTeams
+		uiState.teamMembers = make(map[int][]Athlete)
+	}
+	if kinds&(DataCompetitions|DataSports|DataEvents|DataSites) != 0 {
+		uiState.competitionsDirty = true
+	}
+	if kinds&(DataResults|DataCompetitions|DataEvents|DataAthletes|DataTeams|DataCountries) != 0 {
+		uiState.competitionResults = make(map[int][]Result)
+		uiState.stageQualifiers = make(map[int][]Result)
+		uiState.medalsDirty = true
+	}
+	// Любое изменение попадает в журналы
+	uiState.auditDirty = true
+	if uiState.undoHistoryOpen {
+		reloadUndoHistory()
+	}
+
+	if uiState.athleteProfileOpen {
+		id := uiState.athleteProfile.Athlete.ID
+		request(uiState.worker, TabAthletes, func(s *cachedStore, ctx context.Context) (AthleteProfile, error) {
+			return s.getAthleteProfile(ctx, id)
+		}, func(p AthleteProfile, err error) {
+			if err != nil {
+				// Спортсмена удалили
+				uiState.athleteProfileOpen = false
+			} else {
+				uiState.athleteProfile = p
+			}
+		})
+	}
+	if uiState.athleteConflictsOpen {
+		load(TabCompetitions, (*cachedStore).getAthleteConflicts, func(conflicts []AthleteConflict) {
+			uiState.athleteConflicts = conflicts
+		})
+	}
+}
+
+func initUI(store Store) {
+	uiState.worker = startWorker(store, dataChanged)
+	if s, ok := store.(*sqliteStore); ok {
+		uiState.dbPath = s.path
+	}
+	uiState.refsDirty = DataAll
 	uiState.oldTab = 100500
 	uiState.teamMemberSelection = make(map[int]int)
+	uiState.teamMembers = make(map[int][]Athlete)
+	uiState.competitionResults = make(map[int][]Result)
+	uiState.stageQualifiers = make(map[int][]Result)
+	uiState.resultParticipantSelection = make(map[int]int)
+	uiState.resultPlaceInput = make(map[int]int32)
+	uiState.resultPlaceEdit = make(map[resultKey]int32)
+	uiState.recentFiles = loadRecentFiles()
+	uiState.competitionStatusInput = StatusScheduled
+	uiState.competitionDurationInput = 120
+	uiState.importEntity = importEntities[0]
+	uiState.exportPaths = make(map[Tab]string)
+	uiState.exportFormat = exportFormats[0]
 }
 
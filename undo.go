@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Отмена и повтор изменений. На каждую таблицу с данными вешаются
+// триггеры, которые на каждое изменение строки пишут в undo_log запрос,
+// возвращающий её обратно. Так каскадные удаления и изменения попадают
+// в журнал сами, без участия кода. Функции, которые меняют данные,
+// через undoStep объединяют записанное в шаг с описанием.
+// Отмена шага выполняет его запросы в обратном порядке, а триггеры при
+// этом записывают запросы для повтора
+
+// Таблицы, изменения в которых можно отменить
+var undoTables = []string{
+	"countries", "sports", "events", "athletes", "sites", "teams", "team_members",
+	"competitions", "competition_athletes", "competition_teams",
+}
+
+// Более старые шаги удаляются из истории
+const maxUndoSteps = 100
+
+type UndoStep struct {
+	ID int
+	Description string
+	Time time.Time
+	Undone bool
+}
+
+// Триггеры журналов зависят от столбцов таблиц, поэтому перед миграцией
+// они удаляются, а после открытия базы создаются заново. prefix — undo
+// или audit
+func dropTriggers(ctx context.Context, tx *sql.Tx, prefix string) error {
+	rows, err := tx.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'trigger' AND name LIKE ? || '\_%' ESCAPE '\';`, prefix)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DROP TRIGGER %s;", name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func installUndoTriggers(ctx context.Context, d *sql.DB) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := dropTriggers(ctx, tx, "undo"); err != nil {
+		return err
+	}
+
+	for _, table := range undoTables {
+		columns, hasRowidAlias, err := tableColumns(ctx, tx, table)
+		if err != nil {
+			return err
+		}
+		for _, trigger := range undoTriggers(table, columns, hasRowidAlias) {
+			if _, err := tx.ExecContext(ctx, trigger); err != nil {
+				return fmt.Errorf("failed to create undo trigger on %s: %s", table, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// hasRowidAlias — первичный ключ INTEGER PRIMARY KEY, то есть он и есть
+// rowid и отдельно rowid при вставке указывать нельзя
+func tableColumns(ctx context.Context, tx *sql.Tx, table string) ([]string, bool, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s);", table))
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	pkColumns := 0
+	intPK := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, false, err
+		}
+		columns = append(columns, name)
+		if pk > 0 {
+			pkColumns++
+			intPK = strings.EqualFold(typ, "INTEGER")
+		}
+	}
+
+	return columns, pkColumns == 1 && intPK, rows.Err()
+}
+
+// Строки сохраняются по rowid: он не меняется при смене кода страны
+// или вида спорта, в отличие от первичного ключа
+func undoTriggers(table string, columns []string, hasRowidAlias bool) []string {
+	var set, values []string
+	for _, c := range columns {
+		set = append(set, fmt.Sprintf("%s = ' || quote(OLD.%s) || '", c, c))
+		values = append(values, fmt.Sprintf("' || quote(OLD.%s) || '", c))
+	}
+
+	insertColumns := strings.Join(columns, ", ")
+	insertValues := strings.Join(values, ", ")
+	if !hasRowidAlias {
+		insertColumns = "rowid, " + insertColumns
+		insertValues = "' || OLD.rowid || ', " + insertValues
+	}
+
+	return []string{
+		fmt.Sprintf(`CREATE TRIGGER undo_%[1]s_insert AFTER INSERT ON %[1]s BEGIN
+			INSERT INTO undo_log (sql) VALUES ('DELETE FROM %[1]s WHERE rowid = ' || NEW.rowid);
+		END;`, table),
+		fmt.Sprintf(`CREATE TRIGGER undo_%[1]s_update AFTER UPDATE ON %[1]s BEGIN
+			INSERT INTO undo_log (sql) VALUES ('UPDATE %[1]s SET %[2]s WHERE rowid = ' || OLD.rowid);
+		END;`, table, strings.Join(set, ", ")),
+		fmt.Sprintf(`CREATE TRIGGER undo_%[1]s_delete BEFORE DELETE ON %[1]s BEGIN
+			INSERT INTO undo_log (sql) VALUES ('INSERT INTO %[1]s (%[2]s) VALUES (%[3]s)');
+		END;`, table, insertColumns, insertValues),
+	}
+}
+
+// Завершает операцию, которая меняет данные: если она удалась, всё
+// записанное в журнал с прошлого шага становится новым шагом
+func (s *sqliteStore) undoStep(ctx context.Context, err error, format string, args ...any) error {
+	if err != nil {
+		return err
+	}
+	return s.recordUndoStep(ctx, fmt.Sprintf(format, args...))
+}
+
+func (s *sqliteStore) recordUndoStep(ctx context.Context, description string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var pending bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM undo_log WHERE step_id IS NULL);").Scan(&pending)
+	if err != nil || !pending {
+		return err
+	}
+
+	// Новое изменение делает повтор отменённых шагов невозможным
+	if _, err := tx.ExecContext(ctx, "DELETE FROM undo_steps WHERE undone;"); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO undo_steps (description) VALUES (?);", description)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE undo_log SET step_id = ? WHERE step_id IS NULL;", id); err != nil {
+		return err
+	}
+	if err := signAuditEntries(ctx, tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM undo_steps WHERE id <= ?;", id-maxUndoSteps); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Отменяет последний шаг и возвращает его описание
+func (s *sqliteStore) undo(ctx context.Context) (string, error) {
+	return s.replayUndoStep(ctx, false)
+}
+
+// Повторяет последний отменённый шаг и возвращает его описание
+func (s *sqliteStore) redo(ctx context.Context) (string, error) {
+	return s.replayUndoStep(ctx, true)
+}
+
+func (s *sqliteStore) replayUndoStep(ctx context.Context, redo bool) (string, error) {
+	// Изменения, сделанные в обход undoStep (например, SQL-скриптом),
+	// иначе попали бы в отменяемый шаг
+	if err := s.recordUndoStep(ctx, "Прочие изменения"); err != nil {
+		return "", err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// Запросы шага идут в порядке, в котором срабатывали триггеры, и
+	// при каскадных удалениях дочерние строки могут вернуться раньше
+	// родительских, так что ключи проверяются только при COMMIT
+	if _, err := tx.ExecContext(ctx, "PRAGMA defer_foreign_keys = ON;"); err != nil {
+		return "", err
+	}
+
+	var id int
+	var description string
+	query := "SELECT id, description FROM undo_steps WHERE NOT undone ORDER BY id DESC LIMIT 1;"
+	if redo {
+		query = "SELECT id, description FROM undo_steps WHERE undone ORDER BY id LIMIT 1;"
+	}
+	err = tx.QueryRowContext(ctx, query).Scan(&id, &description)
+	if err == sql.ErrNoRows {
+		if redo {
+			return "", fmt.Errorf("Нечего повторять")
+		}
+		return "", fmt.Errorf("Нечего отменять")
+	} else if err != nil {
+		return "", err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT sql FROM undo_log WHERE step_id = ? ORDER BY seq DESC;", id)
+	if err != nil {
+		return "", err
+	}
+	var queries []string
+	for rows.Next() {
+		var q string
+		if err := rows.Scan(&q); err != nil {
+			rows.Close()
+			return "", err
+		}
+		queries = append(queries, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM undo_log WHERE step_id = ?;", id); err != nil {
+		return "", err
+	}
+	for _, q := range queries {
+		if _, err := tx.ExecContext(ctx, q); err != nil {
+			return "", fmt.Errorf("не удалось вернуть \"%s\": %w", description, err)
+		}
+	}
+
+	// Триггеры записали запросы, которые вернут шаг обратно
+	if _, err := tx.ExecContext(ctx, "UPDATE undo_log SET step_id = ? WHERE step_id IS NULL;", id); err != nil {
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE undo_steps SET undone = ? WHERE id = ?;", !redo, id); err != nil {
+		return "", err
+	}
+	if err := signAuditEntries(ctx, tx); err != nil {
+		return "", err
+	}
+
+	return description, tx.Commit()
+}
+
+// История шагов, самые новые первыми
+func (s *sqliteStore) getUndoHistory(ctx context.Context) ([]UndoStep, error) {
+	var steps []UndoStep
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, description, time, undone FROM undo_steps ORDER BY id DESC;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		step := UndoStep{}
+		if err := rows.Scan(&step.ID, &step.Description, &step.Time, &step.Undone); err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+
+	return steps, rows.Err()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Горутина, через которую интерфейс работает с базой, чтобы медленный
+// запрос или заблокированная база не останавливали отрисовку. Запросы
+// выполняются по одному в порядке отправки, поэтому ответ на чтение,
+// отправленное после изменения, уже видит это изменение. Ответы
+// копятся в очереди и разбираются в начале кадра в потоке интерфейса
+// (main.go закрепляет его за основным потоком ОС)
+type dbWorker struct {
+	requests chan func()
+	stopped chan struct{}
+
+	// Хранилище принадлежит горутине воркера, интерфейс обращается к
+	// нему только через запросы
+	store *cachedStore
+	ctx context.Context
+
+	mu sync.Mutex
+	replies []func()
+
+	// Остальные поля меняются только в потоке интерфейса
+
+	// Сколько отправленных со вкладки запросов ещё не выполнено
+	pending map[Tab]int
+	closed bool
+}
+
+// Запросы, не относящиеся ни к одной вкладке: меню, окна, справочники
+const noTab Tab = -1
+
+// onChange вызывается в потоке интерфейса после каждого изменения данных
+func startWorker(store Store, onChange func(DataKind)) *dbWorker {
+	w := &dbWorker{
+		// С запасом, чтобы отправка из кадра не ждала воркер
+		requests: make(chan func(), 256),
+		stopped: make(chan struct{}),
+		store: newCachedStore(store),
+		ctx: context.Background(),
+		pending: make(map[Tab]int),
+	}
+	w.store.onChange(func(kinds DataKind) {
+		w.reply(func() { onChange(kinds) })
+	})
+
+	go func() {
+		for request := range w.requests {
+			request()
+		}
+		w.store.close()
+		close(w.stopped)
+	}()
+	return w
+}
+
+// Выполняет query в горутине воркера, а done с её результатом — в потоке
+// интерфейса. Пока ответа нет, вкладка tab считается занятой
+func request[T any](w *dbWorker, tab Tab, query func(s *cachedStore, ctx context.Context) (T, error), done func(T, error)) {
+	w.pending[tab]++
+	w.requests <- func() {
+		result, err := query(w.store, w.ctx)
+		w.reply(func() {
+			w.pending[tab]--
+			done(result, err)
+		})
+	}
+}
+
+func (w *dbWorker) reply(f func()) {
+	w.mu.Lock()
+	w.replies = append(w.replies, f)
+	w.mu.Unlock()
+}
+
+// Разбирает накопившиеся ответы, вызывается в начале кадра
+func (w *dbWorker) processReplies() {
+	w.mu.Lock()
+	replies := w.replies
+	w.replies = nil
+	w.mu.Unlock()
+
+	for _, f := range replies {
+		// Ответ мог остановить воркер (переключение базы), остальные
+		// ответы относятся к старой базе
+		if w.closed {
+			return
+		}
+		f()
+	}
+}
+
+func (w *dbWorker) busy(tab Tab) bool {
+	return w.pending[tab] > 0
+}
+
+func (w *dbWorker) anyBusy() bool {
+	for _, n := range w.pending {
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Больше запросов не принимает. Уже отправленные выполняются, но ответы
+// на них не разбираются, потом хранилище закрывается. Возвращаемый канал
+// закрывается, когда всё это сделано
+func (w *dbWorker) stop() <-chan struct{} {
+	w.closed = true
+	close(w.requests)
+	return w.stopped
+}
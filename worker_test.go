@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// Ждёт, пока воркер выполнит отправленные запросы, и разбирает ответы,
+// как это делает кадр интерфейса
+func flushWorker(w *dbWorker) {
+	done := make(chan struct{})
+	w.requests <- func() { close(done) }
+	<-done
+	w.processReplies()
+}
+
+func TestWorker(t *testing.T) {
+	var changes []DataKind
+	w := startWorker(newMemStore(), func(kinds DataKind) { changes = append(changes, kinds) })
+
+	var log []string
+	request(w, TabCountries, func(s *cachedStore, ctx context.Context) (struct{}, error) {
+		return struct{}{}, s.addCountry(ctx, "RUS", "Россия")
+	}, func(_ struct{}, err error) {
+		must(t, err)
+		log = append(log, "add")
+	})
+	request(w, TabCountries, (*cachedStore).getCountries, func(countries []Country, err error) {
+		must(t, err)
+		// Чтение отправлено после изменения и должно его видеть
+		if len(countries) != 1 {
+			t.Errorf("страны: %v", countries)
+		}
+		log = append(log, "get")
+	})
+
+	if !w.busy(TabCountries) || w.busy(TabSports) || !w.anyBusy() {
+		t.Error("вкладка должна быть занята, пока нет ответа")
+	}
+	if len(log) != 0 {
+		t.Error("ответы разобраны до processReplies")
+	}
+
+	flushWorker(w)
+	if len(log) != 2 || log[0] != "add" || log[1] != "get" {
+		t.Errorf("порядок ответов: %v", log)
+	}
+	if len(changes) != 1 || changes[0] != DataCountries {
+		t.Errorf("уведомления об изменениях: %v", changes)
+	}
+	if w.anyBusy() {
+		t.Error("вкладка занята после ответа")
+	}
+
+	// Отправленное до остановки выполняется, но ответы уже не разбираются
+	executed := false
+	request(w, noTab, func(s *cachedStore, ctx context.Context) (struct{}, error) {
+		executed = true
+		return struct{}{}, nil
+	}, func(struct{}, error) {
+		t.Error("ответ разобран после остановки")
+	})
+	<-w.stop()
+	w.processReplies()
+	if !executed {
+		t.Error("запрос, отправленный до остановки, не выполнен")
+	}
+}